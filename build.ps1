@@ -5,10 +5,11 @@
 #   .\build.ps1              # Build all (server + windows client)
 #   .\build.ps1 -Target server
 #   .\build.ps1 -Target client
+#   .\build.ps1 -Target cli     # Headless client for linux/amd64
 # ============================================================================
 
 param(
-    [ValidateSet("all", "server", "client")]
+    [ValidateSet("all", "server", "client", "cli")]
     [string]$Target = "all"
 )
 
@@ -21,6 +22,7 @@ New-Item -ItemType Directory -Force -Path $BIN_DIR | Out-Null
 $FRONTEND_DIR = Join-Path $ROOT "cmd\client-wails\frontend"
 $CLIENT_DIR   = Join-Path $ROOT "cmd\client-wails"
 $SERVER_DIR   = Join-Path $ROOT "cmd\server"
+$CLI_DIR      = Join-Path $ROOT "cmd\client"
 
 # ── Helper ──────────────────────────────────────────────────────────────────
 function Write-Step($msg) { Write-Host "`n>>> $msg" -ForegroundColor Cyan }
@@ -45,6 +47,26 @@ function Build-Server {
     Write-Host "  -> $outPath" -ForegroundColor Green
 }
 
+# ── Build Headless Client (linux/amd64) ─────────────────────────────────────
+function Build-CLI {
+    Write-Step "Building headless client for linux/amd64..."
+
+    $env:GOOS   = "linux"
+    $env:GOARCH = "amd64"
+    $env:CGO_ENABLED = "0"
+
+    $outPath = Join-Path $BIN_DIR "client-linux-amd64"
+    go build -trimpath -ldflags="-s -w" -o $outPath $CLI_DIR
+
+    # Reset env
+    Remove-Item Env:\GOOS
+    Remove-Item Env:\GOARCH
+    Remove-Item Env:\CGO_ENABLED
+
+    if ($LASTEXITCODE -ne 0) { throw "Headless client build failed" }
+    Write-Host "  -> $outPath" -ForegroundColor Green
+}
+
 # ── Build Frontend ──────────────────────────────────────────────────────────
 function Build-Frontend {
     Write-Step "Building frontend..."
@@ -85,6 +107,10 @@ if ($Target -eq "all" -or $Target -eq "server") {
     Build-Server
 }
 
+if ($Target -eq "all" -or $Target -eq "cli") {
+    Build-CLI
+}
+
 if ($Target -eq "all" -or $Target -eq "client") {
     Build-Frontend
     Build-Client-Windows
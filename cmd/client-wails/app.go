@@ -2,55 +2,74 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
-	"os"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/hashicorp/yamux"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"ssh-forwarder/pkg/protocol"
-)
-
-// Global State
-var (
-	sshClient    *ssh.Client
-	yamuxSession *yamux.Session
-	// Map of localPort -> Listener
-	listeners   map[string]net.Listener
-	listenersMu sync.Mutex
-	mu          sync.Mutex
+	"ssh-forwarder/pkg/tunnel"
 )
 
 // App struct
 type App struct {
-	ctx context.Context
+	ctx      context.Context
+	sessions *SessionManager
 }
 
 // ConnectRequest holds SSH connection details
 type ConnectRequest struct {
-	Host      string `json:"host"`
-	User      string `json:"username"`
-	Pass      string `json:"password"`
-	KeyPath   string `json:"keyPath"`
-	AgentPath string `json:"agentPath"`
+	// ProfileID names the session; connecting again with the same ID
+	// replaces it, other sessions are left running
+	ProfileID     string `json:"profileId"`
+	Host          string `json:"host"`
+	User          string `json:"username"`
+	Pass          string `json:"password"`
+	KeyPath       string `json:"keyPath"`
+	KeyPassphrase string `json:"keyPassphrase"`
+	AgentPath     string `json:"agentPath"`
+	TrustHostKey  string `json:"trustHostKey"` // Fingerprint of an unknown host key the user accepted
+	TrustHost     string `json:"trustHost"`    // Hop TrustHostKey was accepted for (HostKeyInfo.Host); empty means the target
+	// JumpHosts are bastions to tunnel through, in order (like ProxyJump)
+	JumpHosts []JumpHost `json:"jumpHosts"`
+	// SSHConfig is ssh_config text pasted on the login screen; it is
+	// consulted before ~/.ssh/config when Host is an alias
+	SSHConfig string `json:"sshConfig"`
+}
+
+// JumpHost is one bastion in a ConnectRequest chain, with its own credentials.
+type JumpHost struct {
+	Host          string `json:"host"`
+	User          string `json:"username"`
+	Pass          string `json:"password"`
+	KeyPath       string `json:"keyPath"`
+	KeyPassphrase string `json:"keyPassphrase"`
+}
+
+// HostKeyInfo describes a server host key that needs the user's attention.
+type HostKeyInfo struct {
+	Host        string `json:"host"`
+	KeyType     string `json:"keyType"`
+	Fingerprint string `json:"fingerprint"`
+	Status      string `json:"status"` // "unknown" (may be accepted) or "changed" (rejected)
 }
 
 type ConnectResponse struct {
-	Success bool                        `json:"success"`
-	Error   string                      `json:"error,omitempty"`
-	Config  *protocol.HandshakeResponse `json:"config,omitempty"`
+	Success            bool                        `json:"success"`
+	Error              string                      `json:"error,omitempty"`
+	PassphraseRequired bool                        `json:"passphraseRequired,omitempty"` // Key is encrypted, ask the user and retry
+	HostKey            *HostKeyInfo                `json:"hostKey,omitempty"`            // Set when the host key is unknown or changed
+	Config             *protocol.HandshakeResponse `json:"config,omitempty"`
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	listeners = make(map[string]net.Listener)
-	return &App{}
+	return &App{sessions: NewSessionManager()}
 }
 
 // startup is called when the app starts. The context is saved
@@ -59,207 +78,153 @@ func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 }
 
-// ConnectSSH establishes the SSH connection and handshake
-
-// ConnectSSH establishes the SSH connection and handshake
+// ConnectSSH establishes the SSH connection and handshake for the
+// request's profile
 func (a *App) ConnectSSH(req ConnectRequest) ConnectResponse {
-	mu.Lock()
-	defer mu.Unlock()
-
-	// Cleanup previous
-	a.disconnectLocked()
-
-	// Reset metrics
-	atomic.StoreUint64(&globalMetrics.BytesSent, 0)
-	atomic.StoreUint64(&globalMetrics.BytesReceived, 0)
-
-	err := connectSSH(req)
-	if err != nil {
-		return ConnectResponse{Success: false, Error: err.Error()}
-	}
-
-	resp, err := performHandshake()
+	session := a.sessions.Open(a, req.ProfileID)
+	resp, err := session.Connect(resolveRequest(req))
 	if err != nil {
-		a.disconnectLocked()
-		return ConnectResponse{Success: false, Error: err.Error()}
+		// A failed reconnect leaves the profile's working session alone
+		if !session.active() {
+			a.sessions.Remove(session)
+		}
+		return ConnectResponse{
+			Success:            false,
+			Error:              err.Error(),
+			PassphraseRequired: errors.Is(err, tunnel.ErrPassphraseRequired),
+			HostKey:            hostKeyInfo(err),
+		}
 	}
 
 	return ConnectResponse{Success: true, Config: resp}
 }
 
-// Disconnect closes the SSH session and all listeners
-func (a *App) Disconnect() bool {
-	mu.Lock()
-	defer mu.Unlock()
-	a.disconnectLocked()
+// Disconnect closes the profile's SSH session and all its listeners
+func (a *App) Disconnect(profileID string) bool {
+	session := a.sessions.Get(profileID)
+	if session == nil {
+		return false
+	}
+	session.Disconnect()
+	a.sessions.Remove(session)
 	return true
 }
 
-func (a *App) disconnectLocked() {
-	// Stop all listeners first
-	listenersMu.Lock()
-	for port, ln := range listeners {
-		ln.Close()
-		delete(listeners, port)
+// ListSessions describes every open session
+func (a *App) ListSessions() []SessionInfo {
+	infos := []SessionInfo{}
+	for _, session := range a.sessions.List() {
+		infos = append(infos, session.Info())
 	}
-	listenersMu.Unlock()
+	return infos
+}
 
-	if yamuxSession != nil {
-		yamuxSession.Close()
-		yamuxSession = nil
-	}
-	if sshClient != nil {
-		sshClient.Close()
-		sshClient = nil
+// StartForward starts a local listener that forwards traffic to the remote
+// target through the profile's session. Network is "tcp" (or empty) or "udp"
+func (a *App) StartForward(profileID, localPort, target, network string) (string, error) {
+	session := a.sessions.Get(profileID)
+	if session == nil {
+		return "", fmt.Errorf("Not connected")
 	}
+	return session.StartForward(localPort, target, network)
 }
 
-// StartForward starts a local listener that forwards traffic to the remote target
-func (a *App) StartForward(localPort, target string) (string, error) {
-	// If user specifically requested a port (not :0), check if we already have it tracked
-	if localPort != ":0" && localPort != "0" {
-		listenersMu.Lock()
-		if _, exists := listeners[localPort]; exists {
-			listenersMu.Unlock()
-			return "", fmt.Errorf("Port already in use by this app")
-		}
-		listenersMu.Unlock()
+// StartSOCKS starts a local SOCKS5 proxy on the profile's connection and
+// returns its bound address. It is stopped with StopForward.
+func (a *App) StartSOCKS(profileID, localAddr string) (string, error) {
+	session := a.sessions.Get(profileID)
+	if session == nil {
+		return "", fmt.Errorf("Not connected")
 	}
+	return session.StartSOCKS(localAddr)
+}
 
-	ln, err := net.Listen("tcp", localPort)
-	if err != nil {
-		return "", fmt.Errorf("Failed to listen: %v", err)
+// StartHTTPProxy starts a local HTTP proxy routing service hostnames on
+// the profile's connection and returns its bound address. Its PAC file is
+// served at /proxy.pac. It is stopped with StopForward.
+func (a *App) StartHTTPProxy(profileID, localAddr string) (string, error) {
+	session := a.sessions.Get(profileID)
+	if session == nil {
+		return "", fmt.Errorf("Not connected")
 	}
-	
-	boundAddr := ln.Addr().String()
-
-	listenersMu.Lock()
-	listeners[boundAddr] = ln
-	listenersMu.Unlock()
-
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				return // Listener closed
-			}
-			go handleForwarding(conn, target)
-		}
-	}()
-
-	return boundAddr, nil
+	return session.StartHTTPProxy(localAddr)
 }
 
-// StopForward stops the listener on the given local port
-func (a *App) StopForward(localPort string) bool {
-	listenersMu.Lock()
-	defer listenersMu.Unlock()
-
-	ln, exists := listeners[localPort]
-	if !exists {
+// StopForward stops the profile's listener on the given local address
+func (a *App) StopForward(profileID, localPort string) bool {
+	session := a.sessions.Get(profileID)
+	if session == nil {
 		return false
 	}
-
-	ln.Close()
-	delete(listeners, localPort)
-	return true
+	return session.StopForward(localPort)
 }
 
-func handleForwarding(localConn net.Conn, target string) {
-	defer localConn.Close()
-
-	// Safety check
-	if yamuxSession == nil {
-		return
-	}
-
-	// Open Yamux stream
-	stream, err := yamuxSession.Open()
-	if err != nil {
-		// Log error?
-		return
-	}
-	defer stream.Close()
-
-	// Send Connect Request
-	req := protocol.ConnectRequest{Target: target}
-	msg := protocol.Message{Type: protocol.MsgTypeConnect, Payload: req}
-	if err := json.NewEncoder(stream).Encode(msg); err != nil {
-		return
-	}
-
-	// Wait for Connect Response
-	var resp protocol.ConnectResponse
-	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
-		return
+// StartReverseForward asks the profile's agent to listen on remoteAddr and
+// forwards each accepted connection to localTarget. Returns the bound
+// remote address
+func (a *App) StartReverseForward(profileID, remoteAddr, localTarget string) (string, error) {
+	session := a.sessions.Get(profileID)
+	if session == nil {
+		return "", fmt.Errorf("Not connected")
 	}
+	return session.StartReverseForward(remoteAddr, localTarget)
+}
 
-	if !resp.Success {
-		// Target connection failed
-		return
+// StopReverseForward stops the profile's reverse forward on remoteAddr
+func (a *App) StopReverseForward(profileID, remoteAddr string) bool {
+	session := a.sessions.Get(profileID)
+	if session == nil {
+		return false
 	}
-
-	// Pipe data
-	// Use CountedConn logic? No, global metrics tracks TOTAL bandwidth.
-	// Since we wrap the SSH connection, all encrypted traffic is counted.
-	// Payload traffic is inside SSH.
-	// If the user wants "Application Throughput", we should count here.
-	// If "Network Usage", we count at SSH level.
-	// Let's count here (Application payload) for "Bandwidth" as it's more useful for "what is this forwarder doing".
-	// The SSH wrapper counts overhead too.
-	// Actually, let's stick to the plan: Wrap the SSH connection (CountedConn) to get TRUE network usage.
-	
-	done := make(chan struct{}, 2)
-	go func() {
-		io.Copy(stream, localConn) // Upload
-		done <- struct{}{}
-	}()
-	go func() {
-		io.Copy(localConn, stream) // Download
-		done <- struct{}{}
-	}()
-	<-done
+	return session.StopReverseForward(remoteAddr)
 }
 
 // TestConnectionResult holds the result of a connection test
 type TestConnectionResult struct {
-	Success   bool   `json:"success"`
-	Error     string `json:"error,omitempty"`
-	Latency   string `json:"latency,omitempty"`   // e.g. "120ms"
-	SSHBanner string `json:"sshBanner,omitempty"` // server SSH banner
+	Success            bool         `json:"success"`
+	Error              string       `json:"error,omitempty"`
+	Latency            string       `json:"latency,omitempty"`            // e.g. "120ms"
+	SSHBanner          string       `json:"sshBanner,omitempty"`          // server SSH banner
+	PassphraseRequired bool         `json:"passphraseRequired,omitempty"` // Key is encrypted, ask the user and retry
+	Fingerprint        string       `json:"fingerprint,omitempty"`        // SHA256 fingerprint of the server host key
+	HostKey            *HostKeyInfo `json:"hostKey,omitempty"`            // Set when the host key is unknown or changed
 }
 
 // TestConnection attempts SSH dial+auth, reports result, then disconnects.
 // This follows industry standard: verify TCP reachability → SSH handshake → auth → disconnect.
 func (a *App) TestConnection(req ConnectRequest) TestConnectionResult {
 	start := time.Now()
+	req = resolveRequest(req)
 
-	// 1. Build SSH config
-	auths := []ssh.AuthMethod{}
-	if req.Pass != "" {
-		auths = append(auths, ssh.Password(req.Pass))
+	// 1. Build SSH config for every hop
+	var verifier *tunnel.HostKeyVerifier
+	newVerifier := func(host string) *tunnel.HostKeyVerifier {
+		verifier = req.hostKeyVerifier(host) // The target is built last
+		return verifier
 	}
-
-	config := &ssh.ClientConfig{
-		User:            req.User,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-		BannerCallback: func(message string) error {
-			// Capture banner but don't block
-			return nil
-		},
+	hops, agentConn, err := sshHops(req, a.LoadSettings(), a.askChallenge, newVerifier, 10*time.Second)
+	if err != nil {
+		return TestConnectionResult{
+			Success:            false,
+			Error:              classifySSHError(err),
+			PassphraseRequired: errors.Is(err, tunnel.ErrPassphraseRequired),
+		}
 	}
 
-	// 2. Attempt SSH connection (covers TCP dial + SSH handshake + auth)
-	client, err := ssh.Dial("tcp", req.Host, config)
+	// 2. Attempt SSH connection through the whole chain (covers TCP dial + SSH handshake + auth)
+	client, err := tunnel.DialChain(hops)
 	latency := time.Since(start).Round(time.Millisecond).String()
+	if agentConn != nil {
+		agentConn.Close()
+	}
 
 	if err != nil {
 		return TestConnectionResult{
-			Success: false,
-			Error:   classifySSHError(err),
-			Latency: latency,
+			Success:     false,
+			Error:       classifySSHError(err),
+			Latency:     latency,
+			Fingerprint: verifier.Fingerprint(),
+			HostKey:     hostKeyInfo(err),
 		}
 	}
 
@@ -270,16 +235,70 @@ func (a *App) TestConnection(req ConnectRequest) TestConnectionResult {
 	client.Close()
 
 	return TestConnectionResult{
-		Success:   true,
-		Latency:   latency,
-		SSHBanner: banner,
+		Success:     true,
+		Latency:     latency,
+		SSHBanner:   banner,
+		Fingerprint: verifier.Fingerprint(),
 	}
 }
 
+// hostKeyVerifier checks the keys of host, one hop of req, against
+// ~/.ssh/known_hosts and the app-managed known_hosts file, accepting an
+// unknown key only if the user trusted it for that hop.
+func (req ConnectRequest) hostKeyVerifier(host string) *tunnel.HostKeyVerifier {
+	trustHost := req.TrustHost
+	if trustHost == "" {
+		trustHost = req.Host
+	}
+	v := &tunnel.HostKeyVerifier{
+		Files:   []string{tunnel.DefaultKnownHostsFile()},
+		AppFile: tunnel.AppKnownHostsFile(),
+	}
+	if host == trustHost {
+		v.TrustFingerprint = req.TrustHostKey
+	}
+	return v
+}
+
+// hostKeyInfo extracts host key details for the frontend, or nil if err is
+// not a host key problem.
+func hostKeyInfo(err error) *HostKeyInfo {
+	var unknown *tunnel.UnknownHostKeyError
+	if errors.As(err, &unknown) {
+		return &HostKeyInfo{Host: unknown.Host, KeyType: unknown.KeyType, Fingerprint: unknown.Fingerprint, Status: "unknown"}
+	}
+	var changed *tunnel.HostKeyChangedError
+	if errors.As(err, &changed) {
+		return &HostKeyInfo{Host: changed.Host, KeyType: changed.KeyType, Fingerprint: changed.Fingerprint, Status: "changed"}
+	}
+	return nil
+}
+
 // classifySSHError returns a user-friendly error message
 func classifySSHError(err error) string {
+	var hop *tunnel.HopError
+	if errors.As(err, &hop) {
+		return fmt.Sprintf("[%s] %s", hop.Host, classifySSHError(hop.Err))
+	}
+
 	msg := err.Error()
+	var unknown *tunnel.UnknownHostKeyError
+	var changed *tunnel.HostKeyChangedError
 	switch {
+	case errors.As(err, &unknown):
+		return fmt.Sprintf("未知主机密钥: 请核对指纹 %s 后确认信任", unknown.Fingerprint)
+	case errors.As(err, &changed):
+		return fmt.Sprintf("主机密钥已变更: 可能存在中间人攻击 (%s)", changed)
+	case errors.Is(err, tunnel.ErrPassphraseRequired):
+		return "私钥已加密: 请输入密码短语"
+	case errors.Is(err, tunnel.ErrChallengeFailed):
+		return "认证失败: 未完成交互式验证 (如动态验证码)"
+	case contains(msg, "read key"):
+		return fmt.Sprintf("无法读取私钥: %s", msg)
+	case contains(msg, "decrypt key"):
+		return "私钥解密失败: 密码短语错误"
+	case contains(msg, "parse key"):
+		return fmt.Sprintf("无法解析私钥: %s", msg)
 	case contains(msg, "connection refused"):
 		return "连接被拒绝: 目标主机未开放 SSH 服务"
 	case contains(msg, "i/o timeout") || contains(msg, "deadline exceeded"):
@@ -287,7 +306,7 @@ func classifySSHError(err error) string {
 	case contains(msg, "no route to host"):
 		return "网络不可达: 无法路由到目标主机"
 	case contains(msg, "unable to authenticate") || contains(msg, "handshake failed"):
-		return "认证失败: 用户名或密码错误"
+		return "认证失败: 用户名、密码或密钥错误"
 	case contains(msg, "no supported methods remain"):
 		return "认证失败: 服务器不支持密码认证"
 	default:
@@ -299,11 +318,10 @@ func contains(s, substr string) bool {
 	return strings.Contains(s, substr)
 }
 
-// GetStatus returns the current connection status
-func (a *App) GetStatus() bool {
-	mu.Lock()
-	defer mu.Unlock()
-	return sshClient != nil && yamuxSession != nil && !yamuxSession.IsClosed()
+// GetStatus returns the connection status of the profile's session
+func (a *App) GetStatus(profileID string) bool {
+	session := a.sessions.Get(profileID)
+	return session != nil && session.Connected()
 }
 
 // Helper functions (same as hybrid client)
@@ -311,110 +329,112 @@ func (a *App) GetStatus() bool {
 // CountedConn wraps net.Conn to track bytes
 type CountedConn struct {
 	net.Conn
+	metrics *Metrics
 }
 
 func (c *CountedConn) Read(b []byte) (n int, err error) {
 	n, err = c.Conn.Read(b)
-	addReceived(uint64(n))
+	c.metrics.addReceived(uint64(n))
 	return
 }
 
 func (c *CountedConn) Write(b []byte) (n int, err error) {
 	n, err = c.Conn.Write(b)
-	addSent(uint64(n))
+	c.metrics.addSent(uint64(n))
 	return
 }
 
-func connectSSH(req ConnectRequest) error {
-	auths := []ssh.AuthMethod{}
-	if req.Pass != "" {
-		auths = append(auths, ssh.Password(req.Pass))
-	}
-	// Add key support if needed
-
-	config := &ssh.ClientConfig{
-		User:            req.User,
-		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
-	}
-
-	// Use custom dialer to wrap connection for metrics
-	conn, err := net.DialTimeout("tcp", req.Host, config.Timeout)
-	if err != nil {
-		return err
-	}
-
-	// Wrap connection
-	countedConn := &CountedConn{Conn: conn}
-
-	// Establish SSH connection
-	c, chans, reqs, err := ssh.NewClientConn(countedConn, req.Host, config)
-	if err != nil {
-		conn.Close()
-		return err
+// sshHops describes every SSH server to dial for req: its jump hosts in
+// order, then the target. Each hop authenticates with its own credentials
+// and verifies its host key with its own verifier from newVerifier; all
+// share one ssh-agent connection, which the returned closer, if non-nil,
+// releases once auth is done.
+func sshHops(req ConnectRequest, settings AppSettings, challenge ssh.KeyboardInteractiveChallenge, newVerifier func(host string) *tunnel.HostKeyVerifier, timeout time.Duration) ([]tunnel.Config, io.Closer, error) {
+	keyring, agentConn := dialSSHAgent(settings)
+
+	targets := []ConnectRequest{}
+	for _, jump := range req.JumpHosts {
+		targets = append(targets, ConnectRequest{
+			Host:          jump.Host,
+			User:          jump.User,
+			Pass:          jump.Pass,
+			KeyPath:       jump.KeyPath,
+			KeyPassphrase: jump.KeyPassphrase,
+		})
 	}
-	client := ssh.NewClient(c, chans, reqs)
-	
-	sshClient = client
-
-	session, err := client.NewSession()
-	if err != nil {
-		return err
+	targets = append(targets, req)
+
+	hops := []tunnel.Config{}
+	for _, target := range targets {
+		verifier := newVerifier(target.Host)
+		hostKeyCallback, err := verifier.Callback()
+		if err != nil {
+			if agentConn != nil {
+				agentConn.Close()
+			}
+			return nil, nil, err
+		}
+		auths, err := authMethods(target, keyring, challenge)
+		if err != nil {
+			if agentConn != nil {
+				agentConn.Close()
+			}
+			if len(targets) > 1 {
+				err = fmt.Errorf("%s: %w", target.Host, err)
+			}
+			return nil, nil, err
+		}
+		hops = append(hops, tunnel.Config{
+			Host:              target.Host,
+			User:              target.User,
+			Auth:              auths,
+			HostKeyCallback:   hostKeyCallback,
+			HostKeyAlgorithms: verifier.HostKeyAlgorithms(target.Host),
+			Timeout:           timeout,
+		})
 	}
+	return hops, agentConn, nil
+}
 
-	stdin, _ := session.StdinPipe()
-	stdout, _ := session.StdoutPipe()
-	stderr, _ := session.StderrPipe()
-	go io.Copy(os.Stderr, stderr)
-
-	agent := req.AgentPath
-	if agent == "" {
-		agent = "./server-agent"
+// dialSSHAgent connects to the ssh-agent if enabled in settings. The
+// returned closer, if non-nil, releases the agent connection.
+func dialSSHAgent(settings AppSettings) (agent.Agent, io.Closer) {
+	if !settings.UseSSHAgent {
+		return nil, nil
 	}
-	cmd := fmt.Sprintf("%s --stdio", agent)
-	if err := session.Start(cmd); err != nil {
-		return err
+	socket := tunnel.AgentSocket(settings.SSHAgentSocket)
+	if socket == "" {
+		return nil, nil
 	}
-
-	rwConn := &stdioRWC{Reader: stdout, WriteCloser: stdin}
-	cfg := yamux.DefaultConfig()
-	cfg.EnableKeepAlive = true
-	ysess, err := yamux.Client(rwConn, cfg)
+	keyring, closer, err := tunnel.DialAgent(socket)
 	if err != nil {
-		return err
+		log.Printf("ssh-agent unavailable: %v", err)
+		return nil, nil
 	}
-	yamuxSession = ysess
-	return nil
+	return keyring, closer
 }
 
-func performHandshake() (*protocol.HandshakeResponse, error) {
-	stream, err := yamuxSession.Open()
-	if err != nil {
-		return nil, err
-	}
-	defer stream.Close()
-
-	req := protocol.HandshakeRequest{Version: "2.0"}
-	msg := protocol.Message{Type: protocol.MsgTypeHandshake, Payload: req}
-	if err := json.NewEncoder(stream).Encode(msg); err != nil {
-		return nil, err
+// authMethods builds the SSH auth chain for one host: the private key (if
+// any) and ssh-agent identities are offered before the password, followed
+// by keyboard-interactive where password prompts are answered from req and
+// any other prompt is passed to challenge.
+func authMethods(req ConnectRequest, keyring agent.Agent, challenge ssh.KeyboardInteractiveChallenge) ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	if req.KeyPath != "" {
+		signer, err := tunnel.LoadSigner(req.KeyPath, req.KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
 	}
 
-	var resp protocol.HandshakeResponse
-	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
-		return nil, err
+	auths := []ssh.AuthMethod{}
+	if len(signers) > 0 || keyring != nil {
+		auths = append(auths, tunnel.PublicKeyAuth(signers, keyring))
 	}
-
-	if resp.Error != "" {
-		return nil, fmt.Errorf("Server Error: %s", resp.Error)
+	if req.Pass != "" {
+		auths = append(auths, ssh.Password(req.Pass))
 	}
-	return &resp, nil
+	auths = append(auths, ssh.KeyboardInteractive(tunnel.AutoAnswer(req.Pass, "", challenge)))
+	return auths, nil
 }
-
-type stdioRWC struct {
-	io.Reader
-	io.WriteCloser
-}
-
-func (c *stdioRWC) Close() error { return c.WriteCloser.Close() }
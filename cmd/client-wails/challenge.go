@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ssh-forwarder/pkg/tunnel"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// EventAuthChallenge is emitted when the server asks keyboard-interactive
+// questions (e.g. an OTP code) that cannot be answered automatically.
+// The frontend replies with AnswerChallenge.
+const EventAuthChallenge = "ssh:challenge"
+
+// challengeTimeout bounds how long a login waits for the user to answer.
+const challengeTimeout = 2 * time.Minute
+
+// AuthChallenge is the payload of EventAuthChallenge
+type AuthChallenge struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Instruction string            `json:"instruction"`
+	Prompts     []ChallengePrompt `json:"prompts"`
+}
+
+type ChallengePrompt struct {
+	Prompt string `json:"prompt"`
+	Echo   bool   `json:"echo"` // false for secrets that should be masked
+}
+
+var (
+	challenges   = make(map[string]chan []string)
+	challengesMu sync.Mutex
+	challengeSeq uint64
+)
+
+// askChallenge forwards keyboard-interactive questions to the frontend and
+// blocks until they are answered, cancelled or time out.
+func (a *App) askChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if a.ctx == nil {
+		return nil, fmt.Errorf("%w: no UI available", tunnel.ErrChallengeFailed)
+	}
+
+	id := fmt.Sprintf("challenge-%d", atomic.AddUint64(&challengeSeq, 1))
+	reply := make(chan []string, 1)
+	challengesMu.Lock()
+	challenges[id] = reply
+	challengesMu.Unlock()
+	defer func() {
+		challengesMu.Lock()
+		delete(challenges, id)
+		challengesMu.Unlock()
+	}()
+
+	challenge := AuthChallenge{ID: id, Name: name, Instruction: instruction}
+	for i, q := range questions {
+		challenge.Prompts = append(challenge.Prompts, ChallengePrompt{Prompt: q, Echo: echos[i]})
+	}
+	runtime.EventsEmit(a.ctx, EventAuthChallenge, challenge)
+
+	select {
+	case answers := <-reply:
+		if len(answers) != len(questions) {
+			return nil, fmt.Errorf("%w: cancelled", tunnel.ErrChallengeFailed)
+		}
+		return answers, nil
+	case <-time.After(challengeTimeout):
+		return nil, fmt.Errorf("%w: timed out", tunnel.ErrChallengeFailed)
+	}
+}
+
+// AnswerChallenge delivers the user's answers to a pending challenge.
+// Sending no answers cancels the login.
+func (a *App) AnswerChallenge(id string, answers []string) bool {
+	challengesMu.Lock()
+	reply, ok := challenges[id]
+	challengesMu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case reply <- answers:
+		return true
+	default:
+		return false
+	}
+}
@@ -3,27 +3,70 @@ import { main } from '../../wailsjs/go/models';
 
 // Define Frontend-friendly interface with optional fields
 export interface FrontendConnectRequest {
+    profileId?: string;
     host: string;
     username: string;
     password?: string;
     keyPath?: string;
+    keyPassphrase?: string;
     agentPath?: string;
+    trustHostKey?: string;
+    trustHost?: string;
+    jumpHosts?: FrontendJumpHost[];
+    sshConfig?: string;
+}
+
+// A bastion to tunnel through; unset credentials default to the ~/.ssh/config
+// entry for the host, then to the target's.
+export interface FrontendJumpHost {
+    host: string;
+    username?: string;
+    password?: string;
+    keyPath?: string;
+    keyPassphrase?: string;
 }
 
 export type ConnectResponse = main.ConnectResponse;
 export type TestConnectionResult = main.TestConnectionResult;
 
+// Map to Wails struct (fill defaults)
+function toWailsRequest(req: FrontendConnectRequest): main.ConnectRequest {
+    return main.ConnectRequest.createFrom({
+        profileId: req.profileId || "",
+        host: req.host,
+        username: req.username,
+        password: req.password || "",
+        keyPath: req.keyPath || "",
+        keyPassphrase: req.keyPassphrase || "",
+        agentPath: req.agentPath || "",
+        trustHostKey: req.trustHostKey || "",
+        trustHost: req.trustHost || "",
+        jumpHosts: (req.jumpHosts || []).map(j => ({
+            host: j.host,
+            username: j.username || "",
+            password: j.password ?? req.password ?? "",
+            keyPath: j.keyPath ?? req.keyPath ?? "",
+            keyPassphrase: j.keyPassphrase ?? req.keyPassphrase ?? "",
+        })),
+        sshConfig: req.sshConfig || "",
+    });
+}
+
+// Parses a ProxyJump-style list such as "admin@bastion:22, jump2" into
+// jump hosts. Hosts may be ~/.ssh/config aliases; the backend fills in the
+// port (default 22) and other unset details.
+export function parseJumpHosts(spec: string): FrontendJumpHost[] {
+    return spec.split(",").map(s => s.trim()).filter(Boolean).map(entry => {
+        const at = entry.lastIndexOf("@");
+        const username = at >= 0 ? entry.slice(0, at) : undefined;
+        const host = at >= 0 ? entry.slice(at + 1) : entry;
+        return { host, username };
+    });
+}
+
 export async function connectV2(req: FrontendConnectRequest): Promise<ConnectResponse> {
     try {
-        // Map to Wails struct (fill defaults)
-        const wailsReq: main.ConnectRequest = {
-            host: req.host,
-            username: req.username,
-            password: req.password || "",
-            keyPath: req.keyPath || "",
-            agentPath: req.agentPath || "",
-        };
-        return await ConnectSSH(wailsReq);
+        return await ConnectSSH(toWailsRequest(req));
     } catch (e) {
         return { success: false, error: String(e) } as ConnectResponse;
     }
@@ -31,22 +74,15 @@ export async function connectV2(req: FrontendConnectRequest): Promise<ConnectRes
 
 export async function testConnection(req: FrontendConnectRequest): Promise<TestConnectionResult> {
     try {
-        const wailsReq: main.ConnectRequest = {
-            host: req.host,
-            username: req.username,
-            password: req.password || "",
-            keyPath: req.keyPath || "",
-            agentPath: req.agentPath || "",
-        };
-        return await TestConnection(wailsReq);
+        return await TestConnection(toWailsRequest(req));
     } catch (e) {
         return { success: false, error: String(e) } as TestConnectionResult;
     }
 }
 
-export async function getStatus(): Promise<{ connected: boolean }> {
+export async function getStatus(profileId: string): Promise<{ connected: boolean }> {
     try {
-        const connected = await GetStatus();
+        const connected = await GetStatus(profileId);
         return { connected };
     } catch {
         return { connected: false };
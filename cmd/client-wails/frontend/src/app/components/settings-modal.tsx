@@ -192,6 +192,42 @@ export function SettingsModal({ isOpen, onClose }: SettingsModalProps) {
                                     />
                                 </button>
                             </div>
+
+                            <div className="flex items-center justify-between">
+                                <div>
+                                    <Label className={`text-sm font-medium ${isDark ? 'text-gray-300' : 'text-slate-700'}`}>
+                                        {t.useSshAgent}
+                                    </Label>
+                                    <p className={`text-xs ${isDark ? 'text-gray-500' : 'text-slate-500'}`}>
+                                        {t.useSshAgentHint}
+                                    </p>
+                                </div>
+                                <button
+                                    onClick={() => update("useSshAgent", !settings.useSshAgent)}
+                                    className={`toggle-track relative w-11 h-6 rounded-full ${settings.useSshAgent ? "bg-blue-600" : isDark ? "bg-gray-600" : "bg-slate-300"
+                                        }`}
+                                >
+                                    <span
+                                        className={`toggle-thumb absolute top-0.5 left-0.5 w-5 h-5 bg-white rounded-full shadow ${settings.useSshAgent ? "translate-x-5" : "translate-x-0"
+                                            }`}
+                                    />
+                                </button>
+                            </div>
+
+                            {settings.useSshAgent && (
+                                <div className="space-y-1.5">
+                                    <Label className={`text-sm font-medium ${isDark ? 'text-gray-300' : 'text-slate-700'}`}>
+                                        {t.sshAgentSocket}
+                                    </Label>
+                                    <Input
+                                        type="text"
+                                        value={settings.sshAgentSocket}
+                                        onChange={(e) => update("sshAgentSocket", e.target.value)}
+                                        placeholder="$SSH_AUTH_SOCK"
+                                        className={`h-9 font-mono text-sm ${isDark ? 'bg-gray-700 border-gray-600 text-gray-100' : 'border-slate-300'}`}
+                                    />
+                                </div>
+                            )}
                         </div>
                     </div>
 
@@ -1,13 +1,13 @@
-import { Settings, Terminal, ChevronDown, Loader2, Network, Check, Play, Square, Activity, Trash2, Edit2, MoreVertical, Info } from "lucide-react";
+import { Settings, Terminal, ChevronDown, Loader2, Network, Check, Play, Square, Activity, Trash2, Edit2, MoreVertical, Info, Download, Plus } from "lucide-react";
 import { Button } from "./ui/button";
 import { Input } from "./ui/input";
 import { Label } from "./ui/label";
 import { Textarea } from "./ui/textarea";
 import { useState, useEffect, useRef } from "react";
-import { connectV2, testConnection } from "../api";
-import { WindowMinimise, WindowMaximise, WindowUnmaximise, WindowIsMaximised, Quit } from "../../../wailsjs/runtime/runtime";
-import { Disconnect, StartForward, StopForward, GetMetrics, LoadSettings } from "../../../wailsjs/go/main/App";
-import { main } from "../../../wailsjs/go/models";
+import { connectV2, parseJumpHosts, testConnection } from "../api";
+import { WindowMinimise, WindowMaximise, WindowUnmaximise, WindowIsMaximised, Quit, EventsOn } from "../../../wailsjs/runtime/runtime";
+import { Disconnect, StartForward, StopForward, StartReverseForward, StopReverseForward, StartSOCKS, StartHTTPProxy, GetMetrics, LoadSettings, AnswerChallenge, ListSSHHosts } from "../../../wailsjs/go/main/App";
+import { main, protocol } from "../../../wailsjs/go/models";
 import { SettingsModal } from "./settings-modal";
 import { useSettings } from "../settings-context";
 
@@ -19,12 +19,75 @@ interface SavedConnection {
   username: string;
 }
 
+interface AuthChallenge {
+  id: string;
+  name: string;
+  instruction: string;
+  prompts: { prompt: string; echo: boolean }[];
+}
+
+// Pushed by the backend connection supervisor ("connection:state")
+interface ConnectionState {
+  profileId: string;
+  state: "connected" | "reconnecting" | "disconnected" | "draining";
+  attempt?: number;
+  retryInMs?: number;
+  error?: string;
+  config?: protocol.HandshakeResponse;
+  failed?: Record<string, string>; // local address -> rebind error
+}
+
+// Pushed when the server reloads its configuration ("config:update")
+interface ConfigUpdate {
+  profileId: string;
+  config: protocol.HandshakeResponse;
+  stopped?: string[]; // Bound addresses of forwards whose target was removed
+}
+
+// A connected profile as shown in the UI
+interface SessionView {
+  host: string;
+  username: string;
+  ports: PortForward[];
+  forwarding: Record<string, string>; // port.name -> boundAddress (absent if stopped)
+  reconnecting: boolean;
+  allowedBinds: string[]; // Remote addresses the server lets reverse forwards claim
+  reverse: Record<string, ReverseView>; // Requested remote address -> reverse forward
+  socks?: string; // Bound address of the SOCKS5 proxy, if running
+  httpProxy?: string; // Bound address of the HTTP proxy, if running
+}
+
+// Local proxies a session can run, by their SessionView field
+type ProxyKind = "socks" | "httpProxy";
+const PROXY_KINDS: ProxyKind[] = ["socks", "httpProxy"];
+
+interface ReverseView {
+  bound: string; // Address the server is listening on
+  local: string;
+}
+
 interface PortForward {
   name: string;
   target: string;
   description?: string;
   static?: boolean;
   local_port?: number;
+  network?: string; // "udp" or undefined for TCP
+  type?: string; // Reported by the agent: "tcp", "udp" or "unix"
+  health?: protocol.PortHealth; // Latest health check by the agent, if any
+}
+
+function toPortForwards(ports: protocol.PortConfig[]): PortForward[] {
+  return ports.map(p => ({
+    name: p.name,
+    target: p.target,
+    description: p.description,
+    static: (p as any).static,
+    local_port: (p as any).local_port,
+    network: p.network,
+    type: p.type,
+    health: p.health
+  }));
 }
 
 // Storage helpers
@@ -48,9 +111,12 @@ export function SSHLogin() {
 
   // Connection form state
   const [host, setHost] = useState("127.0.0.1");
-  const [port, setPort] = useState("22");
+  const [port, setPort] = useState(""); // Empty: port from ~/.ssh/config, else 22
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
+  const [keyPath, setKeyPath] = useState("");
+  const [keyPassphrase, setKeyPassphrase] = useState("");
+  const [jumpHosts, setJumpHosts] = useState("");
   const [sshConfig, setSshConfig] = useState("");
   const [saveConnection, setSaveConnection] = useState(false);
   const [connectionName, setConnectionName] = useState("");
@@ -61,24 +127,178 @@ export function SSHLogin() {
   const [status, setStatus] = useState("");
   const [statusKey, setStatusKey] = useState(0);
   const [isMaximized, setIsMaximized] = useState(false);
-  const [isConnected, setIsConnected] = useState(false);
   const [isSettingsOpen, setIsSettingsOpen] = useState(false);
 
   // Data state
   const [savedConnections, setSavedConnections] = useState<SavedConnection[]>([]);
-  const [forwardedPorts, setForwardedPorts] = useState<PortForward[]>([]);
+
+  // Open sessions by profile ID; the active one is shown in the main panel
+  const [sessions, setSessions] = useState<Record<string, SessionView>>({});
+  const [activeProfile, setActiveProfile] = useState<string | null>(null);
+  const active = activeProfile ? sessions[activeProfile] : undefined;
+  const isConnected = !!active;
+  const isReconnecting = !!active?.reconnecting;
+  const forwardedPorts = active?.ports || [];
+  const forwardingStatus = active?.forwarding || {};
+  const reverseForwards = active?.reverse || {};
+  const allowedBinds = active?.allowedBinds || [];
+  const [reverseRemote, setReverseRemote] = useState("");
+  const [reverseLocal, setReverseLocal] = useState("");
+  const [proxyAddrs, setProxyAddrs] = useState<Record<ProxyKind, string>>({
+    socks: "127.0.0.1:1080",
+    httpProxy: "127.0.0.1:8118",
+  });
 
   // New features state
-  const [forwardingStatus, setForwardingStatus] = useState<Record<string, string>>({}); // port.name -> boundAddress (empty if stopped)
   const [metrics, setMetrics] = useState<main.Metrics>(new main.Metrics());
   const [contextMenu, setContextMenu] = useState<{ x: number, y: number, conn: SavedConnection } | null>(null);
   const contextMenuRef = useRef<HTMLDivElement>(null);
 
+  const updateSession = (id: string, update: (s: SessionView) => SessionView) => {
+    setSessions(prev => prev[id] ? { ...prev, [id]: update(prev[id]) } : prev);
+  };
+
+  const removeSession = (id: string) => {
+    setSessions(prev => {
+      const next = { ...prev };
+      delete next[id];
+      return next;
+    });
+    setActiveProfile(prev => prev === id ? null : prev);
+  };
+
   // Load saved connections on mount
   useEffect(() => {
     setSavedConnections(loadSavedConnections());
   }, []);
 
+  // Keyboard-interactive prompts (e.g. OTP codes) raised during login
+  useEffect(() => {
+    return EventsOn("ssh:challenge", (c: AuthChallenge) => {
+      const header = [c.name, c.instruction].filter(Boolean).join("\n");
+      const answers: string[] = [];
+      for (const p of c.prompts) {
+        const answer = prompt(header ? `${header}\n\n${p.prompt}` : p.prompt);
+        if (answer === null) {
+          AnswerChallenge(c.id, []);
+          return;
+        }
+        answers.push(answer);
+      }
+      AnswerChallenge(c.id, answers);
+    });
+  }, []);
+
+  // Connection drops and automatic reconnects
+  useEffect(() => {
+    return EventsOn("connection:state", (s: ConnectionState) => {
+      const id = s.profileId;
+      setStatusKey(k => k + 1);
+      switch (s.state) {
+        case "reconnecting":
+          updateSession(id, v => ({ ...v, reconnecting: true }));
+          setStatus(`[${id}] ${t.reconnecting} (#${s.attempt}, ${Math.ceil((s.retryInMs || 0) / 1000)}s): ${s.error}`);
+          break;
+        case "connected": {
+          const failed = s.failed || {};
+          updateSession(id, v => ({
+            ...v,
+            reconnecting: false,
+            ports: s.config?.allowed_ports ? toPortForwards(s.config.allowed_ports) : v.ports,
+            forwarding: Object.fromEntries(
+              Object.entries(v.forwarding).filter(([, addr]) => !(addr in failed))
+            ),
+            allowedBinds: s.config?.allowed_binds || v.allowedBinds,
+            reverse: Object.fromEntries(
+              Object.entries(v.reverse).filter(([remote]) => !(remote in failed))
+            ),
+            socks: v.socks && !(v.socks in failed) ? v.socks : undefined,
+            httpProxy: v.httpProxy && !(v.httpProxy in failed) ? v.httpProxy : undefined,
+          }));
+          const errors = Object.entries(failed).map(([addr, err]) => `${addr}: ${err}`);
+          setStatus(`[${id}] ${errors.length ? `${t.reconnected}; ${errors.join("; ")}` : t.reconnected}`);
+          break;
+        }
+        case "disconnected":
+          removeSession(id);
+          setStatus(`[${id}] ${t.connectionLost}: ${s.error}`);
+          break;
+        case "draining":
+          setStatus(`[${id}] ${t.serverRestarting}`);
+          break;
+      }
+    });
+  }, [t]);
+
+  // Server configuration reloads
+  useEffect(() => {
+    return EventsOn("config:update", (u: ConfigUpdate) => {
+      const id = u.profileId;
+      const stopped = u.stopped || [];
+      updateSession(id, v => ({
+        ...v,
+        ports: toPortForwards(u.config.allowed_ports || []),
+        forwarding: Object.fromEntries(
+          Object.entries(v.forwarding).filter(([, addr]) => !stopped.includes(addr))
+        ),
+        allowedBinds: u.config.allowed_binds || [],
+      }));
+      setStatus(`[${id}] ${stopped.length ? `${t.configUpdated}; ${t.forwardsStopped}: ${stopped.join(", ")}` : t.configUpdated}`);
+      setStatusKey(k => k + 1);
+    });
+  }, [t]);
+
+  // Retries a connect/test call while the backend needs a key passphrase or
+  // the user's confirmation of an unknown host key.
+  const runWithPrompts = async <T extends { passphraseRequired?: boolean; hostKey?: main.HostKeyInfo }>(
+    call: (extra: { keyPassphrase: string; trustHostKey: string; trustHost: string }) => Promise<T>
+  ): Promise<T> => {
+    let extra = { keyPassphrase: keyPassphrase, trustHostKey: "", trustHost: "" };
+    for (;;) {
+      const res = await call(extra);
+      if (res.passphraseRequired) {
+        const passphrase = prompt(t.enterPassphrase) || "";
+        if (!passphrase) return res;
+        setKeyPassphrase(passphrase);
+        extra = { ...extra, keyPassphrase: passphrase };
+        continue;
+      }
+      // Each hop of a jump chain may present its own unknown key; accepted
+      // keys are recorded, so only a new fingerprint needs confirming. The
+      // key is only trusted for the hop that presented it.
+      if (res.hostKey?.status === "unknown" && res.hostKey.fingerprint !== extra.trustHostKey) {
+        const hk = res.hostKey;
+        if (!confirm(`${t.unknownHostKey}\n\n${hk.host}\n${hk.keyType} ${hk.fingerprint}`)) return res;
+        extra = { ...extra, trustHostKey: hk.fingerprint, trustHost: hk.host };
+        continue;
+      }
+      return res;
+    }
+  };
+
+  // host may be a ~/.ssh/config alias; leaving the port empty lets the
+  // backend take it from the config.
+  const fullHost = () => port ? `${host}:${port}` : host;
+
+  // Adds the aliases from ~/.ssh/config as saved connections, keeping
+  // existing entries with the same name.
+  const handleImportSSHConfig = async () => {
+    try {
+      const hosts = await ListSSHHosts();
+      const names = new Set(savedConnections.map(c => c.name));
+      const imported: SavedConnection[] = hosts
+        .filter(h => !names.has(h.alias))
+        .map(h => ({ name: h.alias, host: h.alias, port: "", username: h.username }));
+      const updated = [...savedConnections, ...imported];
+      setSavedConnections(updated);
+      saveConnections(updated);
+      setStatus(`${t.importedSshHosts}: ${imported.length}`);
+      setStatusKey(k => k + 1);
+    } catch (e) {
+      setStatus(`${t.errorPrefix}: ${e}`);
+    }
+  };
+
   const handleLogin = async () => {
     setIsLoading(true);
     setStatus(t.connecting);
@@ -94,26 +314,35 @@ export function SSHLogin() {
         console.warn("Failed to load settings:", e);
       }
 
-      const fullHost = `${host}:${port}`;
-      const res = await connectV2({
-        host: fullHost,
+      // Connecting again under the same profile replaces that session only
+      const profileId = connectionName || `${username}@${fullHost()}`;
+      const res = await runWithPrompts((extra) => connectV2({
+        profileId: profileId,
+        host: fullHost(),
         username: username,
         password: password,
-        agentPath: agentPath
-      });
+        keyPath: keyPath,
+        agentPath: agentPath,
+        jumpHosts: parseJumpHosts(jumpHosts),
+        sshConfig: sshConfig,
+        ...extra
+      }));
 
       if (res.success) {
-        setIsConnected(true);
-        setStatus(`${t.connectedTo} ${host}:${port}`);
-        if (res.config?.allowed_ports) {
-          setForwardedPorts(res.config.allowed_ports.map(p => ({
-            name: p.name,
-            target: p.target,
-            description: p.description,
-            static: (p as any).static,
-            local_port: (p as any).local_port
-          })));
-        }
+        setSessions(prev => ({
+          ...prev,
+          [profileId]: {
+            host: fullHost(),
+            username: username,
+            ports: toPortForwards(res.config?.allowed_ports || []),
+            forwarding: {},
+            reconnecting: false,
+            allowedBinds: res.config?.allowed_binds || [],
+            reverse: {},
+          },
+        }));
+        setActiveProfile(profileId);
+        setStatus(`${t.connectedTo} ${fullHost()}`);
 
         if (saveConnection && connectionName) {
           const newConn: SavedConnection = {
@@ -126,6 +355,8 @@ export function SSHLogin() {
           setSavedConnections(updated);
           saveConnections(updated);
         }
+      } else if (res.hostKey?.status === "changed") {
+        setStatus(`${t.hostKeyChanged}: ${res.hostKey.fingerprint}`);
       } else {
         setStatus(`${t.errorPrefix}: ${res.error}`);
       }
@@ -141,6 +372,9 @@ export function SSHLogin() {
     setPort(conn.port);
     setUsername(conn.username);
     setConnectionName(conn.name);
+    if (sessions[conn.name]) {
+      setActiveProfile(conn.name);
+    }
   };
 
   const handleTestConnection = async () => {
@@ -149,15 +383,18 @@ export function SSHLogin() {
     setStatusKey(k => k + 1);
 
     try {
-      const fullHost = `${host}:${port}`;
-      const res = await testConnection({
-        host: fullHost,
+      const res = await runWithPrompts((extra) => testConnection({
+        host: fullHost(),
         username: username,
-        password: password
-      });
+        password: password,
+        keyPath: keyPath,
+        jumpHosts: parseJumpHosts(jumpHosts),
+        sshConfig: sshConfig,
+        ...extra
+      }));
 
       if (res.success) {
-        setStatus(`${t.testSuccess} (${res.latency}) - ${res.sshBanner || 'SSH Server'}`);
+        setStatus(`${t.testSuccess} (${res.latency}) - ${res.sshBanner || 'SSH Server'} - ${res.fingerprint}`);
       } else {
         setStatus(`${res.error}${res.latency ? ` (${res.latency})` : ''}`);
       }
@@ -194,10 +431,11 @@ export function SSHLogin() {
   // Metrics polling
   useEffect(() => {
     let interval: number;
-    if (isConnected) {
+    setMetrics(new main.Metrics());
+    if (activeProfile) {
       interval = setInterval(async () => {
         try {
-          const m = await GetMetrics();
+          const m = await GetMetrics(activeProfile);
           setMetrics(m);
         } catch (e) {
           console.error(e);
@@ -205,7 +443,7 @@ export function SSHLogin() {
       }, 1000);
     }
     return () => clearInterval(interval);
-  }, [isConnected]);
+  }, [activeProfile]);
 
   // Context menu click outside handler
   useEffect(() => {
@@ -219,23 +457,25 @@ export function SSHLogin() {
   }, []);
 
   const handleDisconnect = async () => {
-    await Disconnect();
-    setIsConnected(false);
+    if (!activeProfile) return;
+    await Disconnect(activeProfile);
+    removeSession(activeProfile);
     setStatus(t.disconnected);
-    setForwardingStatus({});
   };
 
   const handleToggleForward = async (port: PortForward) => {
+    if (!activeProfile) return;
+    const id = activeProfile;
     const currentAddr = forwardingStatus[port.name];
 
     if (currentAddr) {
       // Stop
       try {
-        await StopForward(currentAddr); // Stop using the bound address
-        setForwardingStatus(prev => {
-          const next = { ...prev };
-          delete next[port.name];
-          return next;
+        await StopForward(id, currentAddr); // Stop using the bound address
+        updateSession(id, v => {
+          const forwarding = { ...v.forwarding };
+          delete forwarding[port.name];
+          return { ...v, forwarding };
         });
       } catch (e) {
         console.error("Failed to stop", e);
@@ -244,7 +484,7 @@ export function SSHLogin() {
       // Start
       let bindPort = ":0"; // Default random
 
-      if (port.static) {
+      if (port.static && port.type !== "unix") {
         if (port.local_port && port.local_port > 0) {
           bindPort = `:${port.local_port}`;
         } else {
@@ -257,14 +497,64 @@ export function SSHLogin() {
       }
 
       try {
-        const boundAddr = await StartForward(bindPort, port.target);
-        setForwardingStatus(prev => ({ ...prev, [port.name]: boundAddr }));
+        const boundAddr = await StartForward(id, bindPort, port.target, port.network ?? "");
+        updateSession(id, v => ({ ...v, forwarding: { ...v.forwarding, [port.name]: boundAddr } }));
       } catch (e) {
         setStatus(`${t.errorPrefix}: ${e}`);
       }
     }
   };
 
+  const handleToggleProxy = async (kind: ProxyKind) => {
+    if (!activeProfile) return;
+    const id = activeProfile;
+    const bound = active?.[kind];
+    try {
+      if (bound) {
+        await StopForward(id, bound);
+        updateSession(id, v => ({ ...v, [kind]: undefined }));
+      } else {
+        const start = kind === "socks" ? StartSOCKS : StartHTTPProxy;
+        const boundAddr = await start(id, proxyAddrs[kind]);
+        updateSession(id, v => ({ ...v, [kind]: boundAddr }));
+      }
+    } catch (e) {
+      setStatus(`${t.errorPrefix}: ${e}`);
+      setStatusKey(k => k + 1);
+    }
+  };
+
+  const handleAddReverse = async () => {
+    if (!activeProfile || !reverseRemote || !reverseLocal) return;
+    const id = activeProfile;
+    const remote = reverseRemote;
+    const local = reverseLocal;
+    try {
+      const bound = await StartReverseForward(id, remote, local);
+      updateSession(id, v => ({ ...v, reverse: { ...v.reverse, [remote]: { bound, local } } }));
+      setReverseRemote("");
+      setReverseLocal("");
+    } catch (e) {
+      setStatus(`${t.errorPrefix}: ${e}`);
+      setStatusKey(k => k + 1);
+    }
+  };
+
+  const handleStopReverse = async (remote: string) => {
+    if (!activeProfile) return;
+    const id = activeProfile;
+    try {
+      await StopReverseForward(id, remote);
+      updateSession(id, v => {
+        const reverse = { ...v.reverse };
+        delete reverse[remote];
+        return { ...v, reverse };
+      });
+    } catch (e) {
+      console.error("Failed to stop", e);
+    }
+  };
+
   const handleContextMenu = (e: React.MouseEvent, conn: SavedConnection) => {
     e.preventDefault();
     setContextMenu({ x: e.clientX, y: e.clientY, conn });
@@ -360,10 +650,44 @@ export function SSHLogin() {
         {/* 左侧边栏 */}
         <div className={`w-60 border-r flex flex-col ${isDark ? 'bg-gray-800 border-gray-700' : 'bg-white border-slate-200'
           }`}>
-          <div className={`p-4 border-b ${isDark ? 'border-gray-700' : 'border-slate-200'}`}>
+          {Object.keys(sessions).length > 0 && (
+            <div className={`p-2 border-b space-y-1 ${isDark ? 'border-gray-700' : 'border-slate-200'}`}>
+              <div className={`px-3 pt-2 pb-1 text-xs font-medium ${isDark ? 'text-gray-400' : 'text-slate-500'}`}>
+                {t.activeSessions}
+              </div>
+              {Object.entries(sessions).map(([id, v]) => (
+                <button
+                  key={id}
+                  onClick={() => setActiveProfile(id)}
+                  className={`sidebar-item w-full text-left px-3 py-2 rounded transition-colors flex items-center gap-2 ${id === activeProfile
+                    ? (isDark ? 'bg-gray-700' : 'bg-slate-100')
+                    : (isDark ? 'hover:bg-gray-700' : 'hover:bg-slate-100')
+                    }`}
+                >
+                  <span className={`h-2 w-2 rounded-full flex-shrink-0 ${v.reconnecting ? 'bg-yellow-500' : 'bg-green-500'}`} />
+                  <div className="min-w-0">
+                    <div className={`text-sm font-medium truncate ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
+                      {id}
+                    </div>
+                    <div className={`text-xs truncate ${isDark ? 'text-gray-500' : 'text-slate-500'}`}>
+                      {v.host} · {Object.keys(v.forwarding).length} {t.forwardsActive}
+                    </div>
+                  </div>
+                </button>
+              ))}
+            </div>
+          )}
+          <div className={`p-4 border-b flex items-center justify-between ${isDark ? 'border-gray-700' : 'border-slate-200'}`}>
             <h2 className={`font-medium text-sm ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
               {t.savedConnections}
             </h2>
+            <button
+              onClick={handleImportSSHConfig}
+              title={t.importSshConfig}
+              className={`p-1 rounded transition-colors ${isDark ? 'text-gray-400 hover:bg-gray-700' : 'text-slate-500 hover:bg-slate-100'}`}
+            >
+              <Download className="h-4 w-4" />
+            </button>
           </div>
           <div className="flex-1 p-2 space-y-1 overflow-auto">
             {savedConnections.length === 0 ? (
@@ -383,7 +707,7 @@ export function SSHLogin() {
                     {conn.name}
                   </div>
                   <div className={`text-xs ${isDark ? 'text-gray-500' : 'text-slate-500'}`}>
-                    {conn.host}:{conn.port}
+                    {conn.port ? `${conn.host}:${conn.port}` : conn.host}
                   </div>
                   <div className="absolute right-2 top-1/2 -translate-y-1/2 opacity-0 group-hover:opacity-100 transition-opacity">
                     <MoreVertical className={`h-4 w-4 ${isDark ? 'text-gray-400' : 'text-slate-400'}`} />
@@ -408,17 +732,23 @@ export function SSHLogin() {
                     </div>
                     <div>
                       <h2 className={`font-semibold ${isDark ? 'text-gray-100' : 'text-slate-900'}`}>
-                        {t.connectedTo} {host}:{port}
+                        {t.connectedTo} {active?.host}
                       </h2>
                       <p className={`text-sm ${isDark ? 'text-gray-400' : 'text-slate-500'}`}>
-                        {t.user}: {username}
+                        {t.user}: {active?.username} · {activeProfile}
                       </p>
                     </div>
                   </div>
-                  <Button variant="destructive" size="sm" onClick={handleDisconnect} className="gap-2">
-                    <Terminal className="h-4 w-4" />
-                    {t.disconnect}
-                  </Button>
+                  <div className="flex items-center gap-2">
+                    <Button variant="outline" size="sm" onClick={() => setActiveProfile(null)} className="gap-2">
+                      <Plus className="h-4 w-4" />
+                      {t.newConnection}
+                    </Button>
+                    <Button variant="destructive" size="sm" onClick={handleDisconnect} className="gap-2">
+                      <Terminal className="h-4 w-4" />
+                      {t.disconnect}
+                    </Button>
+                  </div>
                 </div>
                 <div className="p-6">
                   <h3 className={`font-medium mb-4 flex items-center gap-2 ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
@@ -436,21 +766,37 @@ export function SSHLogin() {
                       {forwardedPorts.map((port, idx) => {
                         const isRunning = !!forwardingStatus[port.name];
                         const boundAddr = forwardingStatus[port.name];
+                        const isDown = port.health?.status === "down";
                         return (
                           <div key={idx} className={`port-item flex items-center justify-between p-4 rounded-lg border transition-all ${isRunning
                             ? (isDark ? 'bg-blue-900/20 border-blue-700/50' : 'bg-blue-50 border-blue-200')
                             : (isDark ? 'bg-gray-700/30 border-gray-600' : 'bg-slate-50 border-slate-200')
-                            }`}>
+                            } ${isDown ? 'opacity-60 grayscale' : ''}`}>
                             <div className="flex-1">
                               <div className="flex items-center gap-2 mb-1">
                                 <div className={`font-medium ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
                                   {port.name}
                                 </div>
+                                {port.type && port.type !== "tcp" && (
+                                  <span className={`text-[10px] px-1.5 py-0.5 rounded-full font-medium uppercase ${isDark ? 'bg-gray-700 text-gray-300' : 'bg-slate-100 text-slate-600'}`}>
+                                    {port.type}
+                                  </span>
+                                )}
                                 {isRunning && (
                                   <span className={`text-[10px] px-1.5 py-0.5 rounded-full font-medium ${isDark ? 'bg-blue-900 text-blue-200' : 'bg-blue-100 text-blue-700'}`}>
                                     Active
                                   </span>
                                 )}
+                                {isDown && (
+                                  <span title={port.health?.error} className={`text-[10px] px-1.5 py-0.5 rounded-full font-medium ${isDark ? 'bg-red-900 text-red-200' : 'bg-red-100 text-red-700'}`}>
+                                    {t.serviceDown}
+                                  </span>
+                                )}
+                                {port.health?.status === "up" && (
+                                  <span className={`text-[10px] font-mono ${isDark ? 'text-gray-500' : 'text-slate-400'}`}>
+                                    {port.health.latency_ms} ms
+                                  </span>
+                                )}
                               </div>
                               <div className="flex items-center gap-2 text-sm font-mono">
                                 <span className={isDark ? 'text-gray-400' : 'text-slate-500'}>Remote: {port.target}</span>
@@ -468,6 +814,11 @@ export function SSHLogin() {
                                   {port.description}
                                 </div>
                               )}
+                              {isDown && (
+                                <div className={`text-xs mt-1 ${isDark ? 'text-red-400' : 'text-red-600'}`}>
+                                  {t.lastChecked}: {new Date(port.health!.checked_at).toLocaleTimeString()} · {port.health!.error}
+                                </div>
+                              )}
                             </div>
                             <Button
                               size="sm"
@@ -496,6 +847,132 @@ export function SSHLogin() {
                       {t.noForwardPorts}
                     </div>
                   )}
+
+                  <div className="mt-8">
+                    <h3 className={`font-medium mb-4 flex items-center gap-2 ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
+                      <Network className="h-4 w-4" />
+                      {t.localProxies}
+                    </h3>
+                    <div className="space-y-3">
+                      {PROXY_KINDS.map((kind) => {
+                        const bound = active?.[kind];
+                        const scheme = kind === "socks" ? "socks5" : "http";
+                        return (
+                          <div key={kind} className={`p-4 rounded-lg border ${isDark ? 'bg-gray-800 border-gray-700' : 'bg-white border-slate-200'}`}>
+                            <div className="flex items-center justify-between gap-2">
+                              <div className="flex-1 min-w-0">
+                                <div className={`font-medium mb-1 ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
+                                  {kind === "socks" ? t.socksProxy : t.httpProxy}
+                                </div>
+                                {bound ? (
+                                  <span className={`text-sm font-mono font-semibold ${isDark ? 'text-green-400' : 'text-green-600'}`}>
+                                    {t.socksListening} {scheme}://{bound}
+                                  </span>
+                                ) : (
+                                  <Input
+                                    value={proxyAddrs[kind]}
+                                    onChange={(e) => setProxyAddrs(a => ({ ...a, [kind]: e.target.value }))}
+                                    className="font-mono max-w-xs"
+                                  />
+                                )}
+                              </div>
+                              <Button
+                                size="sm"
+                                variant={bound ? "destructive" : "default"}
+                                onClick={() => handleToggleProxy(kind)}
+                                disabled={(!bound && !proxyAddrs[kind]) || isReconnecting}
+                                className={bound ? "" : "bg-blue-600 hover:bg-blue-700"}
+                              >
+                                {bound ? (
+                                  <>
+                                    <Square className="h-3 w-3 mr-1.5 fill-current" />
+                                    {t.stopForward}
+                                  </>
+                                ) : (
+                                  <>
+                                    <Play className="h-3 w-3 mr-1.5 fill-current" />
+                                    {t.startForward}
+                                  </>
+                                )}
+                              </Button>
+                            </div>
+                            <div className={`mt-3 text-xs flex items-start gap-2 ${isDark ? 'text-blue-200' : 'text-blue-700'}`}>
+                              <Info className="h-4 w-4 flex-shrink-0" />
+                              <span className="leading-relaxed opacity-90">
+                                {kind === "socks" ? t.socksInfo : (
+                                  <>
+                                    {t.httpProxyInfo} <span className="font-mono select-all">http://{bound || proxyAddrs.httpProxy}/proxy.pac</span>
+                                  </>
+                                )}
+                              </span>
+                            </div>
+                          </div>
+                        );
+                      })}
+                    </div>
+                  </div>
+
+                  {allowedBinds.length > 0 && (
+                    <div className="mt-8">
+                      <h3 className={`font-medium mb-4 flex items-center gap-2 ${isDark ? 'text-gray-200' : 'text-slate-900'}`}>
+                        <Network className="h-4 w-4 rotate-180" />
+                        {t.reverseForwards}
+                      </h3>
+
+                      <div className={`mb-4 p-3 rounded-lg text-xs flex items-start gap-2 ${isDark ? 'bg-blue-900/20 text-blue-200' : 'bg-blue-50 text-blue-700'}`}>
+                        <Info className="h-4 w-4 flex-shrink-0 mt-0.5" />
+                        <span className="leading-relaxed opacity-90">
+                          {t.reverseForwardInfo} {t.allowedBinds}: <span className="font-mono">{allowedBinds.join(", ")}</span>
+                        </span>
+                      </div>
+
+                      <div className="flex items-end gap-2 mb-3">
+                        <div className="flex-1 space-y-1">
+                          <Label className="text-xs">{t.remoteBind}</Label>
+                          <Input
+                            placeholder="127.0.0.1:9000"
+                            value={reverseRemote}
+                            onChange={(e) => setReverseRemote(e.target.value)}
+                            className="font-mono"
+                          />
+                        </div>
+                        <div className="flex-1 space-y-1">
+                          <Label className="text-xs">{t.localTarget}</Label>
+                          <Input
+                            placeholder="127.0.0.1:3000"
+                            value={reverseLocal}
+                            onChange={(e) => setReverseLocal(e.target.value)}
+                            className="font-mono"
+                          />
+                        </div>
+                        <Button
+                          size="sm"
+                          onClick={handleAddReverse}
+                          disabled={!reverseRemote || !reverseLocal || isReconnecting}
+                          className="bg-blue-600 hover:bg-blue-700"
+                        >
+                          <Plus className="h-3 w-3 mr-1.5" />
+                          {t.addReverse}
+                        </Button>
+                      </div>
+
+                      <div className="space-y-2">
+                        {Object.entries(reverseForwards).map(([remote, r]) => (
+                          <div key={remote} className={`flex items-center justify-between p-3 rounded-lg border ${isDark ? 'bg-gray-800 border-gray-700' : 'bg-white border-slate-200'}`}>
+                            <div className="flex items-center gap-2 text-sm font-mono">
+                              <span className={isDark ? 'text-gray-400' : 'text-slate-500'}>Remote: {r.bound}</span>
+                              <span className={isDark ? 'text-gray-600' : 'text-slate-400'}>→</span>
+                              <span className={`font-semibold ${isDark ? 'text-green-400' : 'text-green-600'}`}>Local: {r.local}</span>
+                            </div>
+                            <Button size="sm" variant="secondary" onClick={() => handleStopReverse(remote)}>
+                              <Square className="h-3 w-3 mr-1.5 fill-current" />
+                              {t.stopForward}
+                            </Button>
+                          </div>
+                        ))}
+                      </div>
+                    </div>
+                  )}
                 </div>
               </div>
             </div>
@@ -569,6 +1046,34 @@ export function SSHLogin() {
                         />
                       </div>
 
+                      <div className="space-y-2">
+                        <Label htmlFor="keyPath" className={`text-sm font-medium ${isDark ? 'text-gray-300' : 'text-slate-700'}`}>
+                          {t.privateKey}
+                        </Label>
+                        <Input
+                          id="keyPath"
+                          type="text"
+                          placeholder={t.privateKeyHint}
+                          value={keyPath}
+                          onChange={(e) => { setKeyPath(e.target.value); setKeyPassphrase(""); }}
+                          className={`h-9 font-mono text-xs ${isDark ? 'bg-gray-700 border-gray-600 text-gray-100 placeholder:text-gray-500' : 'border-slate-300'}`}
+                        />
+                      </div>
+
+                      <div className="space-y-2">
+                        <Label htmlFor="jumpHosts" className={`text-sm font-medium ${isDark ? 'text-gray-300' : 'text-slate-700'}`}>
+                          {t.jumpHosts}
+                        </Label>
+                        <Input
+                          id="jumpHosts"
+                          type="text"
+                          placeholder={t.jumpHostsHint}
+                          value={jumpHosts}
+                          onChange={(e) => setJumpHosts(e.target.value)}
+                          className={`h-9 font-mono text-xs ${isDark ? 'bg-gray-700 border-gray-600 text-gray-100 placeholder:text-gray-500' : 'border-slate-300'}`}
+                        />
+                      </div>
+
                       <div className="space-y-2">
                         <Label className={`text-sm font-medium ${isDark ? 'text-gray-300' : 'text-slate-700'}`}>
                           {t.authMethod}
@@ -674,7 +1179,7 @@ export function SSHLogin() {
               </span>
             </div>
           )}
-          <span>{isConnected ? t.connected : t.disconnected}</span>
+          <span>{isReconnecting ? t.reconnecting : isConnected ? t.connected : t.disconnected}</span>
         </div>
       </div>
 
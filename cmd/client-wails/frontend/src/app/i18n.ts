@@ -13,6 +13,11 @@ export interface Translations {
 
     // Sidebar
     savedConnections: string;
+    activeSessions: string;
+    newConnection: string;
+    forwardsActive: string;
+    importSshConfig: string;
+    importedSshHosts: string;
     noSavedConnections: string;
 
     // Connection form
@@ -21,6 +26,14 @@ export interface Translations {
     hostAddress: string;
     username: string;
     password: string;
+    privateKey: string;
+    privateKeyHint: string;
+    jumpHosts: string;
+    jumpHostsHint: string;
+    keyPassphrase: string;
+    enterPassphrase: string;
+    unknownHostKey: string;
+    hostKeyChanged: string;
     authMethod: string;
     passwordAuth: string;
     sshConfigOptional: string;
@@ -40,6 +53,20 @@ export interface Translations {
     startForward: string;
     stopForward: string;
     noForwardPorts: string;
+    serviceDown: string;
+    lastChecked: string;
+    reverseForwards: string;
+    reverseForwardInfo: string;
+    remoteBind: string;
+    localTarget: string;
+    addReverse: string;
+    allowedBinds: string;
+    socksProxy: string;
+    socksInfo: string;
+    socksListening: string;
+    httpProxy: string;
+    httpProxyInfo: string;
+    localProxies: string;
     disconnect: string;
 
     // Saved connections menu
@@ -51,6 +78,12 @@ export interface Translations {
     ready: string;
     connected: string;
     disconnected: string;
+    reconnecting: string;
+    serverRestarting: string;
+    reconnected: string;
+    configUpdated: string;
+    forwardsStopped: string;
+    connectionLost: string;
     upload: string;
     download: string;
     forwardingInfo: string;
@@ -78,6 +111,9 @@ export interface Translations {
     lanShare: string;
     autoReconnect: string;
     autoReconnectHint: string;
+    useSshAgent: string;
+    useSshAgentHint: string;
+    sshAgentSocket: string;
     appearance: string;
     theme: string;
     themeLight: string;
@@ -101,12 +137,25 @@ const zh: Translations = {
     restore: "还原",
     close: "关闭",
     savedConnections: "已保存的连接",
+    activeSessions: "活动会话",
+    newConnection: "新建连接",
+    forwardsActive: "个转发",
+    importSshConfig: "从 ~/.ssh/config 导入",
+    importedSshHosts: "已导入 SSH 主机",
     noSavedConnections: "暂无保存的连接",
     sshConfig: "SSH 连接配置",
     fillServerInfo: "填写服务器连接信息",
     hostAddress: "主机地址",
     username: "用户名",
     password: "密码",
+    privateKey: "私钥文件（可选）",
+    privateKeyHint: "例如 ~/.ssh/id_ed25519",
+    jumpHosts: "跳板机（可选）",
+    jumpHostsHint: "例如 admin@bastion:22, jump2",
+    keyPassphrase: "密钥密码短语",
+    enterPassphrase: "私钥已加密，请输入密码短语",
+    unknownHostKey: "首次连接此主机，请核对主机密钥指纹后确认是否信任：",
+    hostKeyChanged: "主机密钥已变更，连接已拒绝（可能存在中间人攻击）",
     authMethod: "认证方式",
     passwordAuth: "密码认证",
     sshConfigOptional: "SSH 配置（可选）",
@@ -124,6 +173,20 @@ const zh: Translations = {
     startForward: "开启转发",
     stopForward: "停止转发",
     noForwardPorts: "服务器未配置可转发的端口",
+    serviceDown: "不可用",
+    lastChecked: "上次检查",
+    reverseForwards: "反向转发",
+    reverseForwardInfo: "让服务器上的进程访问本机服务：服务器在允许的地址上监听，并把每个连接转发到本机目标。",
+    remoteBind: "远程监听地址",
+    localTarget: "本地目标",
+    addReverse: "添加",
+    allowedBinds: "允许的地址",
+    socksProxy: "SOCKS5 代理",
+    socksInfo: "浏览器和命令行工具可通过一个本地代理端口访问服务器允许的所有目标。",
+    socksListening: "监听于",
+    httpProxy: "HTTP 代理",
+    httpProxyInfo: "按服务主机名访问 Web 服务（如 http://gitlab-web.tunnel/），将浏览器的自动代理配置 (PAC) 指向：",
+    localProxies: "本地代理",
     disconnect: "断开连接",
     rename: "重命名",
     delete: "删除",
@@ -131,6 +194,12 @@ const zh: Translations = {
     ready: "就绪",
     connected: "已连接",
     disconnected: "未连接",
+    reconnecting: "正在重连",
+    serverRestarting: "服务器正在重启，等待现有连接结束",
+    reconnected: "已重新连接",
+    configUpdated: "服务器配置已更新",
+    forwardsStopped: "已停止转发 (目标被移除)",
+    connectionLost: "连接已断开",
     upload: "上传",
     download: "下载",
     forwardingInfo: "端口转发通过SSH连接在您的本地机器和远程目标之间创建一个安全隧道。它仅转发发送到特定本地端口的流量，不会影响您的系统级网络设置。",
@@ -154,6 +223,9 @@ const zh: Translations = {
     lanShare: "局域网共享",
     autoReconnect: "自动重连",
     autoReconnectHint: "连接断开后自动尝试重新连接",
+    useSshAgent: "使用 ssh-agent",
+    useSshAgentHint: "在密码认证前尝试 ssh-agent 中的密钥",
+    sshAgentSocket: "ssh-agent 套接字路径",
     appearance: "外观",
     theme: "主题",
     themeLight: "浅色",
@@ -175,12 +247,25 @@ const en: Translations = {
     restore: "Restore",
     close: "Close",
     savedConnections: "Saved Connections",
+    activeSessions: "Active Sessions",
+    newConnection: "New Connection",
+    forwardsActive: "forwards",
+    importSshConfig: "Import from ~/.ssh/config",
+    importedSshHosts: "Imported SSH hosts",
     noSavedConnections: "No saved connections",
     sshConfig: "SSH Connection",
     fillServerInfo: "Enter server connection details",
     hostAddress: "Host",
     username: "Username",
     password: "Password",
+    privateKey: "Private Key (optional)",
+    privateKeyHint: "e.g. ~/.ssh/id_ed25519",
+    jumpHosts: "Jump Hosts (optional)",
+    jumpHostsHint: "e.g. admin@bastion:22, jump2",
+    keyPassphrase: "Key passphrase",
+    enterPassphrase: "The private key is encrypted. Enter its passphrase",
+    unknownHostKey: "The authenticity of this host can't be established. Verify the host key fingerprint before trusting it:",
+    hostKeyChanged: "Host key changed, connection refused (possible MITM attack)",
     authMethod: "Auth Method",
     passwordAuth: "Password",
     sshConfigOptional: "SSH Config (optional)",
@@ -198,6 +283,20 @@ const en: Translations = {
     startForward: "Start Forward",
     stopForward: "Stop Forward",
     noForwardPorts: "No forwardable ports configured on server",
+    serviceDown: "Unavailable",
+    lastChecked: "Last checked",
+    reverseForwards: "Reverse Forwards",
+    reverseForwardInfo: "Let processes on the server reach a service on this machine: the server listens on an allowed address and forwards each connection to a local target.",
+    remoteBind: "Remote bind address",
+    localTarget: "Local target",
+    addReverse: "Add",
+    allowedBinds: "Allowed",
+    socksProxy: "SOCKS5 Proxy",
+    socksInfo: "Browsers and CLI tools can reach every target the server allows through one local proxy port.",
+    socksListening: "Listening on",
+    httpProxy: "HTTP Proxy",
+    httpProxyInfo: "Browse web services by hostname (e.g. http://gitlab-web.tunnel/); point the browser's proxy auto-config (PAC) at:",
+    localProxies: "Local Proxies",
     disconnect: "Disconnect",
     rename: "Rename",
     delete: "Delete",
@@ -205,6 +304,12 @@ const en: Translations = {
     ready: "Ready",
     connected: "Connected",
     disconnected: "Disconnected",
+    reconnecting: "Reconnecting",
+    serverRestarting: "Server is restarting, waiting for open connections to finish",
+    reconnected: "Reconnected",
+    configUpdated: "Server configuration updated",
+    forwardsStopped: "stopped forwards (target removed)",
+    connectionLost: "Connection lost",
     upload: "Up",
     download: "Down",
     forwardingInfo: "Port forwarding creates a secure tunnel from your local machine to the remote target. It only forwards traffic sent to the specific local port and does not affect your system-wide network settings.",
@@ -228,6 +333,9 @@ const en: Translations = {
     lanShare: "LAN shared",
     autoReconnect: "Auto Reconnect",
     autoReconnectHint: "Automatically reconnect on disconnect",
+    useSshAgent: "Use ssh-agent",
+    useSshAgentHint: "Offer ssh-agent keys before password auth",
+    sshAgentSocket: "ssh-agent socket path",
     appearance: "Appearance",
     theme: "Theme",
     themeLight: "Light",
@@ -4,7 +4,7 @@ import { main } from '../models';
 
 export function ConnectSSH(arg1: main.ConnectRequest): Promise<main.ConnectResponse>;
 
-export function GetStatus(): Promise<boolean>;
+export function GetStatus(arg1: string): Promise<boolean>;
 
 export function TestConnection(arg1: main.ConnectRequest): Promise<main.TestConnectionResult>;
 
@@ -12,10 +12,24 @@ export function LoadSettings(): Promise<main.AppSettings>;
 
 export function SaveSettings(arg1: main.AppSettings): Promise<boolean>;
 
-export function Disconnect(): Promise<boolean>;
+export function Disconnect(arg1: string): Promise<boolean>;
 
-export function StartForward(arg1: string, arg2: string): Promise<string>;
+export function StartForward(arg1: string, arg2: string, arg3: string, arg4: string): Promise<string>;
 
-export function StopForward(arg1: string): Promise<boolean>;
+export function StartHTTPProxy(arg1: string, arg2: string): Promise<string>;
 
-export function GetMetrics(): Promise<main.Metrics>;
+export function StopForward(arg1: string, arg2: string): Promise<boolean>;
+
+export function StartReverseForward(arg1: string, arg2: string, arg3: string): Promise<string>;
+
+export function StartSOCKS(arg1: string, arg2: string): Promise<string>;
+
+export function StopReverseForward(arg1: string, arg2: string): Promise<boolean>;
+
+export function GetMetrics(arg1: string): Promise<main.Metrics>;
+
+export function AnswerChallenge(arg1: string, arg2: Array<string>): Promise<boolean>;
+
+export function ListSSHHosts(): Promise<Array<main.SSHHost>>;
+
+export function ListSessions(): Promise<Array<main.SessionInfo>>;
@@ -6,8 +6,8 @@ export function ConnectSSH(arg1) {
   return window['go']['main']['App']['ConnectSSH'](arg1);
 }
 
-export function GetStatus() {
-  return window['go']['main']['App']['GetStatus']();
+export function GetStatus(arg1) {
+  return window['go']['main']['App']['GetStatus'](arg1);
 }
 
 export function TestConnection(arg1) {
@@ -22,18 +22,46 @@ export function SaveSettings(arg1) {
   return window['go']['main']['App']['SaveSettings'](arg1);
 }
 
-export function Disconnect() {
-  return window['go']['main']['App']['Disconnect']();
+export function Disconnect(arg1) {
+  return window['go']['main']['App']['Disconnect'](arg1);
 }
 
-export function StartForward(arg1, arg2) {
-  return window['go']['main']['App']['StartForward'](arg1, arg2);
+export function StartForward(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['StartForward'](arg1, arg2, arg3, arg4);
 }
 
-export function StopForward(arg1) {
-  return window['go']['main']['App']['StopForward'](arg1);
+export function StartHTTPProxy(arg1, arg2) {
+  return window['go']['main']['App']['StartHTTPProxy'](arg1, arg2);
 }
 
-export function GetMetrics() {
-  return window['go']['main']['App']['GetMetrics']();
+export function StopForward(arg1, arg2) {
+  return window['go']['main']['App']['StopForward'](arg1, arg2);
+}
+
+export function StartReverseForward(arg1, arg2, arg3) {
+  return window['go']['main']['App']['StartReverseForward'](arg1, arg2, arg3);
+}
+
+export function StartSOCKS(arg1, arg2) {
+  return window['go']['main']['App']['StartSOCKS'](arg1, arg2);
+}
+
+export function StopReverseForward(arg1, arg2) {
+  return window['go']['main']['App']['StopReverseForward'](arg1, arg2);
+}
+
+export function GetMetrics(arg1) {
+  return window['go']['main']['App']['GetMetrics'](arg1);
+}
+
+export function AnswerChallenge(arg1, arg2) {
+  return window['go']['main']['App']['AnswerChallenge'](arg1, arg2);
+}
+
+export function ListSSHHosts() {
+  return window['go']['main']['App']['ListSSHHosts']();
+}
+
+export function ListSessions() {
+  return window['go']['main']['App']['ListSessions']();
 }
@@ -1,11 +1,37 @@
 export namespace main {
 
+	export class JumpHost {
+		host: string;
+		username: string;
+		password: string;
+		keyPath: string;
+		keyPassphrase: string;
+
+		static createFrom(source: any = {}) {
+			return new JumpHost(source);
+		}
+
+		constructor(source: any = {}) {
+			if ('string' === typeof source) source = JSON.parse(source);
+			this.host = source["host"];
+			this.username = source["username"];
+			this.password = source["password"];
+			this.keyPath = source["keyPath"];
+			this.keyPassphrase = source["keyPassphrase"];
+		}
+	}
 	export class ConnectRequest {
+		profileId: string;
 		host: string;
 		username: string;
 		password: string;
 		keyPath: string;
+		keyPassphrase: string;
 		agentPath: string;
+		trustHostKey: string;
+		trustHost: string;
+		jumpHosts: JumpHost[];
+		sshConfig: string;
 
 		static createFrom(source: any = {}) {
 			return new ConnectRequest(source);
@@ -13,16 +39,60 @@ export namespace main {
 
 		constructor(source: any = {}) {
 			if ('string' === typeof source) source = JSON.parse(source);
+			this.profileId = source["profileId"];
 			this.host = source["host"];
 			this.username = source["username"];
 			this.password = source["password"];
 			this.keyPath = source["keyPath"];
+			this.keyPassphrase = source["keyPassphrase"];
 			this.agentPath = source["agentPath"];
+			this.trustHostKey = source["trustHostKey"];
+			this.trustHost = source["trustHost"];
+			this.jumpHosts = this.convertValues(source["jumpHosts"], JumpHost);
+			this.sshConfig = source["sshConfig"];
+		}
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+			if (!a) {
+				return a;
+			}
+			if (a.slice && a.map) {
+				return (a as any[]).map(elem => this.convertValues(elem, classs));
+			} else if ("object" === typeof a) {
+				if (asMap) {
+					for (const key of Object.keys(a)) {
+						a[key] = new classs(a[key]);
+					}
+					return a;
+				}
+				return new classs(a);
+			}
+			return a;
+		}
+	}
+	export class HostKeyInfo {
+		host: string;
+		keyType: string;
+		fingerprint: string;
+		status: string;
+
+		static createFrom(source: any = {}) {
+			return new HostKeyInfo(source);
+		}
+
+		constructor(source: any = {}) {
+			if ('string' === typeof source) source = JSON.parse(source);
+			this.host = source["host"];
+			this.keyType = source["keyType"];
+			this.fingerprint = source["fingerprint"];
+			this.status = source["status"];
 		}
 	}
 	export class ConnectResponse {
 		success: boolean;
 		error?: string;
+		passphraseRequired?: boolean;
+		hostKey?: HostKeyInfo;
 		config?: protocol.HandshakeResponse;
 
 		static createFrom(source: any = {}) {
@@ -33,6 +103,8 @@ export namespace main {
 			if ('string' === typeof source) source = JSON.parse(source);
 			this.success = source["success"];
 			this.error = source["error"];
+			this.passphraseRequired = source["passphraseRequired"];
+			this.hostKey = this.convertValues(source["hostKey"], HostKeyInfo);
 			this.config = this.convertValues(source["config"], protocol.HandshakeResponse);
 		}
 
@@ -59,6 +131,9 @@ export namespace main {
 		error?: string;
 		latency?: string;
 		sshBanner?: string;
+		passphraseRequired?: boolean;
+		fingerprint?: string;
+		hostKey?: HostKeyInfo;
 
 		static createFrom(source: any = {}) {
 			return new TestConnectionResult(source);
@@ -70,6 +145,27 @@ export namespace main {
 			this.error = source["error"];
 			this.latency = source["latency"];
 			this.sshBanner = source["sshBanner"];
+			this.passphraseRequired = source["passphraseRequired"];
+			this.fingerprint = source["fingerprint"];
+			this.hostKey = this.convertValues(source["hostKey"], HostKeyInfo);
+		}
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+			if (!a) {
+				return a;
+			}
+			if (a.slice && a.map) {
+				return (a as any[]).map(elem => this.convertValues(elem, classs));
+			} else if ("object" === typeof a) {
+				if (asMap) {
+					for (const key of Object.keys(a)) {
+						a[key] = new classs(a[key]);
+					}
+					return a;
+				}
+				return new classs(a);
+			}
+			return a;
 		}
 	}
 	export class AppSettings {
@@ -77,6 +173,8 @@ export namespace main {
 		connectionTimeout: number;
 		localBindAddress: string;
 		autoReconnect: boolean;
+		useSshAgent: boolean;
+		sshAgentSocket: string;
 		theme: string;
 		language: string;
 
@@ -90,6 +188,8 @@ export namespace main {
 			this.connectionTimeout = source["connectionTimeout"] || 10;
 			this.localBindAddress = source["localBindAddress"] || "127.0.0.1";
 			this.autoReconnect = source["autoReconnect"] ?? true;
+			this.useSshAgent = source["useSshAgent"] ?? true;
+			this.sshAgentSocket = source["sshAgentSocket"] || "";
 			this.theme = source["theme"] || "light";
 			this.language = source["language"] || "zh";
 		}
@@ -110,14 +210,99 @@ export namespace main {
 		}
 	}
 
+	export class SessionInfo {
+		profileId: string;
+		host: string;
+		username: string;
+		connected: boolean;
+		forwards: string[];
+		reverse: string[];
+
+		static createFrom(source: any = {}) {
+			return new SessionInfo(source);
+		}
+
+		constructor(source: any = {}) {
+			if ('string' === typeof source) source = JSON.parse(source);
+			this.profileId = source["profileId"];
+			this.host = source["host"];
+			this.username = source["username"];
+			this.connected = source["connected"];
+			this.forwards = source["forwards"];
+			this.reverse = source["reverse"];
+		}
+	}
+	export class SSHHost {
+		alias: string;
+		hostName: string;
+		port: string;
+		username: string;
+		identityFile: string;
+		proxyJump: string;
+
+		static createFrom(source: any = {}) {
+			return new SSHHost(source);
+		}
+
+		constructor(source: any = {}) {
+			if ('string' === typeof source) source = JSON.parse(source);
+			this.alias = source["alias"];
+			this.hostName = source["hostName"];
+			this.port = source["port"];
+			this.username = source["username"];
+			this.identityFile = source["identityFile"];
+			this.proxyJump = source["proxyJump"];
+		}
+	}
 }
 
 export namespace protocol {
 
+	export class PortHealth {
+		status: string;
+		// Go type: time
+		checked_at: any;
+		latency_ms: number;
+		error?: string;
+
+		static createFrom(source: any = {}) {
+			return new PortHealth(source);
+		}
+
+		constructor(source: any = {}) {
+			if ('string' === typeof source) source = JSON.parse(source);
+			this.status = source["status"];
+			this.checked_at = this.convertValues(source["checked_at"], null);
+			this.latency_ms = source["latency_ms"];
+			this.error = source["error"];
+		}
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+			if (!a) {
+				return a;
+			}
+			if (a.slice && a.map) {
+				return (a as any[]).map(elem => this.convertValues(elem, classs));
+			} else if ("object" === typeof a) {
+				if (asMap) {
+					for (const key of Object.keys(a)) {
+						a[key] = new classs(a[key]);
+					}
+					return a;
+				}
+				return new classs(a);
+			}
+			return a;
+		}
+	}
 	export class PortConfig {
 		name: string;
 		target: string;
 		description?: string;
+		network?: string;
+		hostname?: string;
+		type?: string;
+		health?: PortHealth;
 
 		static createFrom(source: any = {}) {
 			return new PortConfig(source);
@@ -128,11 +313,34 @@ export namespace protocol {
 			this.name = source["name"];
 			this.target = source["target"];
 			this.description = source["description"];
+			this.network = source["network"];
+			this.hostname = source["hostname"];
+			this.type = source["type"];
+			this.health = this.convertValues(source["health"], PortHealth);
+		}
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+			if (!a) {
+				return a;
+			}
+			if (a.slice && a.map) {
+				return (a as any[]).map(elem => this.convertValues(elem, classs));
+			} else if ("object" === typeof a) {
+				if (asMap) {
+					for (const key of Object.keys(a)) {
+						a[key] = new classs(a[key]);
+					}
+					return a;
+				}
+				return new classs(a);
+			}
+			return a;
 		}
 	}
 	export class HandshakeResponse {
 		version: string;
 		allowed_ports: PortConfig[];
+		allowed_binds?: string[];
 		error?: string;
 
 		static createFrom(source: any = {}) {
@@ -143,6 +351,7 @@ export namespace protocol {
 			if ('string' === typeof source) source = JSON.parse(source);
 			this.version = source["version"];
 			this.allowed_ports = this.convertValues(source["allowed_ports"], PortConfig);
+			this.allowed_binds = source["allowed_binds"];
 			this.error = source["error"];
 		}
 
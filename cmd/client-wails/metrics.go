@@ -7,19 +7,31 @@ type Metrics struct {
 	BytesReceived uint64 `json:"bytesReceived"`
 }
 
-var globalMetrics Metrics
+// GetMetrics returns the traffic counters of the profile's session.
+func (a *App) GetMetrics(profileID string) Metrics {
+	s := a.sessions.Get(profileID)
+	if s == nil {
+		return Metrics{}
+	}
+	return s.metrics.snapshot()
+}
 
-func (a *App) GetMetrics() Metrics {
+func (m *Metrics) snapshot() Metrics {
 	return Metrics{
-		BytesSent:     atomic.LoadUint64(&globalMetrics.BytesSent),
-		BytesReceived: atomic.LoadUint64(&globalMetrics.BytesReceived),
+		BytesSent:     atomic.LoadUint64(&m.BytesSent),
+		BytesReceived: atomic.LoadUint64(&m.BytesReceived),
 	}
 }
 
-func addSent(n uint64) {
-	atomic.AddUint64(&globalMetrics.BytesSent, n)
+func (m *Metrics) reset() {
+	atomic.StoreUint64(&m.BytesSent, 0)
+	atomic.StoreUint64(&m.BytesReceived, 0)
+}
+
+func (m *Metrics) addSent(n uint64) {
+	atomic.AddUint64(&m.BytesSent, n)
 }
 
-func addReceived(n uint64) {
-	atomic.AddUint64(&globalMetrics.BytesReceived, n)
+func (m *Metrics) addReceived(n uint64) {
+	atomic.AddUint64(&m.BytesReceived, n)
 }
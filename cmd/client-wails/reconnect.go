@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"golang.org/x/crypto/ssh"
+	"ssh-forwarder/pkg/protocol"
+	"ssh-forwarder/pkg/tunnel"
+)
+
+// EventConnectionState is emitted with a ConnectionState whenever the
+// supervisor notices a dropped connection or makes progress restoring it.
+const EventConnectionState = "connection:state"
+
+// Connection states reported in ConnectionState.State.
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateDisconnected = "disconnected"
+	StateDraining     = "draining" // The agent is restarting; the connection drops once open streams finish
+)
+
+// errAgentRestarted is why a connection dropped after the agent announced
+// it was draining.
+var errAgentRestarted = errors.New("server restarted")
+
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+	keepaliveInterval  = 15 * time.Second
+	keepaliveTimeout   = 10 * time.Second
+)
+
+// ConnectionState describes a supervisor transition for the frontend.
+type ConnectionState struct {
+	ProfileID string                      `json:"profileId"`
+	State     string                      `json:"state"`
+	Attempt   int                         `json:"attempt,omitempty"`
+	RetryInMs int64                       `json:"retryInMs,omitempty"`
+	Error     string                      `json:"error,omitempty"`
+	Config    *protocol.HandshakeResponse `json:"config,omitempty"`
+	Failed    map[string]string           `json:"failed,omitempty"` // Local (or reverse forward remote) address -> why it could not be restored
+}
+
+// supervise waits for the connection to die, either through the yamux
+// session closing (also when a restarting agent finishes draining) or the
+// SSH keepalive failing, then tries to restore it unless stop is closed
+// first.
+func (s *Session) supervise(stop chan struct{}, client *ssh.Client, session *yamux.Session) {
+	go keepalive(client, session.CloseChan())
+
+	select {
+	case <-stop:
+		return
+	case <-session.CloseChan():
+	}
+	s.reconnect(stop)
+}
+
+// keepalive probes the SSH connection and closes it when the server stops
+// answering, which in turn closes the yamux session.
+func keepalive(client *ssh.Client, done <-chan struct{}) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		result := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			if err == nil {
+				continue
+			}
+			log.Printf("SSH keepalive failed: %v", err)
+		case <-time.After(keepaliveTimeout):
+			log.Printf("SSH keepalive timed out")
+		}
+		client.Close()
+		return
+	}
+}
+
+// reconnect re-establishes the session's connection lost under stop, redoes the
+// handshake, rebinds the listeners that were active on the same addresses
+// and re-requests the reverse forwards. It gives up when AutoReconnect is off, when the user
+// disconnects, or on errors that retrying cannot fix, including a login
+// that would need the user to answer a prompt.
+func (s *Session) reconnect(stop chan struct{}) {
+	s.mu.Lock()
+	if stopped(stop) {
+		s.mu.Unlock()
+		return
+	}
+	s.listenersMu.Lock()
+	for addr, f := range s.listeners {
+		s.pendingForwards[addr] = f
+		f.ln.Close()
+		delete(s.listeners, addr)
+	}
+	for _, r := range s.reverse {
+		r.ln = nil
+	}
+	s.listenersMu.Unlock()
+	s.closeLocked()
+	req := *s.request.Load()
+	s.mu.Unlock()
+
+	err := errors.New("connection lost")
+	if s.draining.Swap(false) {
+		err = errAgentRestarted
+	}
+	settings := s.app.LoadSettings()
+	if !settings.AutoReconnect {
+		s.disconnectAfterDrop(stop, err)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		delay := backoff(attempt)
+		log.Printf("[%s] Connection lost (%v), reconnecting in %v (attempt %d)", s.ID, err, delay, attempt)
+		s.emitState(ConnectionState{
+			State:     StateReconnecting,
+			Attempt:   attempt,
+			RetryInMs: delay.Milliseconds(),
+			Error:     err.Error(),
+		})
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		// Dial without holding mu, so the profile can be disconnected or
+		// queried meanwhile. Nobody may be around to answer prompts, so
+		// a login that needs more than req holds fails instead
+		conn, dialErr := s.dial(req, settings, nil)
+		if dialErr != nil {
+			err = dialErr
+			if !retryable(err) {
+				s.disconnectAfterDrop(stop, err)
+				return
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		if stopped(stop) {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.installLocked(conn)
+
+		s.listenersMu.Lock()
+		forwards := s.pendingForwards
+		s.pendingForwards = make(map[string]*forward)
+		s.listenersMu.Unlock()
+		failed := map[string]string{}
+		for addr, f := range forwards {
+			if _, err := s.startListener(addr, f.target, f.network); err != nil {
+				failed[addr] = err.Error()
+			}
+		}
+		for remote, reason := range s.relistenReverse(conn.mux) {
+			failed[remote] = reason
+		}
+		go s.supervise(stop, conn.client, conn.mux)
+		s.mu.Unlock()
+
+		log.Printf("[%s] Reconnected to %s after %d attempt(s)", s.ID, req.Host, attempt)
+		s.emitState(ConnectionState{State: StateConnected, Attempt: attempt, Config: conn.resp, Failed: failed})
+		return
+	}
+}
+
+// disconnectAfterDrop ends the connection for good, forgets the session
+// and tells the frontend.
+func (s *Session) disconnectAfterDrop(stop chan struct{}, err error) {
+	s.mu.Lock()
+	if stopped(stop) {
+		s.mu.Unlock()
+		return
+	}
+	s.disconnectLocked()
+	s.mu.Unlock()
+	s.app.sessions.Remove(s)
+
+	log.Printf("[%s] Disconnected: %v", s.ID, err)
+	s.emitState(ConnectionState{State: StateDisconnected, Error: classifySSHError(err)})
+}
+
+func (s *Session) emitState(state ConnectionState) {
+	state.ProfileID = s.ID
+	if s.app.ctx != nil {
+		runtime.EventsEmit(s.app.ctx, EventConnectionState, state)
+	}
+}
+
+// stopped reports whether stop has been closed. Call with the session's mu held.
+func stopped(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryable reports whether a failed reconnect attempt is worth repeating.
+// Rejected credentials and host key problems need the user, and retrying
+// them risks account lockouts.
+func retryable(err error) bool {
+	var unknown *tunnel.UnknownHostKeyError
+	var changed *tunnel.HostKeyChangedError
+	switch {
+	case errors.As(err, &unknown), errors.As(err, &changed):
+		return false
+	case errors.Is(err, tunnel.ErrPassphraseRequired), tunnel.IsAuthError(err):
+		return false
+	}
+	return true
+}
+
+// backoff returns the delay before the given attempt: exponential from
+// reconnectBaseDelay up to reconnectMaxDelay, with the upper half jittered
+// so many clients dropped at once do not retry in lockstep.
+func backoff(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt <= 6 {
+		delay = min(reconnectBaseDelay<<(attempt-1), reconnectMaxDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
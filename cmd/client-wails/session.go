@@ -0,0 +1,665 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"golang.org/x/crypto/ssh"
+	"ssh-forwarder/pkg/protocol"
+	"ssh-forwarder/pkg/tunnel"
+)
+
+// defaultProfile is used for requests that do not name a profile.
+const defaultProfile = "default"
+
+// EventConfigUpdate is emitted with a ConfigUpdate when the agent pushes a
+// reloaded configuration.
+const EventConfigUpdate = "config:update"
+
+// ConfigUpdate is a configuration the agent pushed after a reload.
+type ConfigUpdate struct {
+	ProfileID string                      `json:"profileId"`
+	Config    *protocol.HandshakeResponse `json:"config"`
+	Stopped   []string                    `json:"stopped,omitempty"` // Bound local addresses of forwards whose target was removed
+}
+
+// Session is one connection to a server, keyed by profile ID. It owns its
+// SSH client, yamux session, local listeners and traffic counters, so
+// several servers can be forwarded to at the same time.
+type Session struct {
+	ID  string
+	app *App
+
+	mu          sync.Mutex    // Serializes installing a connection and disconnecting
+	stop        chan struct{} // Closed when the session is disconnected or replaced
+	disconnects int           // Disconnect calls, so a Connect that raced one is dropped
+
+	// Swapped under mu, read lock-free by forwarding and status calls
+	request atomic.Pointer[ConnectRequest] // Resolved request, reused to reconnect
+	client  atomic.Pointer[ssh.Client]
+	mux     atomic.Pointer[yamux.Session]
+	config  atomic.Pointer[protocol.HandshakeResponse] // Latest handshake, for the HTTP proxy's services
+
+	draining atomic.Bool // The agent announced it is restarting; cleared on reconnect
+
+	listenersMu sync.Mutex
+	listeners   map[string]*forward // Bound local address -> forward
+	// pendingForwards holds the forwards whose listeners were closed when
+	// the connection dropped, by local address, until they are rebound
+	pendingForwards map[string]*forward
+	// reverse holds the reverse forwards by requested remote address,
+	// which is also their forward ID. Entries survive a dropped connection
+	// and are re-requested on reconnect
+	reverse map[string]*reverseForward
+
+	metrics Metrics
+}
+
+// forward is an active local listener and the remote target it serves.
+type forward struct {
+	ln      io.Closer // net.Listener, or net.PacketConn for UDP
+	target  string
+	network string // protocol.NetworkTCP, protocol.NetworkUDP, networkSOCKS or networkHTTP
+}
+
+// Pseudo networks of the proxy forwards, which have no target.
+const (
+	networkSOCKS = "socks5"
+	networkHTTP  = "http"
+)
+
+// reverseForward is a remote listener on the agent host whose connections
+// are forwarded to local.
+type reverseForward struct {
+	ln    *tunnel.ReverseListener // Nil while reconnecting
+	local string
+}
+
+// SessionInfo summarizes a session for the frontend.
+type SessionInfo struct {
+	ProfileID string   `json:"profileId"`
+	Host      string   `json:"host"`
+	User      string   `json:"username"`
+	Connected bool     `json:"connected"` // False while reconnecting
+	Forwards  []string `json:"forwards"`  // Bound local addresses
+	Reverse   []string `json:"reverse"`   // Remote addresses of reverse forwards
+}
+
+// SessionManager tracks the open sessions by profile ID.
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessionManager() *SessionManager {
+	return &SessionManager{sessions: make(map[string]*Session)}
+}
+
+// Get returns the session for id, or nil.
+func (m *SessionManager) Get(id string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[profileID(id)]
+}
+
+// Open returns the session for id, creating it if needed.
+func (m *SessionManager) Open(a *App, id string) *Session {
+	id = profileID(id)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		s = &Session{
+			ID:              id,
+			app:             a,
+			listeners:       make(map[string]*forward),
+			pendingForwards: make(map[string]*forward),
+			reverse:         make(map[string]*reverseForward),
+		}
+		m.sessions[id] = s
+	}
+	return s
+}
+
+// Remove forgets s, unless its profile has since been given a new session.
+func (m *SessionManager) Remove(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions[s.ID] == s {
+		delete(m.sessions, s.ID)
+	}
+}
+
+// List returns the sessions ordered by profile ID.
+func (m *SessionManager) List() []*Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func profileID(id string) string {
+	if id == "" {
+		return defaultProfile
+	}
+	return id
+}
+
+// Connect replaces any existing connection of the session with a new one
+// to req, which must already be resolved. The existing connection is kept
+// if the new one fails.
+func (s *Session) Connect(req ConnectRequest) (*protocol.HandshakeResponse, error) {
+	// Dial without holding mu: a login prompt can wait minutes for the
+	// user, and status calls and Disconnect must not wait with it
+	s.mu.Lock()
+	disconnects := s.disconnects
+	s.mu.Unlock()
+	conn, err := s.dial(req, s.app.LoadSettings(), s.app.askChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.disconnects != disconnects {
+		conn.Close()
+		return nil, errors.New("disconnected while connecting")
+	}
+
+	// Cleanup previous
+	s.disconnectLocked()
+	s.metrics.reset()
+	s.installLocked(conn)
+
+	s.request.Store(&req)
+	s.stop = make(chan struct{})
+	go s.supervise(s.stop, conn.client, conn.mux)
+	return conn.resp, nil
+}
+
+// connection is an established agent connection, not yet installed in a
+// session.
+type connection struct {
+	client *ssh.Client
+	mux    *yamux.Session
+	resp   *protocol.HandshakeResponse
+}
+
+func (c *connection) Close() {
+	c.mux.Close()
+	c.client.Close()
+}
+
+// dial connects, launches the agent and performs the handshake. It leaves
+// the session's state alone, so it may run without holding mu. Prompts
+// that cannot be answered from req are passed to challenge; with a nil
+// challenge they fail the login.
+func (s *Session) dial(req ConnectRequest, settings AppSettings, challenge ssh.KeyboardInteractiveChallenge) (*connection, error) {
+	hops, agentConn, err := sshHops(req, settings, challenge, req.hostKeyVerifier, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+
+	// Wrap connection for metrics
+	hops[0].WrapConn = func(c net.Conn) net.Conn { return &CountedConn{Conn: c, metrics: &s.metrics} }
+	client, err := tunnel.DialChain(hops)
+	if err != nil {
+		return nil, err
+	}
+
+	// The yamux session closes when the agent exits, which the supervisor
+	// picks up as a dropped connection
+	agent, err := tunnel.StartAgent(client, req.AgentPath, os.Stderr)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	resp, err := tunnel.Handshake(agent.Session)
+	if err != nil {
+		agent.Session.Close()
+		client.Close()
+		return nil, err
+	}
+	return &connection{client: client, mux: agent.Session, resp: resp}, nil
+}
+
+// installLocked makes conn the session's connection and starts serving its
+// reverse forwards and config updates.
+func (s *Session) installLocked(conn *connection) {
+	s.client.Store(conn.client)
+	s.mux.Store(conn.mux)
+	s.config.Store(conn.resp)
+	go tunnel.ServeReverse(conn.mux, s.dialReverse)
+	go s.watchConfig(conn.mux)
+}
+
+// watchConfig follows the agent's config updates for the lifetime of mux,
+// stopping the forwards whose target is removed and reporting a restarting
+// agent.
+func (s *Session) watchConfig(mux *yamux.Session) {
+	tunnel.WatchConfig(mux, func(resp *protocol.HandshakeResponse) {
+		if s.mux.Load() != mux {
+			return // Replaced by a reconnect
+		}
+		if resp.Draining {
+			// The agent closes the session once open streams finish, and
+			// the supervisor takes over from there
+			s.draining.Store(true)
+			log.Printf("[%s] Server is restarting, waiting for open connections to finish", s.ID)
+			s.emitState(ConnectionState{State: StateDraining})
+			return
+		}
+		prev := s.config.Swap(resp)
+		if reflect.DeepEqual(prev, resp) {
+			return // The current config, sent on subscribing
+		}
+
+		var stopped []string
+		s.listenersMu.Lock()
+		for addr, f := range s.listeners {
+			if f.network != networkSOCKS && f.network != networkHTTP &&
+				protocol.HasTarget(prev.AllowedPorts, f.network, f.target) &&
+				!protocol.HasTarget(resp.AllowedPorts, f.network, f.target) {
+				f.ln.Close()
+				delete(s.listeners, addr)
+				stopped = append(stopped, addr)
+			}
+		}
+		s.listenersMu.Unlock()
+
+		log.Printf("[%s] Server config updated: %d allowed targets, %d forwards stopped", s.ID, len(resp.AllowedPorts), len(stopped))
+		if s.app.ctx != nil {
+			runtime.EventsEmit(s.app.ctx, EventConfigUpdate, ConfigUpdate{ProfileID: s.ID, Config: resp, Stopped: stopped})
+		}
+	})
+}
+
+// Disconnect closes the session's connection and all its listeners, and
+// cancels a Connect in progress.
+func (s *Session) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnects++
+	s.disconnectLocked()
+}
+
+// active reports whether the session has a connection, or is restoring a
+// dropped one.
+func (s *Session) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stop != nil
+}
+
+func (s *Session) disconnectLocked() {
+	// Stop supervising, so closing the session is not taken for a drop
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+
+	// Stop all listeners first
+	s.listenersMu.Lock()
+	for addr, f := range s.listeners {
+		f.ln.Close()
+		delete(s.listeners, addr)
+	}
+	clear(s.pendingForwards)
+	for remote, r := range s.reverse {
+		if r.ln != nil {
+			r.ln.Close()
+		}
+		delete(s.reverse, remote)
+	}
+	s.listenersMu.Unlock()
+
+	s.closeLocked()
+}
+
+// closeLocked tears down the SSH client and yamux session, leaving
+// listeners alone.
+func (s *Session) closeLocked() {
+	if mux := s.mux.Swap(nil); mux != nil {
+		mux.Close()
+	}
+	if client := s.client.Swap(nil); client != nil {
+		client.Close()
+	}
+}
+
+// Connected reports whether the session has a live agent connection.
+func (s *Session) Connected() bool {
+	mux := s.mux.Load()
+	return s.client.Load() != nil && mux != nil && !mux.IsClosed()
+}
+
+// Info describes the session for the frontend.
+func (s *Session) Info() SessionInfo {
+	s.listenersMu.Lock()
+	forwards := make([]string, 0, len(s.listeners))
+	for addr := range s.listeners {
+		forwards = append(forwards, addr)
+	}
+	reverse := make([]string, 0, len(s.reverse))
+	for remote := range s.reverse {
+		reverse = append(reverse, remote)
+	}
+	s.listenersMu.Unlock()
+	sort.Strings(forwards)
+	sort.Strings(reverse)
+
+	info := SessionInfo{ProfileID: s.ID, Connected: s.Connected(), Forwards: forwards, Reverse: reverse}
+	if req := s.request.Load(); req != nil {
+		info.Host, info.User = req.Host, req.User
+	}
+	return info
+}
+
+// StartForward starts a local listener that forwards traffic to the remote
+// target over network ("tcp" or "udp"). localPort may be a TCP address or,
+// for stream targets, a local Unix socket ("unix:///path")
+func (s *Session) StartForward(localPort, target, network string) (string, error) {
+	// If user specifically requested a port (not :0), check if we already have it tracked
+	if localPort != ":0" && localPort != "0" {
+		s.listenersMu.Lock()
+		_, exists := s.listeners[localPort]
+		_, pending := s.pendingForwards[localPort]
+		s.listenersMu.Unlock()
+		if exists || pending {
+			return "", fmt.Errorf("Port already in use by this app")
+		}
+	}
+
+	return s.startListener(localPort, target, network)
+}
+
+// startListener binds localPort and forwards accepted connections, or
+// datagrams for UDP, to target.
+func (s *Session) startListener(localPort, target, network string) (string, error) {
+	switch protocol.NetworkOrDefault(network) {
+	case protocol.NetworkUDP:
+		return s.startUDPListener(localPort, target)
+	case networkSOCKS:
+		return s.startSOCKSListener(localPort)
+	case networkHTTP:
+		return s.startHTTPProxy(localPort)
+	}
+
+	ln, err := tunnel.ListenLocal(localPort)
+	if err != nil {
+		return "", fmt.Errorf("Failed to listen: %v", err)
+	}
+
+	boundAddr := ln.Addr().String()
+	if _, unix := protocol.UnixPath(localPort); unix {
+		boundAddr = localPort // Keep the scheme so the forward can be rebound
+	}
+
+	s.listenersMu.Lock()
+	s.listeners[boundAddr] = &forward{ln: ln, target: target, network: protocol.NetworkTCP}
+	s.listenersMu.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // Listener closed
+			}
+			go s.handleForwarding(conn, target)
+		}
+	}()
+
+	return boundAddr, nil
+}
+
+// startUDPListener binds a local UDP socket and relays its datagrams to
+// target, one flow per local source.
+func (s *Session) startUDPListener(localPort, target string) (string, error) {
+	pc, err := net.ListenPacket("udp", localPort)
+	if err != nil {
+		return "", fmt.Errorf("Failed to listen: %v", err)
+	}
+
+	boundAddr := pc.LocalAddr().String()
+
+	s.listenersMu.Lock()
+	s.listeners[boundAddr] = &forward{ln: pc, target: target, network: protocol.NetworkUDP}
+	s.listenersMu.Unlock()
+
+	go tunnel.ServeUDP(pc, func() (net.Conn, error) {
+		mux := s.mux.Load()
+		if mux == nil {
+			return nil, fmt.Errorf("Not connected")
+		}
+		stream, err := tunnel.OpenUDP(mux, target)
+		if err != nil {
+			log.Printf("[%s] Failed to open %s/udp: %v", s.ID, target, err)
+		}
+		return stream, err
+	})
+
+	return boundAddr, nil
+}
+
+// StartSOCKS starts a local SOCKS5 proxy whose requests are opened as
+// streams on this session. The agent's allowlist decides which targets
+// are reachable. Stop it with StopForward.
+func (s *Session) StartSOCKS(localAddr string) (string, error) {
+	return s.StartForward(localAddr, "", networkSOCKS)
+}
+
+func (s *Session) startSOCKSListener(localAddr string) (string, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return "", fmt.Errorf("Failed to listen: %v", err)
+	}
+
+	boundAddr := ln.Addr().String()
+
+	s.listenersMu.Lock()
+	s.listeners[boundAddr] = &forward{ln: ln, network: networkSOCKS}
+	s.listenersMu.Unlock()
+
+	go tunnel.ServeSOCKS(ln, func(network, target string) (net.Conn, error) {
+		mux := s.mux.Load()
+		if mux == nil {
+			return nil, fmt.Errorf("Not connected")
+		}
+		stream, err := tunnel.OpenNetwork(mux, network, target)
+		if err != nil {
+			log.Printf("[%s] SOCKS: failed to open %s/%s: %v", s.ID, target, network, err)
+		}
+		return stream, err
+	})
+
+	return boundAddr, nil
+}
+
+// StartHTTPProxy starts a local HTTP proxy that routes each service
+// hostname (see tunnel.ServiceHost) to its target. The services follow the
+// latest handshake. Stop it with StopForward.
+func (s *Session) StartHTTPProxy(localAddr string) (string, error) {
+	return s.StartForward(localAddr, "", networkHTTP)
+}
+
+func (s *Session) startHTTPProxy(localAddr string) (string, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return "", fmt.Errorf("Failed to listen: %v", err)
+	}
+
+	boundAddr := ln.Addr().String()
+
+	s.listenersMu.Lock()
+	s.listeners[boundAddr] = &forward{ln: ln, network: networkHTTP}
+	s.listenersMu.Unlock()
+
+	services := func() []protocol.PortConfig {
+		if resp := s.config.Load(); resp != nil {
+			return resp.AllowedPorts
+		}
+		return nil
+	}
+	proxy := tunnel.NewHTTPProxy(services, func(target string) (net.Conn, error) {
+		mux := s.mux.Load()
+		if mux == nil {
+			return nil, fmt.Errorf("Not connected")
+		}
+		stream, err := tunnel.OpenTarget(mux, target)
+		if err != nil {
+			log.Printf("[%s] HTTP proxy: failed to open %s: %v", s.ID, target, err)
+		}
+		return stream, err
+	})
+	go http.Serve(ln, proxy)
+
+	return boundAddr, nil
+}
+
+// StopForward stops the listener on the given local address
+func (s *Session) StopForward(localPort string) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	// A forward waiting to be rebound after a dropped connection
+	if _, pending := s.pendingForwards[localPort]; pending {
+		delete(s.pendingForwards, localPort)
+		return true
+	}
+
+	f, exists := s.listeners[localPort]
+	if !exists {
+		return false
+	}
+
+	f.ln.Close()
+	delete(s.listeners, localPort)
+	return true
+}
+
+func (s *Session) handleForwarding(localConn net.Conn, target string) {
+	// Safety check
+	mux := s.mux.Load()
+	if mux == nil {
+		localConn.Close()
+		return
+	}
+
+	// Retry while the agent reports the target at capacity
+	stream, err := tunnel.Retry(3, func() (net.Conn, error) {
+		return tunnel.OpenTarget(mux, target)
+	})
+	if err != nil {
+		log.Printf("[%s] Failed to open %s: %v", s.ID, target, err)
+		localConn.Close()
+		return
+	}
+
+	// Traffic is counted on the SSH connection (CountedConn), which gives
+	// true network usage including protocol overhead
+	tunnel.Pipe(localConn, stream)
+}
+
+// StartReverseForward asks the agent to listen on remote and forwards each
+// connection it accepts to local. It returns the bound remote address.
+func (s *Session) StartReverseForward(remote, local string) (string, error) {
+	mux := s.mux.Load()
+	if mux == nil {
+		return "", fmt.Errorf("Not connected")
+	}
+
+	// Register first, connections may arrive before Listen returns
+	s.listenersMu.Lock()
+	if _, exists := s.reverse[remote]; exists {
+		s.listenersMu.Unlock()
+		return "", fmt.Errorf("Remote address already forwarded")
+	}
+	r := &reverseForward{local: local}
+	s.reverse[remote] = r
+	s.listenersMu.Unlock()
+
+	ln, err := tunnel.Listen(mux, remote, remote)
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	if err != nil || s.reverse[remote] != r {
+		if s.reverse[remote] == r {
+			delete(s.reverse, remote)
+		}
+		if err == nil {
+			ln.Close() // Stopped or disconnected meanwhile
+			err = fmt.Errorf("Reverse forward stopped")
+		}
+		return "", err
+	}
+	r.ln = ln
+	return ln.Address, nil
+}
+
+// relistenReverse re-requests every reverse forward on a new connection,
+// returning the ones that failed by remote address.
+func (s *Session) relistenReverse(mux *yamux.Session) map[string]string {
+	failed := map[string]string{}
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	for remote, r := range s.reverse {
+		ln, err := tunnel.Listen(mux, remote, remote)
+		if err != nil {
+			failed[remote] = err.Error()
+			delete(s.reverse, remote)
+			continue
+		}
+		r.ln = ln
+	}
+	return failed
+}
+
+// StopReverseForward stops the reverse forward on the given remote address
+func (s *Session) StopReverseForward(remote string) bool {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	r, exists := s.reverse[remote]
+	if !exists {
+		return false
+	}
+	if r.ln != nil {
+		r.ln.Close()
+	}
+	delete(s.reverse, remote)
+	return true
+}
+
+// dialReverse connects a connection forwarded by the agent to the local
+// target of its reverse forward.
+func (s *Session) dialReverse(id string) (net.Conn, error) {
+	s.listenersMu.Lock()
+	r, ok := s.reverse[id]
+	s.listenersMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown reverse forward %s", id)
+	}
+	conn, err := tunnel.DialLocal(r.local)
+	if err != nil {
+		log.Printf("[%s] Failed to reach %s for reverse forward %s: %v", s.ID, r.local, id, err)
+	}
+	return conn, err
+}
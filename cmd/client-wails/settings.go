@@ -14,6 +14,8 @@ type AppSettings struct {
 	ConnectionTimeout int    `json:"connectionTimeout"` // SSH connection timeout in seconds
 	LocalBindAddress string `json:"localBindAddress"`  // Default local bind address for forwarding
 	AutoReconnect    bool   `json:"autoReconnect"`     // Auto-reconnect on disconnect
+	UseSSHAgent      bool   `json:"useSshAgent"`       // Offer ssh-agent identities before password auth
+	SSHAgentSocket   string `json:"sshAgentSocket"`    // ssh-agent socket path (empty = $SSH_AUTH_SOCK)
 
 	// Appearance
 	Theme    string `json:"theme"`    // "light" or "dark"
@@ -26,6 +28,7 @@ func defaultSettings() AppSettings {
 		ConnectionTimeout: 10,
 		LocalBindAddress: "127.0.0.1",
 		AutoReconnect:    true,
+		UseSSHAgent:      true,
 		Theme:            "light",
 		Language:         "zh",
 	}
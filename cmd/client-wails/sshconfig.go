@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"ssh-forwarder/pkg/sshconfig"
+	"ssh-forwarder/pkg/tunnel"
+)
+
+// SSHHost is a host alias from the user's OpenSSH client config, resolved
+// for import as a saved connection.
+type SSHHost struct {
+	Alias        string `json:"alias"`
+	HostName     string `json:"hostName"`
+	Port         string `json:"port"`
+	User         string `json:"username"`
+	IdentityFile string `json:"identityFile"`
+	ProxyJump    string `json:"proxyJump"`
+}
+
+// ListSSHHosts returns the aliases defined in ~/.ssh/config.
+func (a *App) ListSSHHosts() []SSHHost {
+	cfg := loadSSHConfig("")
+	hosts := []SSHHost{}
+	for _, alias := range cfg.Aliases() {
+		h := cfg.Resolve(alias)
+		port := h.Port
+		if port == "" {
+			port = "22"
+		}
+		hosts = append(hosts, SSHHost{
+			Alias:        alias,
+			HostName:     h.HostName,
+			Port:         port,
+			User:         h.User,
+			IdentityFile: existingFile(h.IdentityFiles),
+			ProxyJump:    strings.Join(h.ProxyJump, ","),
+		})
+	}
+	return hosts
+}
+
+// loadSSHConfig parses the config pasted on the login screen (which takes
+// precedence) followed by ~/.ssh/config. Parse errors are logged and the
+// offending source skipped, so a broken config never blocks connecting.
+func loadSSHConfig(text string) *sshconfig.Config {
+	var pasted *sshconfig.Config
+	if strings.TrimSpace(text) != "" {
+		c, err := sshconfig.Parse(strings.NewReader(text))
+		if err != nil {
+			log.Printf("Ignoring pasted SSH config: %v", err)
+		}
+		pasted = c
+	}
+	user, err := sshconfig.Load(sshconfig.DefaultPath())
+	if err != nil {
+		log.Printf("Ignoring ~/.ssh/config: %v", err)
+	}
+	return sshconfig.Merge(pasted, user)
+}
+
+// resolveRequest fills in req from the SSH config when its host (or a jump
+// host) is an alias. Values given explicitly in the request win, as command
+// line options do for ssh; a port is only taken from the config when the
+// request has none.
+func resolveRequest(req ConnectRequest) ConnectRequest {
+	cfg := loadSSHConfig(req.SSHConfig)
+
+	h, port := resolveHost(cfg, req.Host)
+	req.Host = net.JoinHostPort(h.HostName, port)
+	if req.User == "" {
+		req.User = h.User
+	}
+	if req.KeyPath == "" {
+		req.KeyPath = existingFile(h.IdentityFiles)
+	}
+
+	// Jump hosts from the config get no password: the target's password
+	// must not be sent to bastions possibly run by someone else, so they
+	// authenticate with keys or through interactive prompts
+	if len(req.JumpHosts) == 0 {
+		for _, spec := range h.ProxyJump {
+			user, host, port := sshconfig.ParseJump(spec)
+			if port != "" {
+				host = net.JoinHostPort(host, port)
+			}
+			req.JumpHosts = append(req.JumpHosts, JumpHost{Host: host, User: user})
+		}
+	}
+
+	jumps := make([]JumpHost, len(req.JumpHosts))
+	for i, jump := range req.JumpHosts {
+		jh, port := resolveHost(cfg, jump.Host)
+		jump.Host = net.JoinHostPort(jh.HostName, port)
+		if jump.User == "" {
+			jump.User = jh.User
+		}
+		if jump.User == "" {
+			jump.User = req.User
+		}
+		if jump.KeyPath == "" {
+			jump.KeyPath = existingFile(jh.IdentityFiles)
+		}
+		if jump.KeyPath == "" {
+			jump.KeyPath, jump.KeyPassphrase = req.KeyPath, req.KeyPassphrase
+		}
+		jumps[i] = jump
+	}
+	req.JumpHosts = jumps
+	return req
+}
+
+// resolveHost looks up the host part of addr ("alias" or "alias:port") and
+// returns the resolved host with the port to dial.
+func resolveHost(cfg *sshconfig.Config, addr string) (sshconfig.Host, string) {
+	alias, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		alias, port = addr, ""
+	}
+	h := cfg.Resolve(alias)
+	if port == "" {
+		port = h.Port
+	}
+	if port == "" {
+		port = "22"
+	}
+	return h, port
+}
+
+// existingFile returns the first of paths that exists, mirroring ssh
+// skipping IdentityFile entries that are not present.
+func existingFile(paths []string) string {
+	for _, path := range paths {
+		if _, err := os.Stat(tunnel.ExpandPath(path)); err == nil {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"ssh-forwarder/internal/config"
+	"ssh-forwarder/pkg/protocol"
+	"ssh-forwarder/pkg/tunnel"
+
+	"github.com/hashicorp/yamux"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// Exit codes, so scripts and CI jobs can tell failure stages apart.
+const (
+	exitOK        = 0
+	exitFailure   = 1 // Config, listener or lost-session errors
+	exitConnect   = 2 // TCP/SSH connection failed
+	exitAuth      = 3 // SSH authentication rejected
+	exitAgent     = 4 // server-agent could not be launched
+	exitHandshake = 5 // Protocol handshake failed
+	exitHostKey   = 6 // Host key unknown or changed
+)
+
+// Environment variables for secrets that should not live in config.yaml.
+const (
+	passwordEnv   = "SSH_FORWARDER_PASSWORD"    // Password for hosts without key auth
+	passphraseEnv = "SSH_FORWARDER_PASSPHRASE"  // Passphrase for an encrypted key_file
+	agentTokenEnv = "SSH_FORWARDER_AGENT_TOKEN" // Token for a listen mode agent (agent_address)
+)
+
+func main() {
+	var configPath string
+	var agentPath string
+	var timeout time.Duration
+	flag.StringVar(&configPath, "config", "config.yaml", "Path to client config")
+	flag.StringVar(&agentPath, "agent", "", "Remote path to server-agent (overrides agent_path)")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "SSH connection timeout")
+	flag.Parse()
+
+	log.SetOutput(os.Stderr)
+	log.SetPrefix("[client] ")
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(exitFailure)
+	}
+	if agentPath != "" {
+		cfg.AgentPath = agentPath
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, cfg, timeout))
+}
+
+func run(ctx context.Context, cfg *config.Config, timeout time.Duration) int {
+	var session *yamux.Session
+	var resp *protocol.HandshakeResponse
+	server := cfg.Server
+	if cfg.AgentAddress != "" {
+		server = cfg.AgentAddress
+		s, code := dialDirect(cfg, timeout)
+		if code != exitOK {
+			return code
+		}
+		defer s.Close()
+		session = s
+
+		r, err := tunnel.Handshake(session)
+		if err != nil {
+			log.Printf("Handshake failed: %v", err)
+			return exitHandshake
+		}
+		resp = r
+	} else {
+		client, code := dialSSH(cfg, timeout)
+		if code != exitOK {
+			return code
+		}
+		defer client.Close()
+
+		agent, r, code := startAgent(client, cfg)
+		if code != exitOK {
+			return code
+		}
+		defer agent.Close()
+		session, resp = agent.Session, r
+	}
+	log.Printf("Connected to %s (agent version %s, %d allowed targets)", server, resp.Version, len(resp.AllowedPorts))
+
+	// The agent pushes its allowlist again after every reload
+	var ports atomic.Pointer[[]protocol.PortConfig]
+	ports.Store(&resp.AllowedPorts)
+
+	var wg sync.WaitGroup
+	var listeners []io.Closer
+	forwards := make(map[int]io.Closer) // Index in cfg.Forwards -> listener
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		wg.Wait()
+	}()
+
+	for i, rule := range cfg.Forwards {
+		if protocol.NetworkOrDefault(rule.Network) == protocol.NetworkUDP {
+			pc, err := net.ListenPacket("udp", localAddr(rule.Local))
+			if err != nil {
+				log.Printf("Failed to listen on %s/udp: %v", rule.Local, err)
+				return exitFailure
+			}
+			listeners = append(listeners, pc)
+			forwards[i] = pc
+			log.Printf("Forwarding %s/udp -> %s", pc.LocalAddr(), rule.Remote)
+
+			wg.Add(1)
+			go func(pc net.PacketConn, target string) {
+				defer wg.Done()
+				serveUDPForward(pc, session, target)
+			}(pc, rule.Remote)
+			continue
+		}
+
+		ln, err := tunnel.ListenLocal(localAddr(rule.Local))
+		if err != nil {
+			log.Printf("Failed to listen on %s: %v", rule.Local, err)
+			return exitFailure
+		}
+		listeners = append(listeners, ln)
+		forwards[i] = ln
+		log.Printf("Forwarding %s -> %s", ln.Addr(), rule.Remote)
+
+		wg.Add(1)
+		go func(ln net.Listener, target string) {
+			defer wg.Done()
+			serveForward(ln, session, target)
+		}(ln, rule.Remote)
+	}
+
+	if cfg.SOCKS != "" {
+		ln, err := net.Listen("tcp", localAddr(cfg.SOCKS))
+		if err != nil {
+			log.Printf("Failed to listen on %s: %v", cfg.SOCKS, err)
+			return exitFailure
+		}
+		listeners = append(listeners, ln)
+		log.Printf("SOCKS5 proxy on %s", ln.Addr())
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveSOCKS(ln, session)
+		}()
+	}
+
+	if cfg.HTTPProxy != "" {
+		ln, err := net.Listen("tcp", localAddr(cfg.HTTPProxy))
+		if err != nil {
+			log.Printf("Failed to listen on %s: %v", cfg.HTTPProxy, err)
+			return exitFailure
+		}
+		listeners = append(listeners, ln)
+		log.Printf("HTTP proxy on %s (PAC: http://%s/proxy.pac)", ln.Addr(), ln.Addr())
+		for _, p := range resp.AllowedPorts {
+			if host := tunnel.ServiceHost(p); host != "" {
+				log.Printf("  http://%s/ -> %s", host, p.Target)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveHTTPProxy(ln, session, &ports)
+		}()
+	}
+
+	if len(cfg.Reverse) > 0 {
+		// Forward IDs are the remote addresses, unique within the config
+		targets := make(map[string]string, len(cfg.Reverse))
+		for _, rule := range cfg.Reverse {
+			targets[rule.Remote] = rule.Local
+		}
+		go tunnel.ServeReverse(session, func(id string) (net.Conn, error) {
+			conn, err := tunnel.DialLocal(targets[id])
+			if err != nil {
+				log.Printf("Failed to reach %s for reverse forward %s: %v", targets[id], id, err)
+			}
+			return conn, err
+		})
+
+		for _, rule := range cfg.Reverse {
+			rl, err := tunnel.Listen(session, rule.Remote, rule.Remote)
+			if err != nil {
+				log.Printf("Failed to start reverse forward: %v", err)
+				return exitFailure
+			}
+			listeners = append(listeners, rl)
+			log.Printf("Reverse forwarding %s (remote) -> %s", rl.Address, rule.Local)
+		}
+	}
+
+	go followConfig(session, &ports, cfg.Forwards, forwards)
+
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down")
+		return exitOK
+	case <-session.CloseChan():
+		log.Printf("Session to %s closed", server)
+		return exitFailure
+	}
+}
+
+// dialSSH connects to the server through any jump hosts.
+func dialSSH(cfg *config.Config, timeout time.Duration) (*ssh.Client, int) {
+	keyring, agentConn := dialSSHAgent(cfg)
+
+	knownHosts := cfg.KnownHosts
+	if knownHosts == "" {
+		knownHosts = tunnel.AppKnownHostsFile()
+	}
+	verifier := func(hostKey string) *tunnel.HostKeyVerifier {
+		return &tunnel.HostKeyVerifier{
+			Files:            []string{tunnel.DefaultKnownHostsFile()},
+			AppFile:          tunnel.ExpandPath(knownHosts),
+			TrustFingerprint: hostKey,
+		}
+	}
+
+	hops, err := hopConfigs(cfg, keyring, verifier, timeout)
+	if err != nil {
+		log.Printf("%v", err)
+		return nil, exitFailure
+	}
+
+	client, err := tunnel.DialChain(hops)
+	if agentConn != nil {
+		agentConn.Close()
+	}
+	if err != nil {
+		var unknown *tunnel.UnknownHostKeyError
+		var changed *tunnel.HostKeyChangedError
+		switch {
+		case errors.As(err, &unknown):
+			log.Printf("Host key for %s is not trusted: %s %s", unknown.Host, unknown.KeyType, unknown.Fingerprint)
+			log.Printf("Verify the fingerprint and set host_key: %q for this host in the config to accept it", unknown.Fingerprint)
+			return nil, exitHostKey
+		case errors.As(err, &changed):
+			log.Printf("WARNING: %v", err)
+			return nil, exitHostKey
+		}
+		log.Printf("SSH connection to %s failed: %v", cfg.Server, err)
+		if tunnel.IsAuthError(err) {
+			return nil, exitAuth
+		}
+		return nil, exitConnect
+	}
+	return client, exitOK
+}
+
+// startAgent launches server-agent over the SSH connection and performs
+// the handshake.
+func startAgent(client *ssh.Client, cfg *config.Config) (*tunnel.Agent, *protocol.HandshakeResponse, int) {
+	agent, err := tunnel.StartAgent(client, cfg.AgentPath, os.Stderr)
+	if err != nil {
+		log.Printf("Failed to launch server-agent: %v", err)
+		return nil, nil, exitAgent
+	}
+
+	resp, err := tunnel.Handshake(agent.Session)
+	if err != nil {
+		// A missing or crashing agent surfaces as a broken session; give the
+		// exit status a moment to arrive so the two cases can be told apart.
+		select {
+		case <-agent.Exited():
+			log.Printf("Failed to launch server-agent: %v", agent.ExitErr())
+			agent.Close()
+			return nil, nil, exitAgent
+		case <-time.After(time.Second):
+		}
+		agent.Close()
+		log.Printf("Handshake failed: %v", err)
+		return nil, nil, exitHandshake
+	}
+	return agent, resp, exitOK
+}
+
+// dialDirect connects to a server-agent in listen mode, skipping SSH.
+func dialDirect(cfg *config.Config, timeout time.Duration) (*yamux.Session, int) {
+	token := cfg.AgentToken
+	if env := os.Getenv(agentTokenEnv); env != "" {
+		token = env
+	}
+	var tlsConfig *tls.Config
+	if cfg.AgentTLS.Enabled() {
+		c, err := tunnel.ClientTLS(cfg.AgentTLS.CA, cfg.AgentTLS.Cert, cfg.AgentTLS.Key, cfg.AgentTLS.ServerName)
+		if err != nil {
+			log.Printf("%v", err)
+			return nil, exitFailure
+		}
+		tlsConfig = c
+	}
+
+	session, err := tunnel.DialDirect(tunnel.DirectConfig{
+		Address: cfg.AgentAddress,
+		Token:   token,
+		TLS:     tlsConfig,
+		Timeout: timeout,
+	})
+	if err != nil {
+		log.Printf("Connection to agent at %s failed: %v", cfg.AgentAddress, err)
+		if tunnel.IsAuthError(err) {
+			return nil, exitAuth
+		}
+		return nil, exitConnect
+	}
+	return session, exitOK
+}
+
+// dialSSHAgent connects to the configured ssh-agent, if any. The returned
+// closer, if non-nil, releases the agent connection.
+func dialSSHAgent(cfg *config.Config) (agent.Agent, io.Closer) {
+	if cfg.SSHAgent == "none" {
+		return nil, nil
+	}
+	socket := tunnel.AgentSocket(cfg.SSHAgent)
+	if socket == "" {
+		return nil, nil
+	}
+	keyring, closer, err := tunnel.DialAgent(socket)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil, nil
+	}
+	return keyring, closer
+}
+
+// hopConfigs describes every SSH server to dial: the jump hosts in order,
+// then the target server. Jump hosts inherit user and key_file when unset;
+// each hop trusts only its own host_key.
+func hopConfigs(cfg *config.Config, keyring agent.Agent, newVerifier func(hostKey string) *tunnel.HostKeyVerifier, timeout time.Duration) ([]tunnel.Config, error) {
+	hops := []tunnel.Config{}
+	add := func(host, user, keyFile, totpSecret, hostKey string) error {
+		verifier := newVerifier(hostKey)
+		hostKeyCallback, err := verifier.Callback()
+		if err != nil {
+			return fmt.Errorf("failed to load known_hosts: %w", err)
+		}
+		auths, err := authMethods(keyFile, totpSecret, keyring)
+		if err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+		hops = append(hops, tunnel.Config{
+			Host:              host,
+			User:              user,
+			Auth:              auths,
+			HostKeyCallback:   hostKeyCallback,
+			HostKeyAlgorithms: verifier.HostKeyAlgorithms(host),
+			Timeout:           timeout,
+		})
+		return nil
+	}
+
+	for _, jump := range cfg.JumpHosts {
+		user, keyFile := jump.User, jump.KeyFile
+		if user == "" {
+			user = cfg.User
+		}
+		if keyFile == "" {
+			keyFile = cfg.KeyFile
+		}
+		if err := add(jump.Host, user, keyFile, jump.TOTPSecret, jump.HostKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(cfg.Server, cfg.User, cfg.KeyFile, cfg.TOTPSecret, cfg.HostKey); err != nil {
+		return nil, err
+	}
+	return hops, nil
+}
+
+// authMethods builds the auth chain for one host: keyFile and ssh-agent
+// identities are offered before the password, then keyboard-interactive.
+func authMethods(keyFile, totpSecret string, keyring agent.Agent) ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	if keyFile != "" {
+		signer, err := loadKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	auths := []ssh.AuthMethod{}
+	if len(signers) > 0 || keyring != nil {
+		auths = append(auths, tunnel.PublicKeyAuth(signers, keyring))
+	}
+	pass := os.Getenv(passwordEnv)
+	if pass != "" {
+		auths = append(auths, ssh.Password(pass))
+	}
+	var fallback ssh.KeyboardInteractiveChallenge
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fallback = terminalChallenge
+	}
+	if pass != "" || totpSecret != "" || fallback != nil {
+		auths = append(auths, ssh.KeyboardInteractive(tunnel.AutoAnswer(pass, totpSecret, fallback)))
+	}
+	if len(auths) == 0 {
+		return nil, errors.New("no authentication configured: set key_file, run an ssh-agent or set " + passwordEnv)
+	}
+	return auths, nil
+}
+
+// loadKey reads the configured key, taking the passphrase from
+// SSH_FORWARDER_PASSPHRASE or prompting for it on the terminal.
+func loadKey(path string) (ssh.Signer, error) {
+	passphrase := os.Getenv(passphraseEnv)
+	signer, err := tunnel.LoadSigner(path, passphrase)
+	if !errors.Is(err, tunnel.ErrPassphraseRequired) {
+		return signer, err
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s: %w (set %s)", path, err, passphraseEnv)
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	input, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return tunnel.LoadSigner(path, string(input))
+}
+
+// terminalChallenge asks the user to answer keyboard-interactive prompts
+// that could not be answered from the config.
+func terminalChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if name != "" {
+		fmt.Fprintln(os.Stderr, name)
+	}
+	if instruction != "" {
+		fmt.Fprintln(os.Stderr, instruction)
+	}
+	fd := int(os.Stdin.Fd())
+	reader := bufio.NewReader(os.Stdin)
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		fmt.Fprint(os.Stderr, q)
+		if echos[i] {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = strings.TrimRight(line, "\r\n")
+			continue
+		}
+		input, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = string(input)
+	}
+	return answers, nil
+}
+
+// localAddr turns a bare port such as "8080" or ":8080" into a loopback
+// address so forwards are not exposed to the network unless explicitly
+// requested with a host such as "0.0.0.0:8080". Unix socket addresses
+// ("unix:///path") are returned unchanged.
+func localAddr(local string) string {
+	if port, ok := strings.CutPrefix(local, ":"); ok {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	if !strings.Contains(local, ":") {
+		return net.JoinHostPort("127.0.0.1", local)
+	}
+	return local
+}
+
+// openAttempts is how often a forwarded connection is tried while the agent
+// reports the target at capacity.
+const openAttempts = 3
+
+func serveForward(ln net.Listener, session *yamux.Session, target string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return // Listener closed
+		}
+		go func() {
+			stream, err := tunnel.Retry(openAttempts, func() (net.Conn, error) {
+				return tunnel.OpenTarget(session, target)
+			})
+			if err != nil {
+				log.Printf("Failed to open %s: %v", target, err)
+				conn.Close()
+				return
+			}
+			tunnel.Pipe(conn, stream)
+		}()
+	}
+}
+
+func serveUDPForward(pc net.PacketConn, session *yamux.Session, target string) {
+	tunnel.ServeUDP(pc, func() (net.Conn, error) {
+		stream, err := tunnel.OpenUDP(session, target)
+		if err != nil {
+			log.Printf("Failed to open %s/udp: %v", target, err)
+		}
+		return stream, err
+	})
+}
+
+func serveSOCKS(ln net.Listener, session *yamux.Session) {
+	tunnel.ServeSOCKS(ln, func(network, target string) (net.Conn, error) {
+		stream, err := tunnel.OpenNetwork(session, network, target)
+		if err != nil {
+			log.Printf("SOCKS: failed to open %s/%s: %v", target, network, err)
+		}
+		return stream, err
+	})
+}
+
+func serveHTTPProxy(ln net.Listener, session *yamux.Session, ports *atomic.Pointer[[]protocol.PortConfig]) {
+	proxy := tunnel.NewHTTPProxy(func() []protocol.PortConfig { return *ports.Load() }, func(target string) (net.Conn, error) {
+		stream, err := tunnel.OpenTarget(session, target)
+		if err != nil {
+			log.Printf("HTTP proxy: failed to open %s: %v", target, err)
+		}
+		return stream, err
+	})
+	http.Serve(ln, proxy)
+}
+
+// followConfig applies the agent's config updates until the session ends:
+// it logs added and removed targets, health changes and a restarting agent,
+// and stops the forwards whose target was removed. forwards maps indexes in
+// rules to their listeners.
+func followConfig(session *yamux.Session, ports *atomic.Pointer[[]protocol.PortConfig], rules []config.ForwardRule, forwards map[int]io.Closer) {
+	tunnel.WatchConfig(session, func(resp *protocol.HandshakeResponse) {
+		if resp.Draining {
+			log.Printf("Server is restarting: open connections may finish, new ones are refused until the session ends")
+			return
+		}
+		prev := *ports.Swap(&resp.AllowedPorts)
+		for _, p := range resp.AllowedPorts {
+			if !protocol.HasTarget(prev, p.Network, p.Target) {
+				log.Printf("Server now allows %s (%s)", p.Target, p.Name)
+			}
+		}
+		for _, p := range prev {
+			if !protocol.HasTarget(resp.AllowedPorts, p.Network, p.Target) {
+				log.Printf("Server no longer allows %s (%s)", p.Target, p.Name)
+			}
+		}
+		for _, p := range resp.AllowedPorts {
+			if p.Health == nil || healthStatus(prev, p.Name) == p.Health.Status {
+				continue
+			}
+			if p.Health.Status == protocol.HealthDown {
+				log.Printf("Target %s (%s) is down: %s", p.Target, p.Name, p.Health.Error)
+			} else {
+				log.Printf("Target %s (%s) is up (%d ms)", p.Target, p.Name, p.Health.LatencyMS)
+			}
+		}
+
+		for i, ln := range forwards {
+			rule := rules[i]
+			if protocol.HasTarget(prev, rule.Network, rule.Remote) && !protocol.HasTarget(resp.AllowedPorts, rule.Network, rule.Remote) {
+				log.Printf("Stopping forward %s -> %s: target no longer allowed", rule.Local, rule.Remote)
+				ln.Close()
+				delete(forwards, i)
+			}
+		}
+	})
+}
+
+// healthStatus returns the health status of the named port in ports, or ""
+// if it has not been checked.
+func healthStatus(ports []protocol.PortConfig, name string) string {
+	for _, p := range ports {
+		if p.Name == name && p.Health != nil {
+			return p.Health.Status
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"slices"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Access Control
+// ============================================================================
+
+// identity is the OS user the agent runs as, which in stdio mode is the SSH
+// login user. It is matched against the users, groups and deny_users of
+// allowed_ports.
+type identity struct {
+	names  []string // User name and UID
+	groups []string // Group names and GIDs
+}
+
+// agentUser is resolved once at startup. Nil if the user is unknown, in
+// which case ports with access rules are unavailable.
+var agentUser *identity
+
+// currentIdentity looks up the agent's user and group memberships. On a
+// group lookup error it returns the user without groups alongside the
+// error.
+func currentIdentity() (*identity, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("look up current user: %w", err)
+	}
+	id := &identity{names: []string{u.Username, u.Uid}}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		return id, fmt.Errorf("look up groups of %s: %w", u.Username, err)
+	}
+	for _, gid := range gids {
+		id.groups = append(id.groups, gid)
+		if g, err := user.LookupGroupId(gid); err == nil {
+			id.groups = append(id.groups, g.Name)
+		}
+	}
+	return id, nil
+}
+
+// permits reports whether p is available to id. deny_users wins; otherwise
+// a port listing users or groups is limited to them, and a port listing
+// neither is open to everyone.
+func (id *identity) permits(p protocol.PortConfig) bool {
+	if len(p.Users) == 0 && len(p.Groups) == 0 && len(p.DenyUsers) == 0 {
+		return true
+	}
+	if id == nil {
+		return false // Fail closed when the user is unknown
+	}
+	if containsAny(p.DenyUsers, id.names) {
+		return false
+	}
+	if len(p.Users) == 0 && len(p.Groups) == 0 {
+		return true
+	}
+	return containsAny(p.Users, id.names) || containsAny(p.Groups, id.groups)
+}
+
+func containsAny(list, values []string) bool {
+	for _, v := range values {
+		if slices.Contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
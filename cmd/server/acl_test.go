@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os/user"
+	"slices"
+	"testing"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+func TestIdentityPermits(t *testing.T) {
+	alice := &identity{names: []string{"alice", "1000"}, groups: []string{"1000", "alice", "100", "dev"}}
+	tests := []struct {
+		name string
+		id   *identity
+		port protocol.PortConfig
+		want bool
+	}{
+		{"open port", alice, protocol.PortConfig{}, true},
+		{"open port, unknown user", nil, protocol.PortConfig{}, true},
+		{"listed user", alice, protocol.PortConfig{Users: []string{"bob", "alice"}}, true},
+		{"listed UID", alice, protocol.PortConfig{Users: []string{"1000"}}, true},
+		{"other users", alice, protocol.PortConfig{Users: []string{"bob"}}, false},
+		{"listed group", alice, protocol.PortConfig{Groups: []string{"dev"}}, true},
+		{"listed GID", alice, protocol.PortConfig{Groups: []string{"100"}}, true},
+		{"other groups", alice, protocol.PortConfig{Groups: []string{"ops"}}, false},
+		{"user or group", alice, protocol.PortConfig{Users: []string{"bob"}, Groups: []string{"dev"}}, true},
+		{"denied", alice, protocol.PortConfig{DenyUsers: []string{"alice"}}, false},
+		{"denied by UID", alice, protocol.PortConfig{DenyUsers: []string{"1000"}}, false},
+		{"others denied", alice, protocol.PortConfig{DenyUsers: []string{"bob"}}, true},
+		{"deny wins over user", alice, protocol.PortConfig{Users: []string{"alice"}, DenyUsers: []string{"alice"}}, false},
+		{"deny wins over group", alice, protocol.PortConfig{Groups: []string{"dev"}, DenyUsers: []string{"1000"}}, false},
+		{"names are not groups", alice, protocol.PortConfig{Users: []string{"dev"}}, false},
+		{"restricted, unknown user", nil, protocol.PortConfig{Users: []string{"alice"}}, false},
+		{"deny list, unknown user", nil, protocol.PortConfig{DenyUsers: []string{"bob"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.id.permits(tt.port); got != tt.want {
+			t.Errorf("%s: permits = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCurrentIdentity(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	id, err := currentIdentity()
+	if id == nil {
+		t.Fatalf("currentIdentity: %v", err)
+	}
+	if !slices.Equal(id.names, []string{u.Username, u.Uid}) {
+		t.Errorf("names = %q, want %q and %q", id.names, u.Username, u.Uid)
+	}
+	if gid := u.Gid; err == nil && !slices.Contains(id.groups, gid) {
+		t.Errorf("groups = %q, want the primary group %s", id.groups, gid)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Audit Log
+// ============================================================================
+
+// AuditConfig enables the JSON-lines audit log. Changes take effect after a
+// restart.
+type AuditConfig struct {
+	File       string `yaml:"file"`        // Audit log path (empty = disabled)
+	MaxSizeMB  int    `yaml:"max_size_mb"` // Rotate once the file reaches this size (default: 100)
+	MaxBackups int    `yaml:"max_backups"` // Rotated files kept as file.1 ... file.N (default: 5)
+}
+
+// Audit events, the event field of each record.
+const (
+	auditSessionStart = "session_start"
+	auditSessionEnd   = "session_end"
+	auditHandshake    = "handshake"
+	auditConnect      = "connect"
+	auditListen       = "listen"
+	auditForwarded    = "forwarded"
+)
+
+// Audit decisions.
+const (
+	decisionAllow = "allow"
+	decisionDeny  = "deny"
+)
+
+// auditEvent is one line of the audit log. Field names and meanings are a
+// stable interface for log shippers: add fields, never rename or repurpose
+// them.
+type auditEvent struct {
+	Time  string `json:"time"` // RFC 3339, UTC
+	Event string `json:"event"`
+	auditSession
+	*auditStream
+}
+
+// auditSession identifies the session an event belongs to.
+type auditSession struct {
+	Session       string `json:"session"`                  // Random ID shared by the session's events
+	SSHUser       string `json:"ssh_user,omitempty"`       // Login user (stdio mode)
+	ClientAddr    string `json:"client_addr,omitempty"`    // From SSH_CLIENT in stdio mode, the peer in listen mode
+	ClientVersion string `json:"client_version,omitempty"` // Protocol version from the handshake
+}
+
+// auditStream describes a connect, listen or forwarded stream. It is logged
+// once the stream ends, or when it is refused.
+type auditStream struct {
+	Network    string `json:"network,omitempty"`
+	Target     string `json:"target"`   // Requested target, or the bind address of a reverse forward
+	Decision   string `json:"decision"` // "allow" or "deny"
+	Error      string `json:"error,omitempty"`
+	Code       string `json:"code,omitempty"` // protocol.Code* of a failed connect
+	BytesUp    int64  `json:"bytes_up"`       // Client to target
+	BytesDown  int64  `json:"bytes_down"`     // Target to client
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// auditLog is nil when auditing is disabled.
+var auditLog *auditWriter
+
+// auditWriter appends events to a file, rotating it by size. Agents
+// sharing the file, as every login does in stdio mode, take turns through
+// a lock file, and the rotation is decided on the file's current size.
+type auditWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	lock       *os.File
+}
+
+func openAuditLog(cfg AuditConfig) (*auditWriter, error) {
+	w := &auditWriter{
+		path:       cfg.File,
+		maxSize:    int64(cfg.MaxSizeMB) << 20,
+		maxBackups: cfg.MaxBackups,
+	}
+	if w.maxSize <= 0 {
+		w.maxSize = 100 << 20
+	}
+	if w.maxBackups <= 0 {
+		w.maxBackups = 5
+	}
+	lock, err := os.OpenFile(w.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	w.lock = lock
+	if err := w.open(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *auditWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	w.file = f
+	return nil
+}
+
+// size returns the size of the log file, reopening it first if another
+// agent rotated it away. Call with the lock file held.
+func (w *auditWriter) size() (int64, error) {
+	if w.file != nil {
+		info, err := w.file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if cur, err := os.Stat(w.path); err == nil && os.SameFile(info, cur) {
+			return info.Size(), nil
+		}
+		w.file.Close()
+		w.file = nil
+	}
+	if err := w.open(); err != nil {
+		return 0, err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// rotate shifts file.N-1 to file.N, down to file to file.1, dropping the
+// oldest, and starts a new file.
+func (w *auditWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+	for i := w.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		log.Printf("Failed to rotate audit log: %v", err)
+	}
+	return w.open()
+}
+
+// write appends ev as one line. Failures are logged, not returned, so
+// auditing never breaks forwarding.
+func (w *auditWriter) write(ev auditEvent) {
+	if w == nil {
+		return
+	}
+	ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	line, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("Failed to encode audit event: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	unlock, err := lockFile(w.lock)
+	if err != nil {
+		log.Printf("Audit event lost: %v", err)
+		return
+	}
+	defer unlock()
+
+	size, err := w.size()
+	if err != nil {
+		log.Printf("Audit event lost: %v", err)
+		return
+	}
+	if size > 0 && size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			log.Printf("Audit event lost: %v", err)
+			return
+		}
+	}
+	if _, err := w.file.Write(line); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// audit records an event for the session; stream is nil for session
+// events.
+func (s *Server) audit(event string, stream *auditStream) {
+	if auditLog == nil {
+		return
+	}
+	peer := s.peer
+	if v, ok := s.clientVersion.Load().(string); ok {
+		peer.ClientVersion = v
+	}
+	auditLog.write(auditEvent{Event: event, auditSession: peer, auditStream: stream})
+}
+
+// newSessionID returns a random ID for a session's audit events.
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// stdioPeer describes the client of a stdio session from the SSH login: the
+// agent's user and the address in SSH_CLIENT ("ip port local_port").
+func stdioPeer() auditSession {
+	var peer auditSession
+	if agentUser != nil {
+		peer.SSHUser = agentUser.names[0]
+	}
+	if fields := strings.Fields(os.Getenv("SSH_CLIENT")); len(fields) >= 2 {
+		peer.ClientAddr = net.JoinHostPort(fields[0], fields[1])
+	}
+	return peer
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// openTestAuditLog opens an audit log at path that rotates past maxSize
+// bytes.
+func openTestAuditLog(t *testing.T, path string, maxSize int64, maxBackups int) *auditWriter {
+	t.Helper()
+	w, err := openAuditLog(AuditConfig{File: path, MaxBackups: maxBackups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.maxSize = maxSize
+	t.Cleanup(func() {
+		w.file.Close()
+		w.lock.Close()
+	})
+	return w
+}
+
+// readAuditLines returns the targets of the events in path, checking that
+// each line is a complete record.
+func readAuditLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var targets []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev struct {
+			Event  string `json:"event"`
+			Target string `json:"target"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.Event != auditConnect {
+			t.Fatalf("%s: bad record %q: %v", path, scanner.Text(), err)
+		}
+		targets = append(targets, ev.Target)
+	}
+	return targets
+}
+
+func auditTestEvent(target string) auditEvent {
+	return auditEvent{Event: auditConnect, auditStream: &auditStream{Target: target, Decision: decisionAllow}}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	w := openTestAuditLog(t, path, 400, 2)
+	for i := range 20 {
+		w.write(auditTestEvent(fmt.Sprintf("10.0.0.%d:22", i)))
+	}
+
+	// The newest events are in the file, older ones in .1 and .2, and the
+	// rest were dropped with the oldest backup
+	var all []string
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 400 {
+			t.Errorf("%s is %d bytes, over the 400 byte limit", name, info.Size())
+		}
+		all = append(all, readAuditLines(t, name)...)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists beyond max_backups: %v", path, err)
+	}
+	if n := len(all); n == 0 || n >= 20 || all[n-1] != "10.0.0.19:22" {
+		t.Fatalf("kept %d events ending in %q, want the newest ones", n, all[n-1])
+	}
+	first := 20 - len(all)
+	for i, target := range all {
+		if want := fmt.Sprintf("10.0.0.%d:22", first+i); target != want {
+			t.Fatalf("event %d = %s, want %s: events out of order", i, target, want)
+		}
+	}
+}
+
+func TestAuditLogSharedByAgents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	const agents, events, maxBackups = 3, 100, 1000
+
+	// Separate writers stand for the agents of concurrent logins
+	writers := make([]*auditWriter, agents)
+	for i := range writers {
+		writers[i] = openTestAuditLog(t, path, 1000, maxBackups)
+	}
+	var wg sync.WaitGroup
+	for i, w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range events {
+				w.write(auditTestEvent(fmt.Sprintf("agent%d:%d", i, j)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	files := []string{path}
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		files = append(files, name)
+	}
+	seen := make(map[string]bool)
+	for _, name := range files {
+		info, _ := os.Stat(name)
+		if info.Size() > 1000 {
+			t.Errorf("%s is %d bytes: written after another agent rotated it", name, info.Size())
+		}
+		for _, target := range readAuditLines(t, name) {
+			if seen[target] {
+				t.Errorf("event %s logged twice", target)
+			}
+			seen[target] = true
+		}
+	}
+	if len(seen) != agents*events {
+		t.Errorf("%d events logged, want %d", len(seen), agents*events)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Graceful Shutdown
+// ============================================================================
+
+// defaultDrainTimeout is how long open streams may finish when the agent
+// shuts down, if drain_timeout is not set.
+const defaultDrainTimeout = 30 * time.Second
+
+// drainState coordinates a graceful shutdown of the whole process.
+type drainState struct {
+	start    chan struct{} // Closed when draining begins
+	once     sync.Once
+	sessions sync.WaitGroup // Listen mode sessions still being served
+}
+
+var shutdown = &drainState{start: make(chan struct{})}
+
+// shuttingDown answers connect requests while draining.
+var shuttingDown = protocol.ConnectResponse{
+	Error: "Server is shutting down, reconnect to continue",
+	Code:  protocol.CodeShuttingDown,
+}
+
+func (d *drainState) begin() {
+	d.once.Do(func() { close(d.start) })
+}
+
+func (d *drainState) draining() bool {
+	select {
+	case <-d.start:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleSignals starts draining on the first SIGTERM or SIGINT and exits at
+// once on the second.
+func handleSignals(configs *configStore) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	sig := <-signals
+	log.Printf("%v received, draining open streams for up to %s", sig, configs.Load().DrainTimeout)
+	shutdown.begin()
+
+	sig = <-signals
+	log.Printf("%v received again, exiting", sig)
+	os.Exit(1)
+}
+
+// drainOnShutdown drains the session once the process starts shutting
+// down: watchers are told (see handleWatch), reverse forwards stop and open
+// streams get the drain timeout to finish before the session is closed.
+func (s *Server) drainOnShutdown() {
+	select {
+	case <-s.session.CloseChan():
+		return
+	case <-shutdown.start:
+	}
+
+	s.reverseMu.Lock()
+	for _, r := range s.reverse {
+		r.stream.Close()
+	}
+	s.reverseMu.Unlock()
+
+	timeout := s.config.Load().DrainTimeout
+	if !s.waitStreams(timeout) {
+		log.Printf("Drain timeout of %s reached, closing %d open streams", timeout, atomic.LoadInt64(&s.activeCount))
+	}
+	s.session.Close()
+}
+
+// waitStreams waits up to timeout for the session's streams and reverse
+// forwarded connections to finish, and reports whether they did.
+func (s *Server) waitStreams(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&s.activeCount) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+	"ssh-forwarder/pkg/tunnel"
+)
+
+// resetShutdown gives the test its own shutdown state, since draining
+// cannot be undone.
+func resetShutdown(t *testing.T) {
+	saved := shutdown
+	shutdown = &drainState{start: make(chan struct{})}
+	t.Cleanup(func() { shutdown = saved })
+}
+
+func TestDrainOnShutdown(t *testing.T) {
+	resetShutdown(t)
+	echo := startEcho(t)
+	cfg := defaultConfig()
+	cfg.Listen.Token = "s3cret"
+	cfg.Listen.Address = "unix://" + filepath.Join(t.TempDir(), "agent.sock")
+	cfg.DrainTimeout = 10 * time.Second
+	cfg.AllowedPorts = []protocol.PortConfig{{Name: "echo", Target: echo}}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- listenAndServe(newConfigStore("", "", cfg)) }()
+
+	dial := func() error {
+		session, err := tunnel.DialDirect(tunnel.DirectConfig{Address: cfg.Listen.Address, Token: "s3cret", Timeout: 5 * time.Second})
+		if err == nil {
+			session.Close()
+		}
+		return err
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for dial() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("agent not listening")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	session, err := tunnel.DialDirect(tunnel.DirectConfig{Address: cfg.Listen.Address, Token: "s3cret", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("DialDirect: %v", err)
+	}
+	defer session.Close()
+	if _, err := tunnel.Handshake(session); err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	stream := pingStream(t, session, echo)
+
+	shutdown.begin()
+
+	// New connects are refused, on the open session and as new sessions
+	var ce *tunnel.ConnectError
+	if _, err := tunnel.OpenTarget(session, echo); !errors.As(err, &ce) || ce.Code != protocol.CodeShuttingDown {
+		t.Errorf("OpenTarget while draining = %v, want code %s", err, protocol.CodeShuttingDown)
+	}
+	for dial() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("agent still accepts sessions while draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The open stream keeps working and the agent waits for it
+	checkEcho(t, stream)
+	select {
+	case err := <-exited:
+		t.Fatalf("agent exited with a stream open: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	stream.Close()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("listenAndServe = %v, want nil after draining", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not exit once the stream finished")
+	}
+	select {
+	case <-session.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Error("session still open after the agent exited")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile does nothing here: agents sharing a file are not serialized,
+// which matters only when several run at once, as in stdio mode.
+func lockFile(f *os.File) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Health Checks
+// ============================================================================
+
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+)
+
+// healthMonitor checks the allowed ports in the background. Results are
+// reported in handshake responses, and status changes are pushed to
+// watching clients.
+type healthMonitor struct {
+	mu      sync.Mutex
+	results map[string]*protocol.PortHealth // healthKey -> latest result
+	due     map[string]time.Time            // healthKey -> next check
+	running map[string]bool
+}
+
+var health = &healthMonitor{
+	results: make(map[string]*protocol.PortHealth),
+	due:     make(map[string]time.Time),
+	running: make(map[string]bool),
+}
+
+// healthKey identifies a port's checks; the name is part of it since ports
+// sharing a target may check different HTTP paths.
+func healthKey(p protocol.PortConfig) string {
+	return p.Name + "\x00" + p.Target
+}
+
+// healthChecked reports whether p is health checked.
+func healthChecked(p protocol.PortConfig) bool {
+	return protocol.NetworkOrDefault(p.Network) == protocol.NetworkTCP && (p.HealthCheck == nil || !p.HealthCheck.Disabled)
+}
+
+// run starts due checks of the current config's ports every second.
+func (h *healthMonitor) run(configs *configStore) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		h.schedule(configs)
+		<-ticker.C
+	}
+}
+
+func (h *healthMonitor) schedule(configs *configStore) {
+	now := time.Now()
+	current := make(map[string]bool)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, p := range configs.Load().AllowedPorts {
+		if !healthChecked(p) || !agentUser.permits(p) {
+			continue
+		}
+		key := healthKey(p)
+		current[key] = true
+		if h.running[key] || now.Before(h.due[key]) {
+			continue
+		}
+		interval := defaultHealthInterval
+		if p.HealthCheck != nil && p.HealthCheck.Interval > 0 {
+			interval = p.HealthCheck.Interval
+		}
+		h.running[key] = true
+		h.due[key] = now.Add(interval)
+		go h.check(configs, key, p)
+	}
+
+	// Forget ports removed from the config
+	for key := range h.due {
+		if !current[key] && !h.running[key] {
+			delete(h.due, key)
+			delete(h.results, key)
+		}
+	}
+}
+
+// check probes p and records the result, notifying watchers if its status
+// changed.
+func (h *healthMonitor) check(configs *configStore, key string, p protocol.PortConfig) {
+	start := time.Now()
+	err := probe(p)
+	result := &protocol.PortHealth{
+		Status:    protocol.HealthUp,
+		CheckedAt: start.UTC(),
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status, result.Error = protocol.HealthDown, err.Error()
+	}
+
+	h.mu.Lock()
+	prev := h.results[key]
+	h.results[key] = result
+	h.running[key] = false
+	h.mu.Unlock()
+
+	if prev == nil || prev.Status != result.Status {
+		if err != nil {
+			log.Printf("Health check of %s (%s) failed: %v", p.Name, p.Target, err)
+		} else if prev != nil {
+			log.Printf("Health check of %s (%s) passed again", p.Name, p.Target)
+		}
+		configs.notify()
+	}
+}
+
+// get returns the latest result for p, or nil if it has not been checked.
+func (h *healthMonitor) get(p protocol.PortConfig) *protocol.PortHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if result, ok := h.results[healthKey(p)]; ok {
+		copied := *result
+		return &copied
+	}
+	return nil
+}
+
+// probe connects to p's target and, if configured, requests its HTTP
+// health path.
+func probe(p protocol.PortConfig) error {
+	check := protocol.HealthCheck{}
+	if p.HealthCheck != nil {
+		check = *p.HealthCheck
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthTimeout
+	}
+
+	network, addr, host := "tcp", p.Target, p.Target
+	if path, ok := protocol.UnixPath(p.Target); ok {
+		network, addr, host = "unix", path, "localhost"
+	}
+	dialer := net.Dialer{Timeout: check.Timeout}
+	if check.HTTPPath == "" {
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{
+		Timeout: check.Timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // A redirect (e.g. to a login page) means the service is up
+		},
+	}
+	resp, err := client.Get("http://" + host + check.HTTPPath)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("GET %s: %s", check.HTTPPath, resp.Status)
+	}
+	return nil
+}
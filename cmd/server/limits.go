@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Connection Limits
+// ============================================================================
+
+// defaultQueueTimeout is how long a queued connection waits for a slot when
+// queue_timeout is not set.
+const defaultQueueTimeout = 30 * time.Second
+
+// capacityError reports a target at its max_connections with a full queue,
+// or a queue wait that timed out; its message is sent to the client.
+type capacityError struct {
+	target string
+	max    int
+	queued bool // Waited in the queue and timed out
+}
+
+func (e *capacityError) Error() string {
+	if e.queued {
+		return fmt.Sprintf("%s is at capacity (%d connections) and no slot freed up in time, try again later", e.target, e.max)
+	}
+	return fmt.Sprintf("%s is at capacity (%d connections), try again later", e.target, e.max)
+}
+
+// connLimiter caps concurrent connections to a port across the sessions of
+// this agent process. In stdio mode every login runs its own agent, so the
+// cap applies per login there. Callers beyond the cap wait in a FIFO
+// queue; a released slot is handed to the first waiter.
+type connLimiter struct {
+	mu      sync.Mutex
+	max     int // From the config at the last acquire
+	active  int
+	waiters []chan struct{}
+}
+
+// connLimiters holds a limiter per port, by portKey.
+var connLimiters = struct {
+	sync.Mutex
+	limiters map[string]*connLimiter
+}{limiters: make(map[string]*connLimiter)}
+
+func connLimiterFor(p protocol.PortConfig) *connLimiter {
+	key := portKey(p)
+	connLimiters.Lock()
+	defer connLimiters.Unlock()
+	l, ok := connLimiters.limiters[key]
+	if !ok {
+		l = &connLimiter{}
+		connLimiters.limiters[key] = l
+	}
+	return l
+}
+
+// acquire takes a connection slot for p, queueing up to p.QueueTimeout if
+// p.QueueSize allows. It returns how long it waited; on success the caller
+// must call release. queued tracks the queue depth for metrics.
+func (l *connLimiter) acquire(p *protocol.PortConfig, queued *atomic.Int64) (time.Duration, error) {
+	l.mu.Lock()
+	l.max = p.MaxConnections
+	l.wakeLocked() // The limit may have been raised since the last call
+	if l.active < l.max {
+		l.active++
+		l.mu.Unlock()
+		return 0, nil
+	}
+	if len(l.waiters) >= p.QueueSize {
+		l.mu.Unlock()
+		return 0, &capacityError{target: p.Name, max: p.MaxConnections}
+	}
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	l.mu.Unlock()
+
+	queued.Add(1)
+	defer queued.Add(-1)
+	start := time.Now()
+	timeout := p.QueueTimeout
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return time.Since(start), nil // Slot handed over by release
+	case <-timer.C:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == ch {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return time.Since(start), &capacityError{target: p.Name, max: p.MaxConnections, queued: true}
+		}
+	}
+	// Handed a slot just as the timer fired
+	return time.Since(start), nil
+}
+
+// release frees a slot, handing it to the first waiter unless the limit
+// was lowered below the connections still open.
+func (l *connLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.wakeLocked()
+}
+
+// wakeLocked hands the free slots to waiters in queue order, so they go
+// ahead of new callers. Call with l.mu held.
+func (l *connLimiter) wakeLocked() {
+	for len(l.waiters) > 0 && l.active < l.max {
+		l.active++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// waitQueued waits until n callers are queued on l.
+func waitQueued(t *testing.T, l *connLimiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		got := len(l.waiters)
+		l.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnLimiterReleaseHandsSlotInOrder(t *testing.T) {
+	var l connLimiter
+	var queued atomic.Int64
+	p := &protocol.PortConfig{Name: "db", MaxConnections: 1, QueueSize: 2, QueueTimeout: 5 * time.Second}
+	if _, err := l.acquire(p, &queued); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	order := make(chan int, 2)
+	for i := range 2 {
+		go func() {
+			if _, err := l.acquire(p, &queued); err == nil {
+				order <- i
+			}
+		}()
+		waitQueued(t, &l, i+1)
+	}
+	if _, err := l.acquire(p, &queued); err == nil {
+		t.Fatal("acquire succeeded with a full queue")
+	}
+
+	for want := range 2 {
+		l.release()
+		if got := <-order; got != want {
+			t.Fatalf("waiter %d got the slot, want %d", got, want)
+		}
+	}
+}
+
+func TestConnLimiterRaisedLimitWakesWaiters(t *testing.T) {
+	var l connLimiter
+	var queued atomic.Int64
+	p := &protocol.PortConfig{Name: "db", MaxConnections: 1, QueueSize: 2, QueueTimeout: 5 * time.Second}
+	if _, err := l.acquire(p, &queued); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	done := make(chan error, 2)
+	for i := range 2 {
+		go func() {
+			_, err := l.acquire(p, &queued)
+			done <- err
+		}()
+		waitQueued(t, &l, i+1)
+	}
+
+	// After a reload to 3 the queued callers take the two free slots, so
+	// the next caller has to queue
+	raised := &protocol.PortConfig{Name: "db", MaxConnections: 3, QueueSize: 2, QueueTimeout: 10 * time.Millisecond}
+	_, err := l.acquire(raised, &queued)
+	var ce *capacityError
+	if !errors.As(err, &ce) || !ce.queued {
+		t.Errorf("new caller acquire = %v, want a queue timeout", err)
+	}
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Errorf("queued caller: %v", err)
+		}
+	}
+}
+
+func TestConnLimiterPerPort(t *testing.T) {
+	web := protocol.PortConfig{Name: "web", Target: "10.0.0.1:80"}
+	sameName := protocol.PortConfig{Name: "web", Target: "10.0.0.2:80"}
+	unnamed := protocol.PortConfig{Target: "10.0.0.3:80"}
+	otherUnnamed := protocol.PortConfig{Target: "10.0.0.4:80"}
+
+	if connLimiterFor(web) != connLimiterFor(web) {
+		t.Error("a port got a new limiter on each connection")
+	}
+	if connLimiterFor(web) == connLimiterFor(sameName) {
+		t.Error("ports with the same name share a limiter")
+	}
+	if connLimiterFor(unnamed) == connLimiterFor(otherUnnamed) {
+		t.Error("unnamed ports share a limiter")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+
+	"github.com/hashicorp/yamux"
+)
+
+// ============================================================================
+// Listen Mode
+// ============================================================================
+
+// authTimeout bounds the TLS handshake and auth exchange of a new connection.
+const authTimeout = 10 * time.Second
+
+type ListenConfig struct {
+	Address   string    `yaml:"address"`    // tcp://host:port, unix:///path or host:port
+	Token     string    `yaml:"token"`      // Pre-shared token clients must present
+	TokenFile string    `yaml:"token_file"` // File holding the token (takes precedence over token)
+	TLS       TLSConfig `yaml:"tls"`
+}
+
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`      // Server certificate (PEM)
+	Key      string `yaml:"key"`       // Server private key (PEM)
+	ClientCA string `yaml:"client_ca"` // CA for client certificates; enables mutual TLS
+}
+
+// listenAndServe accepts direct client connections and serves each one as
+// its own yamux session, exactly as a stdio session would be served. The
+// listen settings are read once; they do not change on reload.
+func listenAndServe(configs *configStore) error {
+	ln, token, err := listen(configs.Load().Listen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	// Stop accepting once draining; open sessions drain on their own
+	go func() {
+		<-shutdown.start
+		ln.Close()
+	}()
+	return serveListener(ln, configs, token)
+}
+
+// listen binds the listen mode address and returns the listener, with TLS
+// applied, and the token clients must present.
+func listen(cfg ListenConfig) (net.Listener, string, error) {
+	token, err := cfg.token()
+	if err != nil {
+		return nil, "", err
+	}
+	tlsConfig, err := cfg.TLS.serverConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	mutualTLS := tlsConfig != nil && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	if token == "" && !mutualTLS {
+		return nil, "", errors.New("listen mode requires a token (listen.token or listen.token_file) or mutual TLS (listen.tls.client_ca)")
+	}
+
+	network, address := protocol.SplitAddress(cfg.Address)
+	var ln net.Listener
+	if network == "unix" {
+		// Remove a socket left behind by a previous run
+		if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+		ln, err = listenUnix(address)
+	} else {
+		ln, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	} else if network == "tcp" {
+		log.Printf("Warning: token auth without TLS sends the token in clear text")
+	}
+	log.Printf("Listening on %s://%s (token: %t, tls: %t, mutual tls: %t)",
+		network, ln.Addr(), token != "", tlsConfig != nil, mutualTLS)
+	return ln, token, nil
+}
+
+// serveListener serves the connections accepted on ln until it is closed.
+// Once draining, it waits for the open sessions to end and returns nil.
+func serveListener(ln net.Listener, configs *configStore, token string) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if shutdown.draining() {
+				shutdown.sessions.Wait()
+				log.Printf("All sessions drained, exiting")
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		shutdown.sessions.Add(1)
+		go serveConn(conn, configs, token)
+	}
+}
+
+// serveConn authenticates a direct connection and serves its session.
+func serveConn(conn net.Conn, configs *configStore, token string) {
+	defer shutdown.sessions.Done()
+	defer conn.Close()
+
+	remote := conn.RemoteAddr().String()
+	if remote == "" || remote == "@" {
+		remote = "unix socket"
+	}
+	if err := authenticate(conn, token); err != nil {
+		log.Printf("Rejected %s: %v", remote, err)
+		return
+	}
+
+	session, err := yamux.Server(conn, yamuxConfig())
+	if err != nil {
+		log.Printf("Failed to create yamux server for %s: %v", remote, err)
+		return
+	}
+	defer session.Close()
+
+	log.Printf("Session from %s started", remote)
+	NewServer(session, configs, auditSession{ClientAddr: remote}).Serve()
+	log.Printf("Session from %s ended", remote)
+}
+
+// authenticate completes the TLS handshake, if any, and checks the token in
+// the client's auth message before yamux starts on the connection.
+func authenticate(conn net.Conn, token string) error {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake: %w", err)
+		}
+	}
+
+	// The client waits for our reply before starting yamux, so the reader
+	// cannot buffer any session data
+	line, err := bufio.NewReader(conn).ReadSlice('\n')
+	if err != nil {
+		return fmt.Errorf("read auth message: %w", err)
+	}
+	var msg protocol.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return fmt.Errorf("invalid auth message: %w", err)
+	}
+	if msg.Type != protocol.MsgTypeAuth {
+		return fmt.Errorf("expected auth message, got %q", msg.Type)
+	}
+	payloadBytes, _ := json.Marshal(msg.Payload)
+	var req protocol.AuthRequest
+	if err := json.Unmarshal(payloadBytes, &req); err != nil {
+		return fmt.Errorf("invalid auth payload: %w", err)
+	}
+
+	resp := protocol.AuthResponse{Success: true}
+	if token != "" && subtle.ConstantTimeCompare([]byte(req.Token), []byte(token)) != 1 {
+		resp = protocol.AuthResponse{Success: false, Error: "invalid token"}
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		return err
+	}
+	if !resp.Success {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// token returns the configured token, reading token_file if set.
+func (c ListenConfig) token() (string, error) {
+	if c.TokenFile == "" {
+		return c.Token, nil
+	}
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", c.TokenFile)
+	}
+	return token, nil
+}
+
+// serverConfig returns nil when no certificate is configured.
+func (c TLSConfig) serverConfig() (*tls.Config, error) {
+	if c.Cert == "" && c.Key == "" {
+		if c.ClientCA != "" {
+			return nil, errors.New("listen.tls.client_ca requires cert and key")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCA != "" {
+		pem, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
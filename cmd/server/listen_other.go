@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// listenUnix binds a unix socket. There is no umask here; access follows
+// the permissions of the socket's directory.
+func listenUnix(address string) (net.Listener, error) {
+	return net.Listen("unix", address)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+	"ssh-forwarder/pkg/tunnel"
+)
+
+// startEcho serves a TCP echo target on a loopback port until the test ends.
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startListenMode runs a listen mode agent on a unix socket in a temp
+// directory, allowing cfg's ports, and returns its address.
+func startListenMode(t *testing.T, cfg *ServerConfig) string {
+	t.Helper()
+	addr, _ := startListenModeConfig(t, cfg, "")
+	return addr
+}
+
+// startListenModeConfig is startListenMode with the config store, which
+// reloads from path.
+func startListenModeConfig(t *testing.T, cfg *ServerConfig, path string) (string, *configStore) {
+	t.Helper()
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.Address = "unix://" + filepath.Join(t.TempDir(), "agent.sock")
+	ln, token, err := listen(cfg.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	configs := newConfigStore(path, "", cfg)
+	go serveListener(ln, configs, token)
+	return cfg.Listen.Address, configs
+}
+
+func TestListenModeConnect(t *testing.T) {
+	echo := startEcho(t)
+	cfg := defaultConfig()
+	cfg.Listen.Token = "s3cret"
+	cfg.AllowedPorts = []protocol.PortConfig{{Name: "echo", Target: echo}}
+	addr := startListenMode(t, cfg)
+
+	session, err := tunnel.DialDirect(tunnel.DirectConfig{Address: addr, Token: "s3cret", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("DialDirect: %v", err)
+	}
+	defer session.Close()
+
+	resp, err := tunnel.Handshake(session)
+	if err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	if len(resp.AllowedPorts) != 1 || resp.AllowedPorts[0].Target != echo {
+		t.Fatalf("AllowedPorts = %+v, want only %s", resp.AllowedPorts, echo)
+	}
+
+	stream, err := tunnel.OpenTarget(session, echo)
+	if err != nil {
+		t.Fatalf("OpenTarget: %v", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(stream, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stream).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("echo = %q, %v", line, err)
+	}
+
+	if _, err := tunnel.OpenTarget(session, "127.0.0.1:1"); err == nil {
+		t.Error("OpenTarget succeeded for a target that is not allowed")
+	}
+}
+
+func TestListenModeRejectsBadToken(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Listen.Token = "s3cret"
+	addr := startListenMode(t, cfg)
+
+	for _, token := range []string{"wrong", ""} {
+		_, err := tunnel.DialDirect(tunnel.DirectConfig{Address: addr, Token: token, Timeout: 5 * time.Second})
+		if !errors.Is(err, tunnel.ErrTokenRejected) {
+			t.Errorf("DialDirect with token %q: error = %v, want ErrTokenRejected", token, err)
+		}
+	}
+}
+
+func TestListenModeRequiresAuth(t *testing.T) {
+	cfg := ListenConfig{Address: "unix://" + filepath.Join(t.TempDir(), "agent.sock")}
+	if ln, _, err := listen(cfg); err == nil {
+		ln.Close()
+		t.Fatal("listen succeeded without a token or mutual TLS")
+	}
+}
+
+func TestListenModeSocketPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no socket permissions on windows")
+	}
+	cfg := defaultConfig()
+	cfg.Listen.Token = "s3cret"
+	addr := startListenMode(t, cfg)
+
+	path, _ := protocol.UnixPath(addr)
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket mode = %o, want 600", perm)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix binds a unix socket only the agent's user can connect to. The
+// socket is created as 0600 rather than chmod-ed afterwards, so nobody else
+// can connect in between.
+func listenUnix(address string) (net.Listener, error) {
+	umask := syscall.Umask(0o177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", address)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -12,6 +14,7 @@ import (
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"ssh-forwarder/pkg/protocol"
@@ -25,11 +28,24 @@ import (
 // ============================================================================
 
 type ServerConfig struct {
-	AllowedPorts    []protocol.PortConfig `yaml:"allowed_ports"`
-	MaxStreams      int                   `yaml:"max_streams"`       // Max concurrent streams per session (default: 100)
-	IdleTimeout     time.Duration         `yaml:"idle_timeout"`      // Idle timeout for connections (default: 5m)
-	ConnectTimeout  time.Duration         `yaml:"connect_timeout"`   // Timeout for dialing targets (default: 10s)
-	MetricsPort     int                   `yaml:"metrics_port"`      // Port for metrics endpoint (0 = disabled)
+	AllowedPorts     []protocol.PortConfig `yaml:"allowed_ports"`
+	MaxStreams       int                   `yaml:"max_streams"`        // Max concurrent streams per session (default: 100)
+	IdleTimeout      time.Duration         `yaml:"idle_timeout"`       // Idle timeout for connections and new streams (default: 5m, 0 = none)
+	MaxLifetime      time.Duration         `yaml:"max_lifetime"`       // Longest a connection may stay open (0 = unlimited)
+	DrainTimeout     time.Duration         `yaml:"drain_timeout"`      // Time open streams get to finish on shutdown (default: 30s)
+	ConnectTimeout   time.Duration         `yaml:"connect_timeout"`    // Timeout for dialing targets (default: 10s)
+	MetricsPort      int                   `yaml:"metrics_port"`       // Port for metrics endpoint (0 = disabled)
+	UDPIdleTimeout   time.Duration         `yaml:"udp_idle_timeout"`   // Idle timeout for UDP flows (default: 1m)
+	AllowedBinds     []string              `yaml:"allowed_binds"`      // Remote addresses reverse forwards may listen on: "host:port" or "host:first-last"
+	Listen           ListenConfig          `yaml:"listen"`             // Direct client connections (listen mode)
+	Audit            AuditConfig           `yaml:"audit"`              // JSON-lines audit log of sessions and streams
+	SessionRateLimit *protocol.RateLimit   `yaml:"session_rate_limit"` // Bandwidth of each session, in addition to per-port rate_limit
+	Quotas           QuotaConfig           `yaml:"quotas"`             // Daily and monthly byte quotas per user
+	TerminateRemoved bool                  `yaml:"terminate_removed"`  // On reload, close open connections to targets no longer allowed
+	AllowTargets     []string              `yaml:"allow_targets"`      // Ad hoc targets beyond allowed_ports: "[udp://]host:ports" with an IP, CIDR, hostname or "*.domain" host
+	DenyTargets      []string              `yaml:"deny_targets"`       // Targets refused even if otherwise allowed, in the same form
+
+	allowRules, denyRules []targetRule // Parsed by validate
 }
 
 func defaultConfig() *ServerConfig {
@@ -38,7 +54,9 @@ func defaultConfig() *ServerConfig {
 		MaxStreams:     100,
 		IdleTimeout:    5 * time.Minute,
 		ConnectTimeout: 10 * time.Second,
+		DrainTimeout:   defaultDrainTimeout,
 		MetricsPort:    0,
+		UDPIdleTimeout: time.Minute,
 	}
 }
 
@@ -63,73 +81,91 @@ func putBuffer(buf *[]byte) {
 	bufferPool.Put(buf)
 }
 
-// ============================================================================
-// Metrics
-// ============================================================================
-
-type Metrics struct {
-	ActiveStreams    int64
-	TotalStreams     int64
-	TotalBytes       int64
-	HandshakeCount   int64
-	ConnectCount     int64
-	ConnectErrors    int64
-	DeniedRequests   int64
-}
-
-var metrics = &Metrics{}
-
-func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintf(w, "# Server Metrics\n")
-	fmt.Fprintf(w, "active_streams %d\n", atomic.LoadInt64(&m.ActiveStreams))
-	fmt.Fprintf(w, "total_streams %d\n", atomic.LoadInt64(&m.TotalStreams))
-	fmt.Fprintf(w, "total_bytes %d\n", atomic.LoadInt64(&m.TotalBytes))
-	fmt.Fprintf(w, "handshake_count %d\n", atomic.LoadInt64(&m.HandshakeCount))
-	fmt.Fprintf(w, "connect_count %d\n", atomic.LoadInt64(&m.ConnectCount))
-	fmt.Fprintf(w, "connect_errors %d\n", atomic.LoadInt64(&m.ConnectErrors))
-	fmt.Fprintf(w, "denied_requests %d\n", atomic.LoadInt64(&m.DeniedRequests))
-}
-
 // ============================================================================
 // Server
 // ============================================================================
 
 type Server struct {
 	session      *yamux.Session
-	config       *ServerConfig
-	activeCount  int64
+	config       *configStore // Shared by all sessions, swapped on reload
+	activeCount  int64        // Streams being served and reverse forwarded connections
 	streamLimit  chan struct{}
+
+	reverseMu sync.Mutex
+	reverse   map[string]*reverseListener // Reverse forward ID -> listener
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]*activeConn // Open connect streams, checked again on reload
+
+	peer          auditSession // Who the session is with, for the audit log
+	clientVersion atomic.Value // string, from the handshake
+
+	rate *tokenBucket // session_rate_limit, shared by the session's streams
 }
 
-func NewServer(session *yamux.Session, config *ServerConfig) *Server {
+func NewServer(session *yamux.Session, config *configStore, peer auditSession) *Server {
+	peer.Session = newSessionID()
+	rate := &tokenBucket{}
+	rate.setLimit(config.Load().SessionRateLimit)
 	return &Server{
 		session:     session,
 		config:      config,
-		streamLimit: make(chan struct{}, config.MaxStreams),
+		peer:        peer,
+		rate:        rate,
+		streamLimit: make(chan struct{}, config.Load().MaxStreams),
+		reverse:     make(map[string]*reverseListener),
+		conns:       make(map[net.Conn]*activeConn),
 	}
 }
 
 func main() {
 	var stdioMode bool
 	var configPath string
+	var listenAddr string
 	flag.BoolVar(&stdioMode, "stdio", true, "Use stdin/stdout for transport")
 	flag.StringVar(&configPath, "config", "server.yaml", "Path to server config")
+	flag.StringVar(&listenAddr, "listen", "", "Accept direct clients on tcp://host:port or unix:///path instead of stdio (overrides listen.address)")
 	flag.Parse()
 
 	// Configure logging to stderr
 	log.SetOutput(os.Stderr)
 	log.SetPrefix("[server-agent] ")
 
-	if !stdioMode {
-		log.Fatal("Only stdio mode is supported currently")
-	}
-
 	// Load Config
-	cfg, err := loadConfig(configPath)
+	cfg, loadedPath, err := loadConfig(configPath)
 	if err != nil {
 		log.Printf("Warning: Failed to load config %s: %v. Using defaults.", configPath, err)
 		cfg = defaultConfig()
+		loadedPath = configPath // Picked up once it appears
+	}
+	if listenAddr != "" {
+		cfg.Listen.Address = listenAddr
+	}
+	if agentUser, err = currentIdentity(); err != nil {
+		log.Printf("Warning: %v. Ports limited to users or groups may be unavailable.", err)
+	}
+	if cfg.Audit.File != "" {
+		if auditLog, err = openAuditLog(cfg.Audit); err != nil {
+			log.Fatalf("Failed to start audit log: %v", err)
+		}
+	}
+	if cfg.Quotas.StateFile != "" {
+		path, err := quotaStatePath(cfg.Quotas.StateFile)
+		if err == nil {
+			err = quotas.load(path)
+		}
+		if err != nil {
+			log.Fatalf("Failed to load quota state: %v", err)
+		}
+		go quotas.saveLoop()
+	}
+	configs := newConfigStore(loadedPath, listenAddr, cfg)
+	go configs.watch()
+	go health.run(configs)
+	go handleSignals(configs)
+	listenMode := listenAddr != "" || !stdioMode
+	if listenMode && cfg.Listen.Address == "" {
+		log.Fatal("Listen mode requires --listen or listen.address in the config")
 	}
 
 	// Start metrics server if configured
@@ -144,34 +180,48 @@ func main() {
 		}()
 	}
 
+	if listenMode {
+		if err := listenAndServe(configs); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Stdio Transport
 	conn := &stdioConn{
 		Reader: os.Stdin,
 		Writer: os.Stdout,
 	}
 
-	// Setup Yamux with optimized config
+	session, err := yamux.Server(conn, yamuxConfig())
+	if err != nil {
+		log.Fatalf("Failed to create yamux server: %v", err)
+	}
+
+	server := NewServer(session, configs, stdioPeer())
+	server.Serve()
+}
+
+// yamuxConfig returns the session settings shared by stdio and listen mode,
+// optimized for throughput.
+func yamuxConfig() *yamux.Config {
 	yamuxCfg := yamux.DefaultConfig()
 	yamuxCfg.EnableKeepAlive = true
 	yamuxCfg.KeepAliveInterval = 30 * time.Second
 	yamuxCfg.MaxStreamWindowSize = 1024 * 1024 // 1MB window for high throughput
 	yamuxCfg.StreamOpenTimeout = 30 * time.Second
 	yamuxCfg.StreamCloseTimeout = 5 * time.Minute
-
-	session, err := yamux.Server(conn, yamuxCfg)
-	if err != nil {
-		log.Fatalf("Failed to create yamux server: %v", err)
-	}
-
-	server := NewServer(session, cfg)
-	server.Serve()
+	return yamuxCfg
 }
 
-func loadConfig(path string) (*ServerConfig, error) {
+// loadConfig reads the config and returns it with the path it was found
+// at, which is the one watched for changes.
+func loadConfig(path string) (*ServerConfig, string, error) {
 	// 1. Try path as is (relative to CWD)
 	data, err := os.ReadFile(path)
 	if err == nil {
-		return parseConfig(data)
+		cfg, err := parseConfig(data)
+		return cfg, path, err
 	}
 
 	// 2. Try executable directory
@@ -182,11 +232,12 @@ func loadConfig(path string) (*ServerConfig, error) {
 		data, err = os.ReadFile(fullPath)
 		if err == nil {
 			log.Printf("Loaded config from executable dir: %s", fullPath)
-			return parseConfig(data)
+			cfg, err := parseConfig(data)
+			return cfg, fullPath, err
 		}
 	}
 
-	return nil, err
+	return nil, "", err
 }
 
 func parseConfig(data []byte) (*ServerConfig, error) {
@@ -194,35 +245,76 @@ func parseConfig(data []byte) (*ServerConfig, error) {
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
 func (s *Server) Serve() {
+	s.audit(auditSessionStart, nil)
+	defer s.audit(auditSessionEnd, nil)
+	defer quotas.save()
+	go s.watchConfig()
+	go s.drainOnShutdown()
+
 	for {
 		stream, err := s.session.Accept()
 		if err != nil {
 			log.Printf("Session accept failed: %v", err)
+			// Streams end with the session; let their handlers finish
+			// closing targets and writing audit records
+			s.waitStreams(s.config.Load().DrainTimeout)
 			return
 		}
 
+		if shutdown.draining() {
+			go s.rejectStream(stream, shuttingDown)
+			continue
+		}
+
 		// Rate limit: try to acquire stream slot
 		select {
 		case s.streamLimit <- struct{}{}:
 			// Got slot, proceed
+			atomic.AddInt64(&s.activeCount, 1)
 			atomic.AddInt64(&metrics.ActiveStreams, 1)
 			atomic.AddInt64(&metrics.TotalStreams, 1)
 			go s.handleStream(stream)
 		default:
 			// At limit, reject
-			log.Printf("Stream limit reached (%d), rejecting", s.config.MaxStreams)
+			log.Printf("Stream limit reached (%d), rejecting", cap(s.streamLimit))
 			atomic.AddInt64(&metrics.DeniedRequests, 1)
-			stream.Close()
+			go s.rejectStream(stream, protocol.ConnectResponse{
+				Error: fmt.Sprintf("Session stream limit (%d) reached, try again later", cap(s.streamLimit)),
+				Code:  protocol.CodeCapacityExceeded,
+			})
 		}
 	}
 }
 
+// rejectTimeout bounds reading the request of a stream rejected for the
+// stream limit or a shutdown.
+const rejectTimeout = 5 * time.Second
+
+// rejectStream answers a connect request on a stream the session will not
+// serve with resp, whose code lets the client tell it apart from a failed
+// dial: CodeCapacityExceeded over max_streams, CodeShuttingDown while
+// draining. Other requests are just closed.
+func (s *Server) rejectStream(stream net.Conn, resp protocol.ConnectResponse) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(rejectTimeout))
+
+	var msg protocol.Message
+	if err := json.NewDecoder(stream).Decode(&msg); err != nil || msg.Type != protocol.MsgTypeConnect {
+		return
+	}
+	json.NewEncoder(stream).Encode(resp)
+}
+
 func (s *Server) releaseStream() {
 	<-s.streamLimit
+	atomic.AddInt64(&s.activeCount, -1)
 	atomic.AddInt64(&metrics.ActiveStreams, -1)
 }
 
@@ -231,7 +323,9 @@ func (s *Server) handleStream(stream net.Conn) {
 	defer stream.Close()
 
 	// Set idle timeout
-	stream.SetDeadline(time.Now().Add(s.config.IdleTimeout))
+	if idle := s.config.Load().IdleTimeout; idle > 0 {
+		stream.SetDeadline(time.Now().Add(idle))
+	}
 
 	// Read Message (JSON)
 	decoder := json.NewDecoder(stream)
@@ -247,6 +341,12 @@ func (s *Server) handleStream(stream net.Conn) {
 	switch msg.Type {
 	case protocol.MsgTypeHandshake:
 		atomic.AddInt64(&metrics.HandshakeCount, 1)
+		payloadBytes, _ := json.Marshal(msg.Payload)
+		var hreq protocol.HandshakeRequest
+		if json.Unmarshal(payloadBytes, &hreq) == nil && hreq.Version != "" {
+			s.clientVersion.Store(hreq.Version)
+		}
+		s.audit(auditHandshake, nil)
 		s.handleHandshake(stream)
 	case protocol.MsgTypeConnect:
 		atomic.AddInt64(&metrics.ConnectCount, 1)
@@ -257,52 +357,167 @@ func (s *Server) handleStream(stream net.Conn) {
 			return
 		}
 		s.handleConnect(stream, freq)
+	case protocol.MsgTypeListen:
+		payloadBytes, _ := json.Marshal(msg.Payload)
+		var lreq protocol.ListenRequest
+		if err := json.Unmarshal(payloadBytes, &lreq); err != nil {
+			log.Printf("Invalid listen payload: %v", err)
+			return
+		}
+		s.handleListen(stream, lreq)
+	case protocol.MsgTypeWatch:
+		s.handleWatch(stream)
 	default:
 		log.Printf("Unknown message type: %s", msg.Type)
 	}
 }
 
 func (s *Server) handleHandshake(stream net.Conn) {
-	resp := protocol.HandshakeResponse{
-		Version:      "2.0",
-		AllowedPorts: s.config.AllowedPorts,
-	}
+	resp := handshakeResponse(s.config.Load())
 	if err := json.NewEncoder(stream).Encode(resp); err != nil {
 		log.Printf("Failed to send handshake response: %v", err)
 	}
 }
 
-func (s *Server) handleConnect(stream net.Conn, req protocol.ConnectRequest) {
-	// Validate Target
-	allowed := false
-	for _, p := range s.config.AllowedPorts {
-		if p.Target == req.Target {
-			allowed = true
-			break
+// handshakeResponse describes cfg to clients, in the handshake and in
+// config updates.
+func handshakeResponse(cfg *ServerConfig) protocol.HandshakeResponse {
+	// Advertise only what this user may use, and report each target's type
+	// so clients can tell unix sockets apart
+	ports := make([]protocol.PortConfig, 0, len(cfg.AllowedPorts))
+	for _, p := range cfg.AllowedPorts {
+		if !agentUser.permits(p) {
+			continue
 		}
+		p.Type = p.TargetType()
+		p.Health = health.get(p)
+		ports = append(ports, p)
+	}
+	return protocol.HandshakeResponse{
+		Version:      "2.0",
+		AllowedPorts: ports,
+		AllowedBinds: cfg.AllowedBinds,
 	}
+}
 
+func (s *Server) handleConnect(stream net.Conn, req protocol.ConnectRequest) {
+	cfg := s.config.Load()
+	network := protocol.NetworkOrDefault(req.Network)
+
+	// Audited once the stream ends, with the outcome from resp
 	resp := protocol.ConnectResponse{}
-	if !allowed {
+	rec := &auditStream{Network: network, Target: req.Target, Decision: decisionAllow}
+	start := time.Now()
+	defer func() {
+		switch resp.Code {
+		case protocol.CodeDenied, protocol.CodeUnsupported, protocol.CodeQuotaExceeded:
+			rec.Decision = decisionDeny
+		}
+		if resp.Error != "" {
+			rec.Error, rec.Code = resp.Error, resp.Code
+		}
+		rec.DurationMS = time.Since(start).Milliseconds()
+		s.audit(auditConnect, rec)
+	}()
+
+	if shutdown.draining() {
+		resp = shuttingDown
+		json.NewEncoder(stream).Encode(resp)
+		return
+	}
+	if network != protocol.NetworkTCP && network != protocol.NetworkUDP {
+		resp.Success = false
+		resp.Error = fmt.Sprintf("Unsupported network %q", req.Network)
+		resp.Code = protocol.CodeUnsupported
+		json.NewEncoder(stream).Encode(resp)
+		return
+	}
+
+	// Validate Target, resolving it if target rules apply
+	port := cfg.namedPort(network, req.Target)
+	stats := metrics.target(cfg.targetLabel(network, req.Target))
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	dialNetwork, dialAddrs, err := cfg.authorize(ctx, network, req.Target)
+	cancel()
+	if errors.Is(err, errTargetDenied) {
 		resp.Success = false
 		resp.Error = fmt.Sprintf("Target %s not allowed", req.Target)
+		resp.Code = protocol.CodeDenied
 		json.NewEncoder(stream).Encode(resp)
 		log.Printf("Denied access to %s", req.Target)
 		atomic.AddInt64(&metrics.DeniedRequests, 1)
+		stats.outcome(outcomeDenied)
+		return
+	}
+	if err != nil {
+		resp.Success = false
+		resp.Error = fmt.Sprintf("Resolve failed: %v", err)
+		resp.Code = protocol.CodeHostUnreachable
+		json.NewEncoder(stream).Encode(resp)
+		log.Printf("Failed to resolve %s: %v", req.Target, err)
+		atomic.AddInt64(&metrics.ConnectErrors, 1)
+		stats.outcome(outcomeDialError)
 		return
 	}
 
-	// Connect with timeout
-	dialer := net.Dialer{Timeout: s.config.ConnectTimeout}
-	targetConn, err := dialer.Dial("tcp", req.Target)
+	th := s.newThrottle(port)
+	if err := th.quota.check(); err != nil {
+		resp.Success = false
+		resp.Error = err.Error()
+		resp.Code = protocol.CodeQuotaExceeded
+		json.NewEncoder(stream).Encode(resp)
+		log.Printf("Denied access to %s: %v", req.Target, err)
+		atomic.AddInt64(&metrics.DeniedRequests, 1)
+		stats.outcome(outcomeDenied)
+		return
+	}
+
+	if port != nil && port.MaxConnections > 0 {
+		limiter := connLimiterFor(*port)
+		wait, err := limiter.acquire(port, &stats.queued)
+		if wait > 0 {
+			stats.queueWait.observe(wait.Seconds())
+		}
+		if err != nil {
+			resp.Success = false
+			resp.Error = err.Error()
+			resp.Code = protocol.CodeCapacityExceeded
+			json.NewEncoder(stream).Encode(resp)
+			log.Printf("Refused connection to %s: %v", req.Target, err)
+			stats.outcome(outcomeCapacity)
+			return
+		}
+		defer limiter.release()
+	}
+
+	if network == protocol.NetworkUDP {
+		s.track(stream, network, req.Target, stream)
+		defer s.untrack(stream)
+		s.handleUDP(stream, dialAddrs[0], stats, th, rec)
+		return
+	}
+
+	// Connect with timeout, trying each permitted address in turn
+	dialer := net.Dialer{Timeout: cfg.ConnectTimeout}
+	var targetConn net.Conn
+	dialStart := time.Now()
+	for _, addr := range dialAddrs {
+		if targetConn, err = dialer.Dial(dialNetwork, addr); err == nil {
+			break
+		}
+	}
+	stats.dial.observe(time.Since(dialStart).Seconds())
 	if err != nil {
 		resp.Success = false
 		resp.Error = fmt.Sprintf("Dial failed: %v", err)
+		resp.Code = dialErrorCode(err)
 		json.NewEncoder(stream).Encode(resp)
 		log.Printf("Failed to dial %s: %v", req.Target, err)
 		atomic.AddInt64(&metrics.ConnectErrors, 1)
+		stats.outcome(outcomeDialError)
 		return
 	}
+	stats.outcome(outcomeOK)
 
 	resp.Success = true
 	if err := json.NewEncoder(stream).Encode(resp); err != nil {
@@ -311,33 +526,150 @@ func (s *Server) handleConnect(stream net.Conn, req protocol.ConnectRequest) {
 	}
 
 	defer targetConn.Close()
+	s.track(stream, network, req.Target, targetConn)
+	defer s.untrack(stream)
+	defer stats.open()()
+
+	idle, lifetime := cfg.connTimeouts(port)
+	stopExpiry := expireConn(req.Target, stream, targetConn, th, stats, idle, lifetime)
+	if rec.BytesUp, rec.BytesDown, err = proxy(stream, targetConn, stats, th); err != nil {
+		rec.Error, rec.Code = err.Error(), protocol.CodeQuotaExceeded
+	}
+	if reason := stopExpiry(); reason != "" {
+		rec.Error = reason
+	}
+	log.Printf("Closed connection to %s", req.Target)
+}
 
+// proxy copies between a stream and the connection it is bridged to until
+// both directions are done, counting the bytes in stats and passing them
+// through th. It returns the bytes copied each way, and the quota error if
+// th ended the connection.
+func proxy(stream, targetConn net.Conn, stats *targetStats, th *throttle) (up, down int64, err error) {
 	// Proxy with buffer pool for zero-copy
-	done := make(chan struct{}, 2)
+	done := make(chan error, 2)
 
 	go func() {
 		buf := getBuffer()
 		defer putBuffer(buf)
-		n, _ := io.CopyBuffer(targetConn, stream, *buf)
-		atomic.AddInt64(&metrics.TotalBytes, n)
-		if conn, ok := targetConn.(*net.TCPConn); ok {
+		var err error
+		up, err = copyThrottled(targetConn, stream, *buf, th, &stats.upstream)
+		if conn, ok := targetConn.(interface{ CloseWrite() error }); ok {
 			conn.CloseWrite()
 		}
-		done <- struct{}{}
+		done <- err
 	}()
 
 	go func() {
 		buf := getBuffer()
 		defer putBuffer(buf)
-		n, _ := io.CopyBuffer(stream, targetConn, *buf)
-		atomic.AddInt64(&metrics.TotalBytes, n)
+		var err error
+		down, err = copyThrottled(stream, targetConn, *buf, th, &stats.downstream)
 		stream.Close()
-		done <- struct{}{}
+		done <- err
 	}()
 
-	<-done
-	<-done
-	log.Printf("Closed connection to %s", req.Target)
+	for range 2 {
+		var quotaErr *quotaError
+		if e := <-done; errors.As(e, &quotaErr) && err == nil {
+			err = e
+			log.Printf("Closing connection: %v", e)
+			stream.Close()
+			targetConn.Close()
+		}
+	}
+	return up, down, err
+}
+
+// copyThrottled copies src to dst like io.CopyBuffer, waiting on th before
+// each write and adding the bytes to counter. It stops at the first read or
+// write error, or when th fails.
+func copyThrottled(dst io.Writer, src io.Reader, buf []byte, th *throttle, counter *atomic.Int64) (int64, error) {
+	var written int64
+	for {
+		n, rerr := src.Read(buf)
+		if n > 0 {
+			if err := th.take(n); err != nil {
+				return written, err
+			}
+			w, werr := dst.Write(buf[:n])
+			written += int64(w)
+			counter.Add(int64(w))
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
+
+// dialErrorCode classifies a failed dial for ConnectResponse.Code.
+func dialErrorCode(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return protocol.CodeRefused
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.EHOSTUNREACH):
+		return protocol.CodeHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		return protocol.CodeNetworkUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return protocol.CodeTimeout
+	}
+	return ""
+}
+
+// allowTarget checks target against the allowlist, as it applies to the
+// agent's user, and returns the network and address to dial.
+func (c *ServerConfig) allowTarget(network, target string) (dialNetwork, dialAddr string, ok bool) {
+	for _, p := range c.AllowedPorts {
+		if protocol.NetworkOrDefault(p.Network) != network || !agentUser.permits(p) {
+			continue
+		}
+		if dialNetwork, dialAddr, ok = matchTarget(p.Target, target); ok {
+			break
+		}
+	}
+	if dialNetwork == "unix" && network != protocol.NetworkTCP {
+		ok = false // Only stream sockets are supported
+	}
+	return dialNetwork, dialAddr, ok
+}
+
+// matchTarget reports whether requested names the allowlisted target and
+// returns the network and address to dial. Unix socket targets are compared
+// by cleaned path and the allowlisted path is the one dialed, so a request
+// cannot reach any other socket by spelling the path differently.
+func matchTarget(allowed, requested string) (network, addr string, ok bool) {
+	allowedPath, allowedUnix := protocol.UnixPath(allowed)
+	requestedPath, requestedUnix := protocol.UnixPath(requested)
+	if !allowedUnix && !requestedUnix {
+		return "tcp", allowed, allowed == requested
+	}
+	if allowedUnix && requestedUnix && filepath.Clean(allowedPath) == filepath.Clean(requestedPath) {
+		return "unix", allowedPath, true
+	}
+	return "", "", false
+}
+
+// namedPort returns the allowed port that names target, or nil. Access
+// rules are not checked.
+func (c *ServerConfig) namedPort(network, target string) *protocol.PortConfig {
+	for i, p := range c.AllowedPorts {
+		if protocol.NetworkOrDefault(p.Network) != network {
+			continue
+		}
+		if _, _, ok := matchTarget(p.Target, target); ok {
+			return &c.AllowedPorts[i]
+		}
+	}
+	return nil
 }
 
 // ============================================================================
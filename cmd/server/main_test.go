@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+func TestMatchTarget(t *testing.T) {
+	tests := []struct {
+		allowed, requested string
+		network, addr      string // Empty when the request is rejected
+	}{
+		{"127.0.0.1:5432", "127.0.0.1:5432", "tcp", "127.0.0.1:5432"},
+		{"127.0.0.1:5432", "127.0.0.1:5433", "", ""},
+		{"unix:///run/app.sock", "unix:///run/app.sock", "unix", "/run/app.sock"},
+		// The allowlisted path is dialed however the request spells it
+		{"unix:///run/app.sock", "unix:///run/./other/../app.sock", "unix", "/run/app.sock"},
+		{"unix:///run/app/../app.sock", "unix:///run/app.sock", "unix", "/run/app/../app.sock"},
+		{"unix:///run/app.sock", "unix:///run/app.sock2", "", ""},
+		{"unix:///run/app.sock", "unix:///run/other.sock", "", ""},
+		{"unix:///run/app.sock", "unix://run/app.sock", "", ""},
+		// Globs are not expanded on either side
+		{"unix:///run/app.sock", "unix:///run/*.sock", "", ""},
+		{"unix:///run/*.sock", "unix:///run/app.sock", "", ""},
+		{"unix:///run/*.sock", "unix:///run/*.sock", "unix", "/run/*.sock"},
+		// A unix target never matches a host:port and the other way round
+		{"unix:///run/app.sock", "/run/app.sock", "", ""},
+		{"127.0.0.1:5432", "unix://127.0.0.1:5432", "", ""},
+	}
+	for _, tt := range tests {
+		network, addr, ok := matchTarget(tt.allowed, tt.requested)
+		if ok != (tt.network != "") || ok && (network != tt.network || addr != tt.addr) {
+			t.Errorf("matchTarget(%q, %q) = %q, %q, %v, want %q, %q", tt.allowed, tt.requested, network, addr, ok, tt.network, tt.addr)
+		}
+	}
+}
+
+func TestAllowTargetUnixOnlyOverTCP(t *testing.T) {
+	cfg := &ServerConfig{AllowedPorts: []protocol.PortConfig{
+		{Name: "app", Target: "unix:///run/app.sock"},
+		{Name: "udp-app", Target: "unix:///run/dgram.sock", Network: protocol.NetworkUDP},
+	}}
+	if network, addr, ok := cfg.allowTarget(protocol.NetworkTCP, "unix:///run/app.sock"); !ok || network != "unix" || addr != "/run/app.sock" {
+		t.Errorf("allowTarget(tcp, app) = %q, %q, %v", network, addr, ok)
+	}
+	if _, _, ok := cfg.allowTarget(protocol.NetworkUDP, "unix:///run/dgram.sock"); ok {
+		t.Error("allowTarget allowed a unix target over udp")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// Metrics
+// ============================================================================
+
+// Metrics holds the process-wide counters, served in the Prometheus text
+// exposition format on metrics_port.
+type Metrics struct {
+	ActiveStreams    int64
+	TotalStreams     int64
+	HandshakeCount   int64
+	ConnectCount     int64
+	ConnectErrors    int64
+	DeniedRequests   int64
+	ActiveUDPFlows   int64
+	TotalUDPFlows    int64
+	ReverseListeners int64
+	ReverseConns     int64
+
+	targetsMu sync.Mutex
+	targets   map[string]*targetStats // Target label -> stats
+}
+
+var metrics = &Metrics{targets: make(map[string]*targetStats)}
+
+// Connection outcomes, the outcome label of ssh_forwarder_connections_total.
+const (
+	outcomeOK        = "ok"
+	outcomeDenied    = "denied"
+	outcomeDialError = "dial_error"
+	outcomeCapacity  = "capacity_exceeded"
+)
+
+// adHocTarget labels targets reached through allow_targets rules rather than
+// a named port, so clients cannot create a series per address.
+const adHocTarget = "ad_hoc"
+
+var (
+	dialBuckets     = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	queueBuckets    = []float64{.01, .05, .1, .5, 1, 2.5, 5, 10, 30, 60, 120}
+	durationBuckets = []float64{.1, 1, 10, 30, 60, 300, 900, 1800, 3600, 14400, 86400}
+)
+
+// targetStats are the metrics of one target label. Upstream bytes flow from
+// the client to the agent side of a stream, downstream bytes back to it.
+type targetStats struct {
+	upstream   atomic.Int64
+	downstream atomic.Int64
+	active     atomic.Int64
+	ok         atomic.Int64
+	denied     atomic.Int64
+	dialError  atomic.Int64
+	capacity   atomic.Int64
+	queued     atomic.Int64 // Connections waiting for a max_connections slot
+
+	idleExpired     atomic.Int64
+	lifetimeExpired atomic.Int64
+
+	dial      *histogram
+	duration  *histogram
+	queueWait *histogram
+}
+
+// target returns the stats for a target label, creating them on first use.
+func (m *Metrics) target(name string) *targetStats {
+	m.targetsMu.Lock()
+	defer m.targetsMu.Unlock()
+	t, ok := m.targets[name]
+	if !ok {
+		t = &targetStats{
+			dial:      newHistogram(dialBuckets),
+			duration:  newHistogram(durationBuckets),
+			queueWait: newHistogram(queueBuckets),
+		}
+		m.targets[name] = t
+	}
+	return t
+}
+
+// outcome counts a connect request for the target.
+func (t *targetStats) outcome(outcome string) {
+	switch outcome {
+	case outcomeOK:
+		t.ok.Add(1)
+	case outcomeDenied:
+		t.denied.Add(1)
+	case outcomeDialError:
+		t.dialError.Add(1)
+	case outcomeCapacity:
+		t.capacity.Add(1)
+	}
+}
+
+// open marks a connection to the target as established and returns a
+// function that records its end.
+func (t *targetStats) open() (done func()) {
+	start := time.Now()
+	t.active.Add(1)
+	return func() {
+		t.active.Add(-1)
+		t.duration.observe(time.Since(start).Seconds())
+	}
+}
+
+// targetLabel names target for metrics: the name of the allowed port it
+// matches, or adHocTarget.
+func (c *ServerConfig) targetLabel(network, target string) string {
+	if p := c.namedPort(network, target); p != nil {
+		return p.Name
+	}
+	return adHocTarget
+}
+
+// histogram is a cumulative Prometheus histogram.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // Upper bounds, ascending
+	counts  []uint64  // Observations per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// write prints the histogram's series with labels, which end in a comma
+// when not empty.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(v)
+}
+
+// labelValue escapes a label value for the exposition format.
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metric := func(name, kind, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+	}
+	metric("ssh_forwarder_active_streams", "gauge", "Streams currently open.", atomic.LoadInt64(&m.ActiveStreams))
+	metric("ssh_forwarder_streams_total", "counter", "Streams accepted.", atomic.LoadInt64(&m.TotalStreams))
+	metric("ssh_forwarder_handshakes_total", "counter", "Handshake requests.", atomic.LoadInt64(&m.HandshakeCount))
+	metric("ssh_forwarder_connect_requests_total", "counter", "Connect requests.", atomic.LoadInt64(&m.ConnectCount))
+	metric("ssh_forwarder_connect_errors_total", "counter", "Connect requests whose target could not be reached.", atomic.LoadInt64(&m.ConnectErrors))
+	metric("ssh_forwarder_denied_requests_total", "counter", "Requests refused by policy or the stream limit.", atomic.LoadInt64(&m.DeniedRequests))
+	metric("ssh_forwarder_active_udp_flows", "gauge", "UDP flows currently open.", atomic.LoadInt64(&m.ActiveUDPFlows))
+	metric("ssh_forwarder_udp_flows_total", "counter", "UDP flows opened.", atomic.LoadInt64(&m.TotalUDPFlows))
+	metric("ssh_forwarder_reverse_listeners", "gauge", "Reverse forward listeners currently open.", atomic.LoadInt64(&m.ReverseListeners))
+	metric("ssh_forwarder_reverse_connections_total", "counter", "Connections accepted on reverse forward listeners.", atomic.LoadInt64(&m.ReverseConns))
+
+	m.targetsMu.Lock()
+	names := make([]string, 0, len(m.targets))
+	for name := range m.targets {
+		names = append(names, name)
+	}
+	targets := make([]*targetStats, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		targets[i] = m.targets[name]
+	}
+	m.targetsMu.Unlock()
+
+	family := func(name, kind, help string, series func(label string, t *targetStats)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for i, t := range targets {
+			series(labelValue.Replace(names[i]), t)
+		}
+	}
+	family("ssh_forwarder_connections_total", "counter", "Connect requests by target and outcome.", func(label string, t *targetStats) {
+		for _, o := range []struct {
+			outcome string
+			n       *atomic.Int64
+		}{{outcomeOK, &t.ok}, {outcomeDenied, &t.denied}, {outcomeDialError, &t.dialError}, {outcomeCapacity, &t.capacity}} {
+			fmt.Fprintf(w, "ssh_forwarder_connections_total{target=\"%s\",outcome=\"%s\"} %d\n", label, o.outcome, o.n.Load())
+		}
+	})
+	family("ssh_forwarder_active_connections", "gauge", "Connections currently open by target.", func(label string, t *targetStats) {
+		fmt.Fprintf(w, "ssh_forwarder_active_connections{target=\"%s\"} %d\n", label, t.active.Load())
+	})
+	family("ssh_forwarder_bytes_total", "counter", "Bytes forwarded by target and direction.", func(label string, t *targetStats) {
+		fmt.Fprintf(w, "ssh_forwarder_bytes_total{target=\"%s\",direction=\"upstream\"} %d\n", label, t.upstream.Load())
+		fmt.Fprintf(w, "ssh_forwarder_bytes_total{target=\"%s\",direction=\"downstream\"} %d\n", label, t.downstream.Load())
+	})
+	family("ssh_forwarder_expired_connections_total", "counter", "Connections closed by idle_timeout or max_lifetime by target and reason.", func(label string, t *targetStats) {
+		fmt.Fprintf(w, "ssh_forwarder_expired_connections_total{target=\"%s\",reason=\"%s\"} %d\n", label, expiredIdle, t.idleExpired.Load())
+		fmt.Fprintf(w, "ssh_forwarder_expired_connections_total{target=\"%s\",reason=\"%s\"} %d\n", label, expiredLifetime, t.lifetimeExpired.Load())
+	})
+	family("ssh_forwarder_queue_depth", "gauge", "Connections waiting for a max_connections slot by target.", func(label string, t *targetStats) {
+		fmt.Fprintf(w, "ssh_forwarder_queue_depth{target=\"%s\"} %d\n", label, t.queued.Load())
+	})
+	family("ssh_forwarder_queue_wait_seconds", "histogram", "Time connections waited in the queue before getting a slot or giving up.", func(label string, t *targetStats) {
+		t.queueWait.write(w, "ssh_forwarder_queue_wait_seconds", fmt.Sprintf("target=\"%s\",", label))
+	})
+	family("ssh_forwarder_dial_duration_seconds", "histogram", "Time to connect to the target.", func(label string, t *targetStats) {
+		t.dial.write(w, "ssh_forwarder_dial_duration_seconds", fmt.Sprintf("target=\"%s\",", label))
+	})
+	family("ssh_forwarder_stream_duration_seconds", "histogram", "Lifetime of forwarded connections.", func(label string, t *targetStats) {
+		t.duration.write(w, "ssh_forwarder_stream_duration_seconds", fmt.Sprintf("target=\"%s\",", label))
+	})
+}
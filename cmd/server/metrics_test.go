@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogramWrite(t *testing.T) {
+	h := newHistogram([]float64{.1, 1, 10})
+	for _, v := range []float64{.05, .1, .5, 20} {
+		h.observe(v)
+	}
+	var b strings.Builder
+	h.write(&b, "x_seconds", `target="db",`)
+	want := `x_seconds_bucket{target="db",le="0.1"} 2
+x_seconds_bucket{target="db",le="1"} 3
+x_seconds_bucket{target="db",le="10"} 3
+x_seconds_bucket{target="db",le="+Inf"} 4
+x_seconds_sum{target="db"} 20.65
+x_seconds_count{target="db"} 4
+`
+	if b.String() != want {
+		t.Errorf("histogram =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	newHistogram([]float64{1}).write(&b, "y", "")
+	if want := "y_bucket{le=\"1\"} 0\ny_bucket{le=\"+Inf\"} 0\ny_sum{} 0\ny_count{} 0\n"; b.String() != want {
+		t.Errorf("empty histogram =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	m := &Metrics{targets: make(map[string]*targetStats), ActiveStreams: 2, TotalStreams: 7}
+	db := m.target("db")
+	db.outcome(outcomeOK)
+	db.outcome(outcomeOK)
+	db.outcome(outcomeCapacity)
+	db.upstream.Add(100)
+	db.downstream.Add(2048)
+	db.dial.observe(.003)
+	done := db.open()
+	m.target(`odd "name"`).outcome(outcomeDenied)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"ssh_forwarder_active_streams 2\n",
+		"ssh_forwarder_streams_total 7\n",
+		`ssh_forwarder_connections_total{target="db",outcome="ok"} 2` + "\n",
+		`ssh_forwarder_connections_total{target="db",outcome="capacity_exceeded"} 1` + "\n",
+		`ssh_forwarder_connections_total{target="odd \"name\"",outcome="denied"} 1` + "\n",
+		`ssh_forwarder_active_connections{target="db"} 1` + "\n",
+		`ssh_forwarder_bytes_total{target="db",direction="upstream"} 100` + "\n",
+		`ssh_forwarder_bytes_total{target="db",direction="downstream"} 2048` + "\n",
+		`ssh_forwarder_expired_connections_total{target="db",reason="idle_timeout"} 0` + "\n",
+		`ssh_forwarder_dial_duration_seconds_bucket{target="db",le="0.001"} 0` + "\n",
+		`ssh_forwarder_dial_duration_seconds_bucket{target="db",le="0.005"} 1` + "\n",
+		`ssh_forwarder_dial_duration_seconds_bucket{target="db",le="+Inf"} 1` + "\n",
+		`ssh_forwarder_dial_duration_seconds_count{target="db"} 1` + "\n",
+		`ssh_forwarder_stream_duration_seconds_count{target="db"} 0` + "\n",
+		"# TYPE ssh_forwarder_queue_wait_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("exposition lacks %q", want)
+		}
+	}
+
+	// Every series follows the TYPE line of its family, and targets are
+	// sorted within a family
+	family, kind := "", ""
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			family, kind, _ = strings.Cut(rest, " ")
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		name, _, _ := strings.Cut(line, "{")
+		name, _, _ = strings.Cut(name, " ")
+		if kind == "histogram" {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base, ok := strings.CutSuffix(name, suffix); ok {
+					name = base
+					break
+				}
+			}
+		}
+		if name != family {
+			t.Errorf("series %q outside its family (last TYPE %s)", line, family)
+		}
+	}
+	if i, j := strings.Index(body, `active_connections{target="db"}`), strings.Index(body, `active_connections{target="odd`); i < 0 || j < i {
+		t.Error("targets not sorted by label")
+	}
+
+	done()
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `ssh_forwarder_stream_duration_seconds_count{target="db"} 1`) {
+		t.Error("closed connection not counted in stream_duration_seconds")
+	}
+}
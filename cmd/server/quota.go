@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// Byte Quotas
+// ============================================================================
+
+// quotaSaveInterval is how often quota usage is written to the state file.
+const quotaSaveInterval = 30 * time.Second
+
+// QuotaConfig limits the bytes each user may forward per calendar day and
+// month, counted in both directions. The state file is fixed at startup;
+// the limits can be reloaded. Usage is charged to the agent's OS user: in
+// stdio mode that is the SSH login user, while in listen mode every client
+// shares the quota of the user the agent runs as.
+type QuotaConfig struct {
+	StateFile    string                `yaml:"state_file"`    // Usage is kept here across restarts and agents (empty = in memory only); %u and ~ expand to the user
+	DailyBytes   int64                 `yaml:"daily_bytes"`   // Per user and day (0 = unlimited)
+	MonthlyBytes int64                 `yaml:"monthly_bytes"` // Per user and month (0 = unlimited)
+	Users        map[string]QuotaLimit `yaml:"users"`         // Limits for specific users, replacing the defaults
+}
+
+type QuotaLimit struct {
+	DailyBytes   int64 `yaml:"daily_bytes"`
+	MonthlyBytes int64 `yaml:"monthly_bytes"`
+}
+
+func (c QuotaConfig) enabled() bool {
+	return c.DailyBytes > 0 || c.MonthlyBytes > 0 || len(c.Users) > 0
+}
+
+func (c QuotaConfig) limitFor(user string) QuotaLimit {
+	if l, ok := c.Users[user]; ok {
+		return l
+	}
+	return QuotaLimit{DailyBytes: c.DailyBytes, MonthlyBytes: c.MonthlyBytes}
+}
+
+// quotaError reports a used up quota; its message is sent to the client.
+type quotaError struct {
+	period string // "Daily" or "Monthly"
+	limit  int64
+	user   string
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("%s quota of %s exceeded for user %s", e.period, formatBytes(e.limit), e.user)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// quotaUsage is a user's usage in the current day and month.
+type quotaUsage struct {
+	Day        string `json:"day"` // 2006-01-02, local time
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"` // 2006-01
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// roll starts a new day or month when the calendar has moved on.
+func (u *quotaUsage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayBytes = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+	}
+}
+
+// add adds v's usage in the day and month of now.
+func (u *quotaUsage) add(v *quotaUsage, now time.Time) {
+	v.roll(now)
+	u.DayBytes += v.DayBytes
+	u.MonthBytes += v.MonthBytes
+}
+
+// exceeded returns the first limit the usage has reached.
+func (u *quotaUsage) exceeded(limit QuotaLimit, user string) error {
+	if limit.DailyBytes > 0 && u.DayBytes >= limit.DailyBytes {
+		return &quotaError{period: "Daily", limit: limit.DailyBytes, user: user}
+	}
+	if limit.MonthlyBytes > 0 && u.MonthBytes >= limit.MonthlyBytes {
+		return &quotaError{period: "Monthly", limit: limit.MonthlyBytes, user: user}
+	}
+	return nil
+}
+
+// quotaStore tracks usage per user for the whole process. In stdio mode
+// every SSH login runs its own agent, so the state file is shared: base is
+// the usage last read from it, pending what this process has used since,
+// and each save adds pending to the file under a lock.
+type quotaStore struct {
+	mu      sync.Mutex
+	path    string
+	base    map[string]*quotaUsage
+	pending map[string]*quotaUsage
+}
+
+var quotas = &quotaStore{
+	base:    make(map[string]*quotaUsage),
+	pending: make(map[string]*quotaUsage),
+}
+
+// quotaState is the state file's format.
+type quotaState struct {
+	Users map[string]*quotaUsage `json:"users"`
+}
+
+// quotaStatePath expands %u to the agent's user name and a leading ~ to
+// its home directory, so state_file can give each user a file of their own.
+func quotaStatePath(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~"); ok && (rest == "" || rest[0] == '/') {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = home + rest
+	}
+	if strings.Contains(path, "%u") {
+		if agentUser == nil {
+			return "", errors.New("%u needs the agent's user, which is unknown")
+		}
+		path = strings.ReplaceAll(path, "%u", agentUser.names[0])
+	}
+	return path, nil
+}
+
+// load reads the state file at path, which is then kept up to date. A
+// missing file starts empty.
+func (q *quotaStore) load(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.path = path
+	q.mu.Unlock()
+	err := q.sync()
+	if errors.Is(err, os.ErrPermission) {
+		err = fmt.Errorf("%w (for a state file per user, put %%u in quotas.state_file)", err)
+	}
+	return err
+}
+
+// saveLoop writes usage to the state file periodically.
+func (q *quotaStore) saveLoop() {
+	ticker := time.NewTicker(quotaSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		q.save()
+	}
+}
+
+// save adds this process's usage to the state file.
+func (q *quotaStore) save() {
+	if err := q.sync(); err != nil {
+		log.Printf("Failed to save quota state: %v", err)
+	}
+}
+
+// sync adds the pending usage to the state file and takes the result, which
+// includes what other agents saved, as the new base. On failure the pending
+// usage is kept for the next attempt.
+func (q *quotaStore) sync() error {
+	q.mu.Lock()
+	if q.path == "" {
+		q.mu.Unlock()
+		return nil
+	}
+	path, pending := q.path, q.pending
+	q.pending = make(map[string]*quotaUsage)
+	q.mu.Unlock()
+
+	users, err := mergeQuotaState(path, pending)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		now := time.Now()
+		for user, p := range pending {
+			q.entry(q.pending, user, now).add(p, now)
+		}
+		return err
+	}
+	q.base = users
+	return nil
+}
+
+// mergeQuotaState adds pending to the usage in the state file at path and
+// returns the result. The lock file serializes agents sharing the state
+// file; the state file itself is replaced atomically.
+func mergeQuotaState(path string, pending map[string]*quotaUsage) (map[string]*quotaUsage, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	unlock, err := lockFile(lock)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	state := quotaState{Users: make(map[string]*quotaUsage)}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	users := make(map[string]*quotaUsage, len(state.Users))
+	for user, u := range state.Users {
+		if u != nil {
+			users[user] = u
+		}
+	}
+	if len(pending) == 0 {
+		return users, nil
+	}
+
+	now := time.Now()
+	for user, p := range pending {
+		u, ok := users[user]
+		if !ok {
+			u = &quotaUsage{}
+			users[user] = u
+		}
+		u.roll(now)
+		u.add(p, now)
+	}
+	data, err = json.MarshalIndent(quotaState{Users: users}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// entry returns user's usage in m, rolled to now.
+func (q *quotaStore) entry(m map[string]*quotaUsage, user string, now time.Time) *quotaUsage {
+	u, ok := m[user]
+	if !ok {
+		u = &quotaUsage{}
+		m[user] = u
+	}
+	u.roll(now)
+	return u
+}
+
+// use adds n bytes to user's usage, which is then checked against limit.
+func (q *quotaStore) use(user string, n int64, limit QuotaLimit) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	p := q.entry(q.pending, user, now)
+	if n > 0 {
+		p.DayBytes += n
+		p.MonthBytes += n
+	}
+	total := *q.entry(q.base, user, now)
+	total.add(p, now)
+	return total.exceeded(limit, user)
+}
+
+// quotaAccount charges a connection's bytes to a user.
+type quotaAccount struct {
+	user   string
+	config *configStore // Limits are read on each charge so reloads apply
+}
+
+// quota returns the account of the agent's user, or nil if quotas are off.
+func (s *Server) quota() *quotaAccount {
+	if !s.config.Load().Quotas.enabled() {
+		return nil
+	}
+	user := "unknown"
+	if agentUser != nil {
+		user = agentUser.names[0]
+	}
+	return &quotaAccount{user: user, config: s.config}
+}
+
+// check fails if the quota is already used up.
+func (a *quotaAccount) check() error {
+	return a.charge(0)
+}
+
+// charge adds n bytes and fails once the quota is used up.
+func (a *quotaAccount) charge(n int64) error {
+	if a == nil {
+		return nil
+	}
+	return quotas.use(a.user, n, a.config.Load().Quotas.limitFor(a.user))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newQuotaStore() *quotaStore {
+	return &quotaStore{
+		base:    make(map[string]*quotaUsage),
+		pending: make(map[string]*quotaUsage),
+	}
+}
+
+func TestQuotaStoreSharedStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	limit := QuotaLimit{DailyBytes: 100}
+
+	// Two agents of the same user, as with two SSH logins
+	a, b := newQuotaStore(), newQuotaStore()
+	for _, q := range []*quotaStore{a, b} {
+		if err := q.load(path); err != nil {
+			t.Fatalf("load: %v", err)
+		}
+	}
+	if err := a.use("alice", 40, limit); err != nil {
+		t.Fatalf("a.use: %v", err)
+	}
+	if err := b.use("alice", 40, limit); err != nil {
+		t.Fatalf("b.use: %v", err)
+	}
+	a.save()
+	b.save()
+
+	// Each save adds to the file rather than replacing the other's usage
+	c := newQuotaStore()
+	if err := c.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := c.base["alice"].DayBytes; got != 80 {
+		t.Errorf("saved usage = %d, want 80", got)
+	}
+
+	// b has seen a's usage since its save, so the quota holds across agents
+	var qe *quotaError
+	if err := b.use("alice", 30, limit); !errors.As(err, &qe) {
+		t.Errorf("b.use past the shared quota = %v, want a quota error", err)
+	}
+}
+
+func TestQuotaStoreInMemory(t *testing.T) {
+	q := newQuotaStore()
+	limit := QuotaLimit{MonthlyBytes: 100}
+	if err := q.use("alice", 60, limit); err != nil {
+		t.Fatalf("use: %v", err)
+	}
+	q.save()
+	if err := q.use("alice", 60, limit); err == nil {
+		t.Error("use past the quota succeeded after a save without a state file")
+	}
+	if err := q.use("bob", 60, limit); err != nil {
+		t.Errorf("use by another user: %v", err)
+	}
+}
+
+func TestQuotaStatePath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	saved := agentUser
+	t.Cleanup(func() { agentUser = saved })
+	agentUser = &identity{names: []string{"alice", "1000"}}
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/var/lib/forwarder/quota.json", "/var/lib/forwarder/quota.json"},
+		{"/var/lib/forwarder/quota-%u.json", "/var/lib/forwarder/quota-alice.json"},
+		{"~/.forwarder/quota.json", home + "/.forwarder/quota.json"},
+		{"~", home},
+		{"~other/quota.json", "~other/quota.json"},
+	}
+	for _, tt := range tests {
+		got, err := quotaStatePath(tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("quotaStatePath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	agentUser = nil
+	if _, err := quotaStatePath("/tmp/quota-%u.json"); err == nil {
+		t.Error("quotaStatePath expanded %u without a known user")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Rate Limiting
+// ============================================================================
+
+// tokenBucket limits a byte rate. A bucket without a limit never blocks, so
+// limits can be added or removed on reload without replacing it.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second, 0 = unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// setLimit applies limit, which may be nil for unlimited.
+func (b *tokenBucket) setLimit(limit *protocol.RateLimit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rate, burst := 0.0, 0.0
+	if limit != nil && limit.BytesPerSec > 0 {
+		rate, burst = float64(limit.BytesPerSec), float64(limit.Burst)
+		if burst <= 0 {
+			burst = rate
+		}
+	}
+	if rate == b.rate && burst == b.burst {
+		return
+	}
+	if b.rate == 0 {
+		b.tokens, b.last = burst, time.Now() // Start full
+	}
+	b.rate, b.burst = rate, burst
+	b.tokens = min(b.tokens, burst)
+}
+
+// wait blocks until n bytes may pass. Requests larger than the burst are
+// let through in burst-sized parts.
+func (b *tokenBucket) wait(n int) {
+	remaining := float64(n)
+	for remaining > 0 {
+		b.mu.Lock()
+		if b.rate == 0 {
+			b.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+
+		need := min(remaining, b.burst)
+		if b.tokens >= need {
+			b.tokens -= need
+			remaining -= need
+			b.mu.Unlock()
+			continue
+		}
+		delay := time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// portLimiters holds a bucket per port, shared by the sessions of this
+// agent process. In stdio mode every login runs its own agent, so the
+// limit applies per login there.
+var portLimiters = struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}{buckets: make(map[string]*tokenBucket)}
+
+// portLimiter returns the bucket of p, updated to its current limit, or nil
+// if p has none.
+func portLimiter(p protocol.PortConfig) *tokenBucket {
+	key := portKey(p)
+	portLimiters.Lock()
+	b, ok := portLimiters.buckets[key]
+	if !ok && p.RateLimit != nil {
+		b = &tokenBucket{}
+		portLimiters.buckets[key] = b
+	}
+	portLimiters.Unlock()
+	if b != nil {
+		b.setLimit(p.RateLimit)
+	}
+	return b
+}
+
+// portKey identifies p among the allowed ports by name, network and
+// target, none of which need be unique on its own, so unrelated ports
+// never share a limit.
+func portKey(p protocol.PortConfig) string {
+	return p.Name + "\x00" + protocol.NetworkOrDefault(p.Network) + "\x00" + p.Target
+}
+
+// throttle applies a connection's rate limits and its user's quota to the
+// bytes it forwards, and notes when bytes last passed for the idle timeout.
+type throttle struct {
+	buckets    []*tokenBucket
+	quota      *quotaAccount // Nil if quotas are off
+	lastActive atomic.Int64  // Unix nanoseconds
+}
+
+// newThrottle combines the session's bucket, the port's bucket if any, and
+// the quota of the agent's user.
+func (s *Server) newThrottle(port *protocol.PortConfig) *throttle {
+	t := &throttle{buckets: []*tokenBucket{s.rate}, quota: s.quota()}
+	t.lastActive.Store(time.Now().UnixNano())
+	if port != nil {
+		if b := portLimiter(*port); b != nil {
+			t.buckets = append(t.buckets, b)
+		}
+	}
+	return t
+}
+
+// take waits until n bytes may pass and charges them to the quota, which
+// fails once the quota is used up.
+func (t *throttle) take(n int) error {
+	for _, b := range t.buckets {
+		b.wait(n)
+	}
+	t.lastActive.Store(time.Now().UnixNano())
+	return t.quota.charge(int64(n))
+}
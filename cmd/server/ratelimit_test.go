@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// timeWait returns how long b.wait(n) blocks.
+func timeWait(b *tokenBucket, n int) time.Duration {
+	start := time.Now()
+	b.wait(n)
+	return time.Since(start)
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	var b tokenBucket
+	if d := timeWait(&b, 1<<30); d > 10*time.Millisecond {
+		t.Errorf("bucket without a limit blocked for %v", d)
+	}
+	b.setLimit(&protocol.RateLimit{BytesPerSec: 0})
+	if d := timeWait(&b, 1<<30); d > 10*time.Millisecond {
+		t.Errorf("bucket with a zero rate blocked for %v", d)
+	}
+}
+
+func TestTokenBucketBurstAndRefill(t *testing.T) {
+	var b tokenBucket
+	b.setLimit(&protocol.RateLimit{BytesPerSec: 10000, Burst: 1000})
+
+	// A new bucket starts full
+	if d := timeWait(&b, 1000); d > 10*time.Millisecond {
+		t.Errorf("burst blocked for %v", d)
+	}
+	// Then bytes pass at the rate: 500 bytes take 50ms
+	if d := timeWait(&b, 500); d < 40*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("500 bytes from an empty bucket took %v, want about 50ms", d)
+	}
+	// Idle time refills it, up to the burst
+	time.Sleep(100 * time.Millisecond)
+	if d := timeWait(&b, 1000); d > 10*time.Millisecond {
+		t.Errorf("refilled burst blocked for %v", d)
+	}
+}
+
+func TestTokenBucketLargeWrite(t *testing.T) {
+	var b tokenBucket
+	b.setLimit(&protocol.RateLimit{BytesPerSec: 10000, Burst: 1000})
+
+	// More than the burst passes in parts: 1000 at once, 2000 at the rate
+	if d := timeWait(&b, 3000); d < 160*time.Millisecond || d > time.Second {
+		t.Errorf("3000 bytes took %v, want about 200ms", d)
+	}
+}
+
+func TestTokenBucketDefaultBurst(t *testing.T) {
+	var b tokenBucket
+	b.setLimit(&protocol.RateLimit{BytesPerSec: 5000})
+	if b.burst != 5000 || b.tokens != 5000 {
+		t.Errorf("burst = %v, tokens = %v, want one second's worth", b.burst, b.tokens)
+	}
+}
+
+func TestTokenBucketReload(t *testing.T) {
+	var b tokenBucket
+	b.setLimit(&protocol.RateLimit{BytesPerSec: 10000, Burst: 1000})
+	b.wait(1000)
+
+	// Lowering the burst caps the tokens; removing the limit unblocks
+	b.setLimit(&protocol.RateLimit{BytesPerSec: 100, Burst: 100})
+	if b.tokens > 100 {
+		t.Errorf("tokens = %v after lowering the burst to 100", b.tokens)
+	}
+	b.setLimit(nil)
+	if d := timeWait(&b, 1<<20); d > 10*time.Millisecond {
+		t.Errorf("bucket blocked for %v after its limit was removed", d)
+	}
+}
+
+func TestPortLimiterKey(t *testing.T) {
+	limit := &protocol.RateLimit{BytesPerSec: 1000}
+	web := protocol.PortConfig{Name: "web", Target: "10.0.0.1:80", RateLimit: limit}
+	sameName := protocol.PortConfig{Name: "web", Target: "10.0.0.2:80", RateLimit: limit}
+	unnamed := protocol.PortConfig{Target: "10.0.0.3:80", RateLimit: limit}
+	unnamedUDP := protocol.PortConfig{Target: "10.0.0.3:80", Network: protocol.NetworkUDP, RateLimit: limit}
+
+	if portLimiter(web) != portLimiter(web) {
+		t.Error("a port got a new bucket on each connection")
+	}
+	if portLimiter(web) == portLimiter(sameName) {
+		t.Error("ports with the same name share a bucket")
+	}
+	if portLimiter(unnamed) == portLimiter(unnamedUDP) {
+		t.Error("TCP and UDP ports with the same target share a bucket")
+	}
+	if b := portLimiter(protocol.PortConfig{Name: "free", Target: "10.0.0.4:80"}); b != nil {
+		t.Error("port without rate_limit got a bucket")
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Config Reload
+// ============================================================================
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// configStore holds the current configuration, shared by every session and
+// swapped atomically on reload. Sessions subscribe to hear about reloads.
+type configStore struct {
+	atomic.Pointer[ServerConfig]
+
+	path           string
+	listenOverride string // --listen, which takes precedence over listen.address
+	data           []byte // File contents of the current config
+
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func newConfigStore(path, listenOverride string, cfg *ServerConfig) *configStore {
+	c := &configStore{
+		path:           path,
+		listenOverride: listenOverride,
+		subscribers:    make(map[chan struct{}]struct{}),
+	}
+	c.data, _ = os.ReadFile(path)
+	c.Store(cfg)
+	return c
+}
+
+// watch reloads the config on SIGHUP and whenever the file changes.
+func (c *configStore) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Printf("SIGHUP received, reloading %s", c.path)
+			if err := c.reload(true); err != nil {
+				log.Printf("Reload failed, keeping the current config: %v", err)
+			}
+		case <-ticker.C:
+			if err := c.reload(false); err != nil {
+				log.Printf("Reload failed, keeping the current config: %v", err)
+			}
+		}
+	}
+}
+
+// reload parses and validates the config file and makes it current. Unless
+// forced, an unchanged or missing file is ignored. Only watch calls it.
+func (c *configStore) reload(force bool) error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if !force && errors.Is(err, os.ErrNotExist) {
+			return nil // Possibly mid-rename by an editor
+		}
+		return err
+	}
+	if !force && bytes.Equal(data, c.data) {
+		return nil
+	}
+	c.data = data
+
+	next, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	// Process-wide settings are fixed at startup
+	prev := c.Load()
+	if c.listenOverride != "" {
+		next.Listen.Address = c.listenOverride
+	}
+	if next.Listen != prev.Listen || next.MetricsPort != prev.MetricsPort || next.MaxStreams != prev.MaxStreams ||
+		next.Audit != prev.Audit || next.Quotas.StateFile != prev.Quotas.StateFile {
+		log.Printf("Note: listen, metrics_port, max_streams, audit and quotas.state_file changes take effect after a restart")
+		next.Listen, next.MetricsPort, next.MaxStreams, next.Audit = prev.Listen, prev.MetricsPort, prev.MaxStreams, prev.Audit
+		next.Quotas.StateFile = prev.Quotas.StateFile
+	}
+
+	c.Store(next)
+	log.Printf("Reloaded %s: %d allowed targets, %d allowed binds", c.path, len(next.AllowedPorts), len(next.AllowedBinds))
+	c.notify()
+	return nil
+}
+
+// notify wakes subscribers after a reload or a health status change.
+func (c *configStore) notify() {
+	c.mu.Lock()
+	for ch := range c.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default: // Already pending
+		}
+	}
+	c.mu.Unlock()
+}
+
+// subscribe returns a channel that receives after each reload or health
+// status change. Notifications in quick succession may be coalesced.
+func (c *configStore) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	c.mu.Lock()
+	c.subscribers[ch] = struct{}{}
+	c.mu.Unlock()
+	return ch
+}
+
+func (c *configStore) unsubscribe(ch chan struct{}) {
+	c.mu.Lock()
+	delete(c.subscribers, ch)
+	c.mu.Unlock()
+}
+
+// validate rejects configs that would fail at connect time, so a bad edit
+// is caught when it is loaded.
+func (c *ServerConfig) validate() error {
+	if c.MaxStreams <= 0 {
+		return errors.New("max_streams must be positive")
+	}
+	for i, p := range c.AllowedPorts {
+		network := protocol.NetworkOrDefault(p.Network)
+		if network != protocol.NetworkTCP && network != protocol.NetworkUDP {
+			return fmt.Errorf("allowed_ports[%d] (%s): unsupported network %q", i, p.Name, p.Network)
+		}
+		if err := validateRateLimit(p.RateLimit); err != nil {
+			return fmt.Errorf("allowed_ports[%d] (%s): rate_limit: %v", i, p.Name, err)
+		}
+		if p.MaxConnections < 0 || p.QueueSize < 0 || p.QueueTimeout < 0 {
+			return fmt.Errorf("allowed_ports[%d] (%s): max_connections, queue_size and queue_timeout must not be negative", i, p.Name)
+		}
+		if p.IdleTimeout != nil && *p.IdleTimeout < 0 || p.MaxLifetime != nil && *p.MaxLifetime < 0 {
+			return fmt.Errorf("allowed_ports[%d] (%s): idle_timeout and max_lifetime must not be negative", i, p.Name)
+		}
+		if h := p.HealthCheck; h != nil {
+			if h.Interval < 0 || h.Timeout < 0 {
+				return fmt.Errorf("allowed_ports[%d] (%s): health_check interval and timeout must not be negative", i, p.Name)
+			}
+			if h.HTTPPath != "" && !strings.HasPrefix(h.HTTPPath, "/") {
+				return fmt.Errorf("allowed_ports[%d] (%s): health_check http_path must start with /", i, p.Name)
+			}
+		}
+		if _, unix := protocol.UnixPath(p.Target); unix {
+			if network != protocol.NetworkTCP {
+				return fmt.Errorf("allowed_ports[%d] (%s): unix targets must use tcp", i, p.Name)
+			}
+			continue
+		}
+		if _, _, err := net.SplitHostPort(p.Target); err != nil {
+			return fmt.Errorf("allowed_ports[%d] (%s): %v", i, p.Name, err)
+		}
+	}
+	if c.DrainTimeout < 0 {
+		return errors.New("drain_timeout must not be negative")
+	}
+	if err := validateRateLimit(c.SessionRateLimit); err != nil {
+		return fmt.Errorf("session_rate_limit: %v", err)
+	}
+	if c.Quotas.DailyBytes < 0 || c.Quotas.MonthlyBytes < 0 {
+		return errors.New("quotas must not be negative")
+	}
+	for i, rule := range c.AllowedBinds {
+		if _, _, _, err := parseBindRule(rule); err != nil {
+			return fmt.Errorf("allowed_binds[%d]: %v", i, err)
+		}
+	}
+	c.allowRules, c.denyRules = nil, nil
+	for i, rule := range c.AllowTargets {
+		r, err := parseTargetRule(rule)
+		if err != nil {
+			return fmt.Errorf("allow_targets[%d]: %v", i, err)
+		}
+		c.allowRules = append(c.allowRules, r)
+	}
+	for i, rule := range c.DenyTargets {
+		r, err := parseTargetRule(rule)
+		if err != nil {
+			return fmt.Errorf("deny_targets[%d]: %v", i, err)
+		}
+		c.denyRules = append(c.denyRules, r)
+	}
+	return nil
+}
+
+func validateRateLimit(l *protocol.RateLimit) error {
+	if l != nil && (l.BytesPerSec < 0 || l.Burst < 0) {
+		return errors.New("bytes_per_sec and burst must not be negative")
+	}
+	return nil
+}
+
+// activeConn is an open connect stream and what it was allowed as.
+type activeConn struct {
+	network string
+	target  string
+	close   io.Closer // Closing it ends the connection
+}
+
+func (s *Server) track(stream net.Conn, network, target string, c io.Closer) {
+	s.connsMu.Lock()
+	s.conns[stream] = &activeConn{network: network, target: target, close: c}
+	s.connsMu.Unlock()
+}
+
+func (s *Server) untrack(stream net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, stream)
+	s.connsMu.Unlock()
+}
+
+// watchConfig applies reloads to the session's open connections until it
+// ends.
+func (s *Server) watchConfig() {
+	updates := s.config.subscribe()
+	defer s.config.unsubscribe(updates)
+	applied := s.config.Load()
+	for {
+		select {
+		case <-s.session.CloseChan():
+			return
+		case <-updates:
+		}
+		cfg := s.config.Load()
+		if cfg == applied {
+			continue // A health status change
+		}
+		applied = cfg
+		s.rate.setLimit(cfg.SessionRateLimit)
+		if cfg.TerminateRemoved {
+			s.closeRemoved(cfg)
+		}
+	}
+}
+
+// closeRemoved ends connections and reverse forwards that cfg no longer
+// allows.
+func (s *Server) closeRemoved(cfg *ServerConfig) {
+	// Rules may need DNS, so check outside the lock
+	s.connsMu.Lock()
+	conns := make([]*activeConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connsMu.Unlock()
+	for _, c := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+		_, _, err := cfg.authorize(ctx, c.network, c.target)
+		cancel()
+		if errors.Is(err, errTargetDenied) {
+			log.Printf("Closing connection to %s: no longer allowed", c.target)
+			c.close.Close()
+		}
+	}
+
+	s.reverseMu.Lock()
+	for _, r := range s.reverse {
+		if !bindAllowed(cfg.AllowedBinds, r.ln.Addr().String()) {
+			log.Printf("Closing reverse forward on %s: no longer allowed", r.ln.Addr())
+			r.stream.Close()
+		}
+	}
+	s.reverseMu.Unlock()
+}
+
+// handleWatch pushes the configuration as the client sees it in the
+// handshake, once on subscribing and again after every reload or health
+// status change, until the client closes the stream. When the agent starts
+// draining it pushes a last update marked Draining and closes the stream.
+func (s *Server) handleWatch(stream net.Conn) {
+	updates := s.config.subscribe()
+	defer s.config.unsubscribe(updates)
+
+	// The client sends nothing more; EOF or a reset means it is done
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, stream)
+		close(closed)
+	}()
+
+	encoder := json.NewEncoder(stream)
+	for {
+		resp := handshakeResponse(s.config.Load())
+		resp.Draining = shutdown.draining()
+		if err := encoder.Encode(resp); err != nil || resp.Draining {
+			return
+		}
+		select {
+		case <-updates:
+		case <-shutdown.start:
+		case <-closed:
+			return
+		}
+	}
+}
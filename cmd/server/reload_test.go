@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+	"ssh-forwarder/pkg/tunnel"
+
+	"github.com/hashicorp/yamux"
+)
+
+func TestValidatePortFields(t *testing.T) {
+	negative := -time.Second
+	tests := []struct {
+		name string
+		port protocol.PortConfig
+		want string // Expected error substring; empty for a valid port
+	}{
+		{"valid", protocol.PortConfig{}, ""},
+		{"rate_limit", protocol.PortConfig{RateLimit: &protocol.RateLimit{BytesPerSec: -1}}, "rate_limit"},
+		{"max_connections", protocol.PortConfig{MaxConnections: -1}, "max_connections"},
+		{"queue_timeout", protocol.PortConfig{QueueTimeout: -time.Second}, "queue_timeout"},
+		{"idle_timeout", protocol.PortConfig{IdleTimeout: &negative}, "idle_timeout"},
+		{"max_lifetime", protocol.PortConfig{MaxLifetime: &negative}, "max_lifetime"},
+		{"health_check interval", protocol.PortConfig{HealthCheck: &protocol.HealthCheck{Interval: -time.Second}}, "health_check"},
+		{"health_check http_path", protocol.PortConfig{HealthCheck: &protocol.HealthCheck{HTTPPath: "health"}}, "http_path"},
+	}
+	// Unix targets skip only the host:port check
+	for _, target := range []string{"127.0.0.1:5432", "unix:///run/app.sock"} {
+		for _, tt := range tests {
+			p := tt.port
+			p.Name, p.Target = "app", target
+			cfg := ServerConfig{MaxStreams: 1, AllowedPorts: []protocol.PortConfig{p}}
+			err := cfg.validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("%s, %s: validate = %v", target, tt.name, err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s, %s: validate = %v, want an error about %s", target, tt.name, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		network, target string
+		ok              bool
+	}{
+		{"", "127.0.0.1:5432", true},
+		{"udp", "127.0.0.1:53", true},
+		{"", "no-port", false},
+		{"sctp", "127.0.0.1:5432", false},
+		{"", "unix:///run/app.sock", true},
+		{"udp", "unix:///run/app.sock", false},
+	}
+	for _, tt := range tests {
+		p := protocol.PortConfig{Name: "app", Network: tt.network, Target: tt.target}
+		cfg := ServerConfig{MaxStreams: 1, AllowedPorts: []protocol.PortConfig{p}}
+		if err := cfg.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate(%s %s) = %v, want ok %v", tt.network, tt.target, err, tt.ok)
+		}
+	}
+}
+
+// pingStream opens a stream to an echo target and checks it echoes.
+func pingStream(t *testing.T, session *yamux.Session, target string) net.Conn {
+	t.Helper()
+	stream, err := tunnel.OpenTarget(session, target)
+	if err != nil {
+		t.Fatalf("OpenTarget(%s): %v", target, err)
+	}
+	t.Cleanup(func() { stream.Close() })
+	checkEcho(t, stream)
+	return stream
+}
+
+// checkEcho sends a line on stream and waits for it to come back.
+func checkEcho(t *testing.T, stream net.Conn) {
+	t.Helper()
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+	defer stream.SetDeadline(time.Time{})
+	if _, err := io.WriteString(stream, "ping\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "ping\n" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+}
+
+func TestReloadClosesRemovedTargets(t *testing.T) {
+	kept, removed := startEcho(t), startEcho(t)
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	writeConfig := func(targets ...string) {
+		text := "terminate_removed: true\nallowed_ports:\n"
+		for i, target := range targets {
+			text += fmt.Sprintf("  - name: echo%d\n    target: %q\n", i, target)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(kept, removed)
+	cfg, err := parseConfig(mustRead(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Listen.Token = "s3cret"
+	addr, configs := startListenModeConfig(t, cfg, path)
+
+	session, err := tunnel.DialDirect(tunnel.DirectConfig{Address: addr, Token: "s3cret", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("DialDirect: %v", err)
+	}
+	defer session.Close()
+	if _, err := tunnel.Handshake(session); err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	keptStream := pingStream(t, session, kept)
+	removedStream := pingStream(t, session, removed)
+
+	writeConfig(kept)
+	if err := configs.reload(true); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	removedStream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ne net.Error
+	if n, err := removedStream.Read(make([]byte, 1)); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Errorf("stream to the removed target still open after reload: read %d, %v", n, err)
+	}
+	checkEcho(t, keptStream)
+	if _, err := tunnel.OpenTarget(session, removed); err == nil {
+		t.Error("OpenTarget succeeded for the removed target")
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Reverse Forwarding
+// ============================================================================
+
+// reverseListener is a reverse forward's listener and the client stream
+// that keeps it open.
+type reverseListener struct {
+	ln     net.Listener
+	stream net.Conn
+}
+
+// handleListen serves a reverse forward: it listens on the requested
+// address and hands each accepted connection to the client on a stream the
+// agent opens. The listener is closed when the client closes the request
+// stream or the session ends.
+func (s *Server) handleListen(stream net.Conn, req protocol.ListenRequest) {
+	resp := protocol.ListenResponse{}
+	rec := &auditStream{Network: "tcp", Target: req.Address, Decision: decisionAllow}
+	start := time.Now()
+	defer func() {
+		rec.Error = resp.Error
+		rec.DurationMS = time.Since(start).Milliseconds()
+		s.audit(auditListen, rec)
+	}()
+
+	if !bindAllowed(s.config.Load().AllowedBinds, req.Address) {
+		rec.Decision = decisionDeny
+		resp.Error = fmt.Sprintf("Bind address %s not allowed", req.Address)
+		json.NewEncoder(stream).Encode(resp)
+		log.Printf("Denied reverse bind on %s", req.Address)
+		atomic.AddInt64(&metrics.DeniedRequests, 1)
+		return
+	}
+
+	s.reverseMu.Lock()
+	if _, exists := s.reverse[req.ID]; exists || req.ID == "" {
+		s.reverseMu.Unlock()
+		resp.Error = fmt.Sprintf("Invalid or duplicate reverse forward ID %q", req.ID)
+		json.NewEncoder(stream).Encode(resp)
+		return
+	}
+	ln, err := net.Listen("tcp", req.Address)
+	if err != nil {
+		s.reverseMu.Unlock()
+		resp.Error = fmt.Sprintf("Listen failed: %v", err)
+		json.NewEncoder(stream).Encode(resp)
+		log.Printf("Failed to listen on %s: %v", req.Address, err)
+		return
+	}
+	s.reverse[req.ID] = &reverseListener{ln: ln, stream: stream}
+	s.reverseMu.Unlock()
+	atomic.AddInt64(&metrics.ReverseListeners, 1)
+
+	defer func() {
+		ln.Close()
+		s.reverseMu.Lock()
+		delete(s.reverse, req.ID)
+		s.reverseMu.Unlock()
+		atomic.AddInt64(&metrics.ReverseListeners, -1)
+		log.Printf("Stopped reverse forward on %s", ln.Addr())
+	}()
+
+	resp.Success = true
+	resp.Address = ln.Addr().String()
+	if err := json.NewEncoder(stream).Encode(resp); err != nil {
+		return
+	}
+	log.Printf("Reverse forward listening on %s", ln.Addr())
+	stats := metrics.target("reverse:" + req.Address)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return // Listener closed
+			}
+			atomic.AddInt64(&s.activeCount, 1)
+			go s.forwardReverse(conn, req.ID, stats, req.Address)
+		}
+	}()
+
+	// The client sends nothing more; EOF or a reset means it is done
+	io.Copy(io.Discard, stream)
+}
+
+// forwardReverse opens a stream to the client for a connection accepted on
+// a reverse forward listener and bridges the two.
+func (s *Server) forwardReverse(conn net.Conn, id string, stats *targetStats, bind string) {
+	defer atomic.AddInt64(&s.activeCount, -1)
+	defer conn.Close()
+
+	rec := &auditStream{Network: "tcp", Target: bind, Decision: decisionAllow}
+	start := time.Now()
+	defer func() {
+		rec.DurationMS = time.Since(start).Milliseconds()
+		s.audit(auditForwarded, rec)
+	}()
+
+	stream, err := s.session.Open()
+	if err != nil {
+		log.Printf("Failed to open reverse stream: %v", err)
+		rec.Error = err.Error()
+		return
+	}
+	defer stream.Close()
+
+	msg := protocol.Message{
+		Type:    protocol.MsgTypeForwarded,
+		Payload: protocol.ForwardedConn{ID: id, Remote: conn.RemoteAddr().String()},
+	}
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		return
+	}
+	atomic.AddInt64(&metrics.ReverseConns, 1)
+	stats.outcome(outcomeOK)
+	defer stats.open()()
+
+	if rec.BytesUp, rec.BytesDown, err = proxy(stream, conn, stats, s.newThrottle(nil)); err != nil {
+		rec.Error = err.Error()
+	}
+}
+
+// bindAllowed reports whether addr matches one of the allowed_binds rules,
+// each "host:port" or "host:first-last". Hosts must match exactly, so a
+// rule for 127.0.0.1 does not allow 0.0.0.0.
+func bindAllowed(rules []string, addr string) bool {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false
+	}
+
+	for _, rule := range rules {
+		ruleHost, lo, hi, err := parseBindRule(rule)
+		if err == nil && ruleHost == host && port >= lo && port <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBindRule splits an allowed_binds rule into its host and port range.
+func parseBindRule(rule string) (host string, lo, hi int, err error) {
+	host, ports, err := net.SplitHostPort(rule)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	first, last, ok := strings.Cut(ports, "-")
+	if !ok {
+		last = first
+	}
+	lo, err1 := strconv.Atoi(first)
+	hi, err2 := strconv.Atoi(last)
+	if err1 != nil || err2 != nil || lo < 0 || lo > hi || hi > 65535 {
+		return "", 0, 0, fmt.Errorf("invalid port range %q", ports)
+	}
+	return host, lo, hi, nil
+}
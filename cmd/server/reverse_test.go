@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseBindRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		host    string
+		lo, hi  int
+		wantErr bool
+	}{
+		{"127.0.0.1:8080", "127.0.0.1", 8080, 8080, false},
+		{"0.0.0.0:9000-9010", "0.0.0.0", 9000, 9010, false},
+		{"[::1]:0-65535", "::1", 0, 65535, false},
+		{"localhost:22", "localhost", 22, 22, false},
+		{"127.0.0.1", "", 0, 0, true},
+		{"127.0.0.1:abc", "", 0, 0, true},
+		{"127.0.0.1:9010-9000", "", 0, 0, true},
+		{"127.0.0.1:1-70000", "", 0, 0, true},
+		{"127.0.0.1:-1", "", 0, 0, true},
+	}
+	for _, tt := range tests {
+		host, lo, hi, err := parseBindRule(tt.rule)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBindRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (host != tt.host || lo != tt.lo || hi != tt.hi) {
+			t.Errorf("parseBindRule(%q) = %q, %d, %d, want %q, %d, %d", tt.rule, host, lo, hi, tt.host, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestBindAllowed(t *testing.T) {
+	rules := []string{"127.0.0.1:8080", "0.0.0.0:9000-9010", "[::1]:7000", "bad rule"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"127.0.0.1:8081", false},
+		{"0.0.0.0:9000", true},
+		{"0.0.0.0:9010", true},
+		{"0.0.0.0:9011", false},
+		{"0.0.0.0:8080", false}, // Wildcard binds must be allowed explicitly
+		{"127.0.0.1:9005", false},
+		{"[::1]:7000", true},
+		{"localhost:8080", false}, // Hosts are compared literally
+		{"127.0.0.1", false},
+		{"127.0.0.1:http", false},
+	}
+	for _, tt := range tests {
+		if got := bindAllowed(rules, tt.addr); got != tt.want {
+			t.Errorf("bindAllowed(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if bindAllowed(nil, "127.0.0.1:8080") {
+		t.Error("bindAllowed with no rules = true, want false")
+	}
+}
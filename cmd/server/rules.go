@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Target Rules
+// ============================================================================
+
+// errTargetDenied is returned by authorize for targets outside the policy.
+var errTargetDenied = errors.New("target not allowed")
+
+// targetRule is a parsed allow_targets or deny_targets entry:
+// "[udp://]host:ports", where host is an IP, a CIDR, a hostname, a
+// "*.domain" wildcard or "*", and ports is a port, "first-last" or "*".
+type targetRule struct {
+	network string       // protocol.NetworkTCP or protocol.NetworkUDP
+	any     bool         // "*" matches every host and address
+	name    string       // Exact hostname, lower case
+	suffix  string       // ".domain" of a "*.domain" wildcard
+	prefix  netip.Prefix // IP or CIDR
+	lo, hi  int
+}
+
+func parseTargetRule(s string) (targetRule, error) {
+	r := targetRule{network: protocol.NetworkTCP}
+	rest := s
+	if after, ok := strings.CutPrefix(rest, "udp://"); ok {
+		r.network, rest = protocol.NetworkUDP, after
+	} else {
+		rest = strings.TrimPrefix(rest, "tcp://")
+	}
+
+	host, ports, err := net.SplitHostPort(rest)
+	if err != nil {
+		return r, fmt.Errorf("%q: %v", s, err)
+	}
+	if ports == "*" {
+		r.lo, r.hi = 0, 65535
+	} else {
+		first, last, ok := strings.Cut(ports, "-")
+		if !ok {
+			last = first
+		}
+		lo, err1 := strconv.Atoi(first)
+		hi, err2 := strconv.Atoi(last)
+		if err1 != nil || err2 != nil || lo < 0 || lo > hi || hi > 65535 {
+			return r, fmt.Errorf("%q: invalid ports %q", s, ports)
+		}
+		r.lo, r.hi = lo, hi
+	}
+
+	host = strings.ToLower(host)
+	switch {
+	case host == "*":
+		r.any = true
+	case strings.HasPrefix(host, "*."):
+		r.suffix = host[1:]
+	case strings.Contains(host, "/"):
+		if r.prefix, err = netip.ParsePrefix(host); err != nil {
+			return r, fmt.Errorf("%q: %v", s, err)
+		}
+		r.prefix = r.prefix.Masked()
+	default:
+		if ip, err := netip.ParseAddr(host); err == nil {
+			r.prefix = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+		} else if host == "" || strings.Contains(host, "*") {
+			return r, fmt.Errorf("%q: invalid host", s)
+		} else {
+			r.name = strings.TrimSuffix(host, ".")
+		}
+	}
+	return r, nil
+}
+
+// matchName reports whether the rule matches a requested hostname.
+func (r targetRule) matchName(network, host string, port int) bool {
+	if r.network != network || port < r.lo || port > r.hi {
+		return false
+	}
+	return r.any || r.name != "" && r.name == host || r.suffix != "" && strings.HasSuffix(host, r.suffix)
+}
+
+// matchIP reports whether the rule matches a resolved address.
+func (r targetRule) matchIP(network string, ip netip.Addr, port int) bool {
+	if r.network != network || port < r.lo || port > r.hi {
+		return false
+	}
+	return r.any || r.prefix.IsValid() && r.prefix.Contains(ip)
+}
+
+// authorize decides whether target may be reached over network and returns
+// what to dial. Named allowed_ports are matched as before; when target rules
+// are configured, the target is also resolved and only the addresses that
+// pass them are returned, so the name cannot be rebound to a denied
+// address between the check and the dial. Deny rules win over every allow,
+// and a named port the agent's user may not use is not reachable through
+// the rules either.
+func (c *ServerConfig) authorize(ctx context.Context, network, target string) (dialNetwork string, dialAddrs []string, err error) {
+	dialNetwork, dialAddr, named := c.allowTarget(network, target)
+	if named && dialNetwork == "unix" {
+		return dialNetwork, []string{dialAddr}, nil
+	}
+	if !named && c.namedPort(network, target) != nil {
+		return "", nil, errTargetDenied
+	}
+	if len(c.allowRules) == 0 && len(c.denyRules) == 0 {
+		if !named {
+			return "", nil, errTargetDenied
+		}
+		return dialNetwork, []string{dialAddr}, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", nil, errTargetDenied
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", nil, errTargetDenied
+	}
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+
+	nameAllowed := named
+	for _, r := range c.denyRules {
+		if r.matchName(network, name, port) {
+			return "", nil, errTargetDenied
+		}
+	}
+	for _, r := range c.allowRules {
+		if r.matchName(network, name, port) {
+			nameAllowed = true
+			break
+		}
+	}
+
+	ips, err := resolveHost(ctx, host)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, ip := range ips {
+		if !named && c.restrictedAddr(network, ip, port) {
+			continue
+		}
+		if c.ipAllowed(network, ip, port, nameAllowed) {
+			dialAddrs = append(dialAddrs, net.JoinHostPort(ip.String(), portStr))
+		}
+	}
+	if len(dialAddrs) == 0 {
+		return "", nil, errTargetDenied
+	}
+	return network, dialAddrs, nil
+}
+
+// ipAllowed applies the rules to one resolved address of a target.
+func (c *ServerConfig) ipAllowed(network string, ip netip.Addr, port int, nameAllowed bool) bool {
+	for _, r := range c.denyRules {
+		if r.matchIP(network, ip, port) {
+			return false
+		}
+	}
+	if nameAllowed {
+		return true
+	}
+	for _, r := range c.allowRules {
+		if r.matchIP(network, ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictedAddr reports whether ip and port are the target of a named
+// port the agent's user may not use, and of none it may use. Ports with a
+// hostname target are matched by name only, in authorize.
+func (c *ServerConfig) restrictedAddr(network string, ip netip.Addr, port int) bool {
+	restricted := false
+	for _, p := range c.AllowedPorts {
+		if protocol.NetworkOrDefault(p.Network) != network {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(p.Target)
+		if err != nil || portStr != strconv.Itoa(port) {
+			continue
+		}
+		if addr, err := netip.ParseAddr(host); err != nil || addr.Unmap() != ip {
+			continue
+		}
+		if agentUser.permits(p) {
+			return false
+		}
+		restricted = true
+	}
+	return restricted
+}
+
+// resolveHost returns the addresses of host, which may be an IP literal.
+func resolveHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip.Unmap()}, nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, ip := range ips {
+		ips[i] = ip.Unmap()
+	}
+	return ips, nil
+}
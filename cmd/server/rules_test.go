@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+func TestParseTargetRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    targetRule
+		wantErr bool
+	}{
+		{"10.0.0.5:22", targetRule{network: "tcp", prefix: netip.MustParsePrefix("10.0.0.5/32"), lo: 22, hi: 22}, false},
+		{"tcp://10.0.0.0/8:8000-8999", targetRule{network: "tcp", prefix: netip.MustParsePrefix("10.0.0.0/8"), lo: 8000, hi: 8999}, false},
+		{"udp://10.1.2.3/16:53", targetRule{network: "udp", prefix: netip.MustParsePrefix("10.1.0.0/16"), lo: 53, hi: 53}, false},
+		{"[::ffff:10.0.0.1]:80", targetRule{network: "tcp", prefix: netip.MustParsePrefix("10.0.0.1/32"), lo: 80, hi: 80}, false},
+		{"[fd00::/8]:*", targetRule{network: "tcp", prefix: netip.MustParsePrefix("fd00::/8"), lo: 0, hi: 65535}, false},
+		{"*.Example.com:443", targetRule{network: "tcp", suffix: ".example.com", lo: 443, hi: 443}, false},
+		{"DB.internal.:5432", targetRule{network: "tcp", name: "db.internal", lo: 5432, hi: 5432}, false},
+		{"*:*", targetRule{network: "tcp", any: true, lo: 0, hi: 65535}, false},
+		{"10.0.0.5", targetRule{}, true},
+		{"10.0.0.5:http", targetRule{}, true},
+		{"10.0.0.5:90-80", targetRule{}, true},
+		{"10.0.0.5:1-65536", targetRule{}, true},
+		{"10.0.0.0/33:22", targetRule{}, true},
+		{"db*.internal:22", targetRule{}, true},
+		{":22", targetRule{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTargetRule(tt.rule)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTargetRule(%q) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseTargetRule(%q) = %+v, want %+v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+// rulesConfig returns a validated config with the given target rules.
+func rulesConfig(t *testing.T, ports []protocol.PortConfig, allow, deny []string) *ServerConfig {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.AllowedPorts = ports
+	cfg.AllowTargets = allow
+	cfg.DenyTargets = deny
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestAuthorize(t *testing.T) {
+	cfg := rulesConfig(t,
+		[]protocol.PortConfig{
+			{Name: "db", Target: "10.9.9.9:5432"},
+			{Name: "blocked", Target: "10.0.0.66:22"},
+			{Name: "dns", Target: "10.0.0.53:53", Network: "udp"},
+		},
+		[]string{"10.0.0.0/24:22", "10.0.1.0/24:8000-8099", "udp://10.0.2.0/24:*", "[fd00::/8]:443", "*.local.test:80"},
+		[]string{"10.0.0.66:*", "10.0.1.50:8080", "[fd00::bad]:*"},
+	)
+	tests := []struct {
+		network string
+		target  string
+		want    []string // Addresses to dial; nil means denied
+	}{
+		{"tcp", "10.0.0.5:22", []string{"10.0.0.5:22"}},
+		{"tcp", "10.0.0.5:23", nil}, // Port outside the rule
+		{"tcp", "10.0.3.5:22", nil}, // Address outside the CIDR
+		{"tcp", "10.0.1.7:8000", []string{"10.0.1.7:8000"}},
+		{"tcp", "10.0.1.7:8099", []string{"10.0.1.7:8099"}},
+		{"tcp", "10.0.1.7:8100", nil},
+		{"tcp", "10.0.1.50:8080", nil}, // Deny wins over the allow range
+		{"tcp", "10.0.1.50:8081", []string{"10.0.1.50:8081"}},
+		{"tcp", "10.0.0.66:22", nil},                        // Deny wins over the CIDR and allowed_ports
+		{"tcp", "10.9.9.9:5432", []string{"10.9.9.9:5432"}}, // Named allowed_ports still work
+		{"udp", "10.0.2.9:5353", []string{"10.0.2.9:5353"}},
+		{"tcp", "10.0.2.9:5353", nil}, // The rule is UDP only
+		{"udp", "10.0.0.5:22", nil},   // The rule is TCP only
+		{"udp", "10.0.0.53:53", []string{"10.0.0.53:53"}},
+		{"tcp", "[fd00::1]:443", []string{"[fd00::1]:443"}},
+		{"tcp", "[fd00::bad]:443", nil},
+		{"tcp", "[::ffff:10.0.0.5]:22", []string{"10.0.0.5:22"}}, // Mapped addresses cannot dodge rules
+		{"tcp", "not-a-target", nil},
+	}
+	for _, tt := range tests {
+		_, addrs, err := cfg.authorize(context.Background(), tt.network, tt.target)
+		if tt.want == nil {
+			if !errors.Is(err, errTargetDenied) {
+				t.Errorf("authorize(%s, %s) = %v, %v, want denied", tt.network, tt.target, addrs, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(addrs, tt.want) {
+			t.Errorf("authorize(%s, %s) = %v, %v, want %v", tt.network, tt.target, addrs, err, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeHostnames(t *testing.T) {
+	ctx := context.Background()
+
+	// A name allowed by a wildcard is dialed at its resolved addresses
+	cfg := rulesConfig(t, nil, []string{"*.localhost:80", "localhost:8080"}, nil)
+	if _, addrs, err := cfg.authorize(ctx, "tcp", "LocalHost:8080"); err != nil || len(addrs) == 0 {
+		t.Errorf("authorize(localhost:8080) = %v, %v, want allowed", addrs, err)
+	}
+	if _, _, err := cfg.authorize(ctx, "tcp", "localhost:80"); !errors.Is(err, errTargetDenied) {
+		t.Errorf("authorize(localhost:80) error = %v, want denied: *.localhost does not match localhost", err)
+	}
+
+	// A deny on the resolved address wins over an allowed name
+	cfg = rulesConfig(t, nil, []string{"localhost:8080"}, []string{"127.0.0.0/8:*", "[::1]:*"})
+	if _, addrs, err := cfg.authorize(ctx, "tcp", "localhost:8080"); !errors.Is(err, errTargetDenied) {
+		t.Errorf("authorize(localhost:8080) = %v, %v, want denied by address", addrs, err)
+	}
+
+	// A deny on the name wins over an allowed address
+	cfg = rulesConfig(t, nil, []string{"127.0.0.0/8:*", "[::1]:*"}, []string{"localhost:*"})
+	if _, addrs, err := cfg.authorize(ctx, "tcp", "localhost:8080"); !errors.Is(err, errTargetDenied) {
+		t.Errorf("authorize(localhost:8080) = %v, %v, want denied by name", addrs, err)
+	}
+	if _, addrs, err := cfg.authorize(ctx, "tcp", "127.0.0.1:8080"); err != nil || !slices.Equal(addrs, []string{"127.0.0.1:8080"}) {
+		t.Errorf("authorize(127.0.0.1:8080) = %v, %v, want allowed", addrs, err)
+	}
+}
+
+func TestAuthorizeWithoutRules(t *testing.T) {
+	cfg := rulesConfig(t, []protocol.PortConfig{
+		{Name: "web", Target: "10.0.0.5:80"},
+		{Name: "docker", Target: "unix:///var/run/docker.sock"},
+	}, nil, nil)
+	tests := []struct {
+		target      string
+		wantNetwork string
+		wantAddr    string
+	}{
+		{"10.0.0.5:80", "tcp", "10.0.0.5:80"},
+		{"unix:///var/run/../run/docker.sock", "unix", "/var/run/docker.sock"},
+		{"10.0.0.5:81", "", ""},
+		{"unix:///var/run/other.sock", "", ""},
+	}
+	for _, tt := range tests {
+		network, addrs, err := cfg.authorize(context.Background(), "tcp", tt.target)
+		if tt.wantAddr == "" {
+			if !errors.Is(err, errTargetDenied) {
+				t.Errorf("authorize(%s) = %v, %v, want denied", tt.target, addrs, err)
+			}
+			continue
+		}
+		if err != nil || network != tt.wantNetwork || !slices.Equal(addrs, []string{tt.wantAddr}) {
+			t.Errorf("authorize(%s) = %s %v, %v, want %s %s", tt.target, network, addrs, err, tt.wantNetwork, tt.wantAddr)
+		}
+	}
+}
+
+func TestAuthorizeRestrictedNamedPort(t *testing.T) {
+	saved := agentUser
+	t.Cleanup(func() { agentUser = saved })
+	agentUser = &identity{names: []string{"alice", "1000"}, groups: []string{"dev"}}
+
+	cfg := rulesConfig(t,
+		[]protocol.PortConfig{
+			{Name: "ops-ssh", Target: "10.0.0.7:22", Groups: []string{"ops"}},
+			{Name: "web", Target: "10.0.0.8:80", DenyUsers: []string{"alice"}},
+			{Name: "shared", Target: "10.0.0.9:80", Users: []string{"bob"}},
+			{Name: "shared-dev", Target: "10.0.0.9:80", Groups: []string{"dev"}},
+			{Name: "wiki", Target: "wiki.internal:80", Users: []string{"bob"}},
+		},
+		[]string{"10.0.0.0/24:*", "*.internal:*"},
+		nil,
+	)
+	tests := []struct {
+		target string
+		want   []string // Addresses to dial; nil means denied
+	}{
+		// The CIDR rule does not open ports the user is kept out of
+		{"10.0.0.7:22", nil},
+		{"[::ffff:10.0.0.7]:22", nil},
+		{"10.0.0.8:80", nil},
+		{"wiki.internal:80", nil},
+		// Other ports on the same hosts are up to the rules
+		{"10.0.0.7:2222", []string{"10.0.0.7:2222"}},
+		{"10.0.0.8:443", []string{"10.0.0.8:443"}},
+		// A port the user may use through another entry stays reachable
+		{"10.0.0.9:80", []string{"10.0.0.9:80"}},
+	}
+	for _, tt := range tests {
+		_, addrs, err := cfg.authorize(context.Background(), "tcp", tt.target)
+		if tt.want == nil {
+			if !errors.Is(err, errTargetDenied) {
+				t.Errorf("authorize(%s) = %v, %v, want denied", tt.target, addrs, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(addrs, tt.want) {
+			t.Errorf("authorize(%s) = %v, %v, want %v", tt.target, addrs, err, tt.want)
+		}
+	}
+}
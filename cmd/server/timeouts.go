@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// Connection Timeouts
+// ============================================================================
+
+// Reasons a connection expired, the reason label of
+// ssh_forwarder_expired_connections_total.
+const (
+	expiredIdle     = "idle_timeout"
+	expiredLifetime = "max_lifetime"
+)
+
+// connTimeouts returns the idle timeout and max lifetime of a connection to
+// port, which is nil for targets allowed by rules. Zero means none.
+func (c *ServerConfig) connTimeouts(port *protocol.PortConfig) (idle, lifetime time.Duration) {
+	idle, lifetime = c.IdleTimeout, c.MaxLifetime
+	if port != nil && port.IdleTimeout != nil {
+		idle = *port.IdleTimeout
+	}
+	if port != nil && port.MaxLifetime != nil {
+		lifetime = *port.MaxLifetime
+	}
+	return idle, lifetime
+}
+
+// expireConn closes stream and targetConn once no bytes have passed th for
+// idle, or lifetime after the call; zero disables either. The returned stop
+// function ends the watch and reports why the connection expired, if it
+// did.
+func expireConn(target string, stream, targetConn net.Conn, th *throttle, stats *targetStats, idle, lifetime time.Duration) (stop func() string) {
+	if idle <= 0 && lifetime <= 0 {
+		return func() string { return "" }
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	var reason string
+	go func() {
+		defer close(finished)
+		start := time.Now()
+		interval := max(min(positive(idle), positive(lifetime))/4, time.Second)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for reason == "" {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if lifetime > 0 && now.Sub(start) >= lifetime {
+					reason = expiredLifetime
+				} else if idle > 0 && now.UnixNano()-th.lastActive.Load() >= int64(idle) {
+					reason = expiredIdle
+				}
+			}
+		}
+
+		if reason == expiredLifetime {
+			log.Printf("Closing connection to %s: max lifetime of %s reached", target, lifetime)
+			stats.lifetimeExpired.Add(1)
+		} else {
+			log.Printf("Closing connection to %s: idle for %s", target, idle)
+			stats.idleExpired.Add(1)
+		}
+		stream.Close()
+		targetConn.Close()
+	}()
+
+	return func() string {
+		close(done)
+		<-finished
+		return reason
+	}
+}
+
+// positive returns d, or the largest duration if d is not positive, for
+// taking the minimum of optional timeouts.
+func positive(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return d
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeConns returns the agent's ends of a stream and a target connection,
+// and the peer ends, which see the agent's ends close.
+func pipeConns(t *testing.T) (stream, target, streamPeer, targetPeer net.Conn) {
+	t.Helper()
+	stream, streamPeer = net.Pipe()
+	target, targetPeer = net.Pipe()
+	t.Cleanup(func() {
+		for _, c := range []net.Conn{stream, target, streamPeer, targetPeer} {
+			c.Close()
+		}
+	})
+	return stream, target, streamPeer, targetPeer
+}
+
+// waitClosed fails unless the agent's end of conn's pipe closes within
+// timeout.
+func waitClosed(t *testing.T, conn net.Conn, timeout time.Duration) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var ne net.Error
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("connection still open after %s: %v", timeout, err)
+	}
+}
+
+func newTestThrottle() *throttle {
+	th := &throttle{}
+	th.lastActive.Store(time.Now().UnixNano())
+	return th
+}
+
+func TestExpireConnIdle(t *testing.T) {
+	stream, target, streamPeer, targetPeer := pipeConns(t)
+	stats := &targetStats{}
+	stop := expireConn("db", stream, target, newTestThrottle(), stats, time.Second, 0)
+
+	waitClosed(t, streamPeer, 5*time.Second)
+	waitClosed(t, targetPeer, time.Second)
+	if reason := stop(); reason != expiredIdle {
+		t.Errorf("reason = %q, want %q", reason, expiredIdle)
+	}
+	if got := stats.idleExpired.Load(); got != 1 {
+		t.Errorf("idle expirations = %d, want 1", got)
+	}
+	if got := stats.lifetimeExpired.Load(); got != 0 {
+		t.Errorf("lifetime expirations = %d, want 0", got)
+	}
+}
+
+func TestExpireConnActivityResetsIdle(t *testing.T) {
+	stream, target, streamPeer, _ := pipeConns(t)
+	th := newTestThrottle()
+	stats := &targetStats{}
+	stop := expireConn("db", stream, target, th, stats, 1500*time.Millisecond, 0)
+
+	// Bytes pass every 200ms for longer than the idle timeout
+	for end := time.Now().Add(2500 * time.Millisecond); time.Now().Before(end); {
+		if err := th.take(1); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if got := stats.idleExpired.Load(); got != 0 {
+		t.Fatal("active connection expired as idle")
+	}
+
+	// Once they stop, the connection expires
+	waitClosed(t, streamPeer, 5*time.Second)
+	if reason := stop(); reason != expiredIdle {
+		t.Errorf("reason = %q, want %q", reason, expiredIdle)
+	}
+}
+
+func TestExpireConnLifetime(t *testing.T) {
+	stream, target, streamPeer, targetPeer := pipeConns(t)
+	th := newTestThrottle()
+	stats := &targetStats{}
+	start := time.Now()
+	stop := expireConn("db", stream, target, th, stats, time.Hour, time.Second)
+
+	// Activity does not extend the lifetime
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Millisecond):
+				th.take(1)
+			}
+		}
+	}()
+
+	waitClosed(t, streamPeer, 5*time.Second)
+	waitClosed(t, targetPeer, time.Second)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("closed after %s, before the max lifetime", elapsed)
+	}
+	if reason := stop(); reason != expiredLifetime {
+		t.Errorf("reason = %q, want %q", reason, expiredLifetime)
+	}
+	if got := stats.lifetimeExpired.Load(); got != 1 {
+		t.Errorf("lifetime expirations = %d, want 1", got)
+	}
+}
+
+func TestExpireConnStop(t *testing.T) {
+	stream, target, streamPeer, _ := pipeConns(t)
+	stats := &targetStats{}
+	stop := expireConn("db", stream, target, newTestThrottle(), stats, time.Second, time.Second)
+	if reason := stop(); reason != "" {
+		t.Errorf("reason = %q for a connection that ended first", reason)
+	}
+
+	// The watch is over, so the connection stays open
+	go streamPeer.Write([]byte("x"))
+	stream.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := stream.Read(make([]byte, 1)); err != nil {
+		t.Errorf("connection closed after stop: %v", err)
+	}
+	if stats.idleExpired.Load()+stats.lifetimeExpired.Load() != 0 {
+		t.Error("expiration counted after stop")
+	}
+}
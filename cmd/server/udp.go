@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ============================================================================
+// UDP Forwarding
+// ============================================================================
+
+// udpRelay serves one "udp" connect stream. Datagrams from the client are
+// framed with a flow ID per client-side source; each flow gets its own UDP
+// socket to the target, so replies can be routed back like a NAT would.
+// Flows without traffic for UDPIdleTimeout are expired.
+type udpRelay struct {
+	stream net.Conn
+	target *net.UDPAddr
+	idle   time.Duration
+	stats  *targetStats
+	th     *throttle
+	up     atomic.Int64 // Bytes client to target
+	down   atomic.Int64 // Bytes target to client
+
+	writeMu sync.Mutex // Serializes frames written to the stream
+
+	mu    sync.Mutex
+	flows map[uint32]*udpFlow
+}
+
+type udpFlow struct {
+	conn       *net.UDPConn
+	lastActive atomic.Int64 // Unix nanoseconds
+}
+
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+// handleUDP relays datagrams for a connect stream to target through th,
+// recording the outcome in rec.
+func (s *Server) handleUDP(stream net.Conn, target string, stats *targetStats, th *throttle, rec *auditStream) {
+	resp := protocol.ConnectResponse{}
+	addr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		resp.Error = fmt.Sprintf("Resolve failed: %v", err)
+		resp.Code = protocol.CodeHostUnreachable
+		json.NewEncoder(stream).Encode(resp)
+		log.Printf("Failed to resolve %s: %v", target, err)
+		atomic.AddInt64(&metrics.ConnectErrors, 1)
+		stats.outcome(outcomeDialError)
+		rec.Error, rec.Code = resp.Error, resp.Code
+		return
+	}
+	stats.outcome(outcomeOK)
+
+	resp.Success = true
+	if err := json.NewEncoder(stream).Encode(resp); err != nil {
+		return
+	}
+
+	r := &udpRelay{
+		stream: stream,
+		target: addr,
+		idle:   s.config.Load().UDPIdleTimeout,
+		stats:  stats,
+		th:     th,
+		flows:  make(map[uint32]*udpFlow),
+	}
+	done := stats.open()
+	r.run()
+	done()
+	rec.BytesUp, rec.BytesDown = r.up.Load(), r.down.Load()
+	log.Printf("Closed UDP relay to %s", target)
+}
+
+// run relays client datagrams until the stream closes.
+func (r *udpRelay) run() {
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		r.mu.Lock()
+		for id, flow := range r.flows {
+			r.closeFlowLocked(id, flow)
+		}
+		r.mu.Unlock()
+	}()
+	go r.expire(done)
+
+	payload := make([]byte, protocol.MaxDatagramSize)
+	for {
+		id, n, err := protocol.ReadDatagram(r.stream, payload)
+		if err != nil {
+			return
+		}
+		flow, err := r.flow(id)
+		if err != nil {
+			log.Printf("Failed to open UDP flow to %s: %v", r.target, err)
+			continue
+		}
+		flow.touch()
+		if err := r.th.take(n); err != nil {
+			log.Printf("Closing UDP relay to %s: %v", r.target, err)
+			return
+		}
+		if _, err := flow.conn.Write(payload[:n]); err != nil {
+			log.Printf("UDP write to %s failed: %v", r.target, err)
+			continue
+		}
+		r.stats.upstream.Add(int64(n))
+		r.up.Add(int64(n))
+	}
+}
+
+// flow returns the socket for flow id, opening it on first use.
+func (r *udpRelay) flow(id uint32) (*udpFlow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if flow, ok := r.flows[id]; ok {
+		return flow, nil
+	}
+
+	conn, err := net.DialUDP("udp", nil, r.target)
+	if err != nil {
+		return nil, err
+	}
+	flow := &udpFlow{conn: conn}
+	flow.touch()
+	r.flows[id] = flow
+	atomic.AddInt64(&metrics.ActiveUDPFlows, 1)
+	atomic.AddInt64(&metrics.TotalUDPFlows, 1)
+	go r.readFlow(id, flow)
+	return flow, nil
+}
+
+// readFlow sends replies from the target back to the client as frames
+// tagged with the flow's ID.
+func (r *udpRelay) readFlow(id uint32, flow *udpFlow) {
+	buf := make([]byte, protocol.MaxDatagramSize)
+	for {
+		n, err := flow.conn.Read(buf)
+		if err != nil {
+			// Closed by expiry or relay shutdown; a refused port (ICMP) also
+			// ends the flow, the next datagram reopens it
+			r.mu.Lock()
+			if r.flows[id] == flow {
+				r.closeFlowLocked(id, flow)
+			}
+			r.mu.Unlock()
+			return
+		}
+		flow.touch()
+		if err := r.th.take(n); err != nil {
+			log.Printf("Closing UDP relay to %s: %v", r.target, err)
+			r.stream.Close()
+			return
+		}
+
+		r.writeMu.Lock()
+		err = protocol.WriteDatagram(r.stream, id, buf[:n])
+		r.writeMu.Unlock()
+		if err != nil {
+			r.stream.Close()
+			return
+		}
+		r.stats.downstream.Add(int64(n))
+		r.down.Add(int64(n))
+	}
+}
+
+// expire closes flows idle for longer than the timeout. A zero timeout
+// keeps flows until the stream closes.
+func (r *udpRelay) expire(done chan struct{}) {
+	if r.idle <= 0 {
+		return
+	}
+	interval := max(r.idle/4, time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-r.idle).UnixNano()
+		r.mu.Lock()
+		for id, flow := range r.flows {
+			if flow.lastActive.Load() < cutoff {
+				r.closeFlowLocked(id, flow)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *udpRelay) closeFlowLocked(id uint32, flow *udpFlow) {
+	flow.conn.Close()
+	delete(r.flows, id)
+	atomic.AddInt64(&metrics.ActiveUDPFlows, -1)
+}
@@ -6,5 +6,9 @@ toolchain go1.24.13
 
 require (
 	github.com/hashicorp/yamux v0.1.2
+	golang.org/x/crypto v0.33.0
+	golang.org/x/term v0.29.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.30.0 // indirect
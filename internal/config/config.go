@@ -8,15 +8,61 @@ import (
 )
 
 type ForwardRule struct {
-	Local  string `yaml:"local"`
-	Remote string `yaml:"remote"`
+	Local   string `yaml:"local"`
+	Remote  string `yaml:"remote"`
+	Network string `yaml:"network"` // "tcp" (default) or "udp"
+}
+
+// ReverseRule asks the agent to listen on Remote and forwards each
+// connection it accepts to Local on this machine.
+type ReverseRule struct {
+	Remote string `yaml:"remote"` // Bind address on the server, must be in its allowed_binds
+	Local  string `yaml:"local"`  // Local target: host:port or unix:///path
+}
+
+// JumpHost is a bastion the connection is tunnelled through (like ProxyJump).
+// User and KeyFile default to the top-level values.
+type JumpHost struct {
+	Host       string `yaml:"host"`
+	User       string `yaml:"user"`
+	KeyFile    string `yaml:"key_file"`
+	TOTPSecret string `yaml:"totp_secret"`
+	HostKey    string `yaml:"host_key"`
+}
+
+// AgentTLS configures TLS to a server-agent in listen mode. TLS is used when
+// any field is set; Cert and Key present a client certificate.
+type AgentTLS struct {
+	CA         string `yaml:"ca"`          // CA for the agent certificate (default: system roots)
+	Cert       string `yaml:"cert"`        // Client certificate for mutual TLS
+	Key        string `yaml:"key"`         // Client private key for mutual TLS
+	ServerName string `yaml:"server_name"` // Name to verify (default: host of agent_address)
+}
+
+// Enabled reports whether any TLS option is set.
+func (t AgentTLS) Enabled() bool {
+	return t != AgentTLS{}
 }
 
 type Config struct {
-	Server  string        `yaml:"server"`
-	User    string        `yaml:"user"`
-	KeyFile string        `yaml:"key_file"`
-	Forwards []ForwardRule `yaml:"forwards"`
+	Server     string     `yaml:"server"`
+	User       string     `yaml:"user"`
+	KeyFile    string     `yaml:"key_file"`
+	TOTPSecret string     `yaml:"totp_secret"` // Base32 TOTP secret for keyboard-interactive one-time codes
+	SSHAgent   string     `yaml:"ssh_agent"`   // ssh-agent socket (default: $SSH_AUTH_SOCK, "none" disables)
+	AgentPath  string     `yaml:"agent_path"`  // Remote path to server-agent (default: ./server-agent)
+	HostKey    string     `yaml:"host_key"`    // SHA256 fingerprint to trust if the host is not yet known
+	KnownHosts string     `yaml:"known_hosts"` // App-managed known_hosts file (default: <config dir>/ssh-forwarder/known_hosts)
+	JumpHosts  []JumpHost `yaml:"jump_hosts"`  // Bastions to dial through, in order
+	// Direct connection to a server-agent in listen mode; SSH settings are
+	// ignored when AgentAddress is set
+	AgentAddress string        `yaml:"agent_address"` // tcp://host:port, unix:///path or host:port
+	AgentToken   string        `yaml:"agent_token"`   // Pre-shared token (or SSH_FORWARDER_AGENT_TOKEN)
+	AgentTLS     AgentTLS      `yaml:"agent_tls"`
+	Forwards     []ForwardRule `yaml:"forwards"`
+	Reverse      []ReverseRule `yaml:"reverse"`    // Remote-to-local forwards
+	SOCKS        string        `yaml:"socks"`      // Local SOCKS5 proxy address; the agent's allowlist still applies
+	HTTPProxy    string        `yaml:"http_proxy"` // Local HTTP proxy address routing service hostnames (e.g. gitlab-web.tunnel)
 }
 
 func LoadConfig(path string) (*Config, error) {
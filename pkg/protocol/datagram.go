@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxDatagramSize is the largest UDP payload a datagram frame can carry.
+const MaxDatagramSize = 65535
+
+// datagramHeader is the frame header size: flow ID (uint32) and payload
+// length (uint16), both big endian.
+const datagramHeader = 6
+
+// WriteDatagram frames one UDP packet on a "udp" connect stream so packet
+// boundaries survive the byte stream. Flow identifies the client-side
+// source the packet belongs to; the agent keeps one UDP socket per flow,
+// like a NAT mapping. The frame is written with a single Write call.
+func WriteDatagram(w io.Writer, flow uint32, payload []byte) error {
+	if len(payload) > MaxDatagramSize {
+		return fmt.Errorf("datagram of %d bytes exceeds %d", len(payload), MaxDatagramSize)
+	}
+	frame := make([]byte, datagramHeader+len(payload))
+	binary.BigEndian.PutUint32(frame, flow)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(payload)))
+	copy(frame[datagramHeader:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadDatagram reads the next frame into buf, which must hold
+// MaxDatagramSize bytes, and returns its flow ID and payload length.
+func ReadDatagram(r io.Reader, buf []byte) (flow uint32, n int, err error) {
+	var header [datagramHeader]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	flow = binary.BigEndian.Uint32(header[:])
+	n = int(binary.BigEndian.Uint16(header[4:]))
+	if n > len(buf) {
+		return 0, 0, fmt.Errorf("datagram of %d bytes exceeds buffer", n)
+	}
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return 0, 0, err
+	}
+	return flow, n, nil
+}
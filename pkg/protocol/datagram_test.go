@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDatagramRoundTrip(t *testing.T) {
+	frames := []struct {
+		flow    uint32
+		payload []byte
+	}{
+		{0, []byte("hello")},
+		{1, nil},
+		{0xffffffff, bytes.Repeat([]byte{0xab}, 1500)},
+		{42, bytes.Repeat([]byte{1}, MaxDatagramSize)},
+	}
+
+	var stream bytes.Buffer
+	for _, f := range frames {
+		if err := WriteDatagram(&stream, f.flow, f.payload); err != nil {
+			t.Fatalf("WriteDatagram(%d, %d bytes): %v", f.flow, len(f.payload), err)
+		}
+	}
+
+	buf := make([]byte, MaxDatagramSize)
+	for _, f := range frames {
+		flow, n, err := ReadDatagram(&stream, buf)
+		if err != nil {
+			t.Fatalf("ReadDatagram: %v", err)
+		}
+		if flow != f.flow || !bytes.Equal(buf[:n], f.payload) {
+			t.Errorf("ReadDatagram = flow %d, %d bytes, want flow %d, %d bytes", flow, n, f.flow, len(f.payload))
+		}
+	}
+	if _, _, err := ReadDatagram(&stream, buf); err != io.EOF {
+		t.Errorf("ReadDatagram at end of stream: error = %v, want EOF", err)
+	}
+}
+
+// countingWriter records the size of every Write call.
+type countingWriter struct {
+	writes []int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return len(p), nil
+}
+
+func TestWriteDatagramSingleWrite(t *testing.T) {
+	w := &countingWriter{}
+	if err := WriteDatagram(w, 7, []byte("payload")); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 1 || w.writes[0] != datagramHeader+len("payload") {
+		t.Errorf("writes = %v, want one write of the whole frame", w.writes)
+	}
+}
+
+func TestWriteDatagramTooLarge(t *testing.T) {
+	w := &countingWriter{}
+	if err := WriteDatagram(w, 1, make([]byte, MaxDatagramSize+1)); err == nil {
+		t.Fatal("WriteDatagram accepted an oversized payload")
+	}
+	if len(w.writes) != 0 {
+		t.Errorf("oversized datagram was written: %v", w.writes)
+	}
+}
+
+func TestReadDatagramErrors(t *testing.T) {
+	var frame bytes.Buffer
+	WriteDatagram(&frame, 3, []byte("abcdef"))
+	data := frame.Bytes()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		bufSize int
+		want    error
+	}{
+		{"empty", nil, MaxDatagramSize, io.EOF},
+		{"short header", data[:3], MaxDatagramSize, io.ErrUnexpectedEOF},
+		{"short payload", data[:len(data)-2], MaxDatagramSize, io.ErrUnexpectedEOF},
+		{"small buffer", data, 4, nil},
+	}
+	for _, tt := range tests {
+		_, _, err := ReadDatagram(bytes.NewReader(tt.data), make([]byte, tt.bufSize))
+		if err == nil {
+			t.Errorf("%s: ReadDatagram succeeded", tt.name)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
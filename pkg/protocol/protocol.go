@@ -1,15 +1,60 @@
 package protocol
 
+import (
+	"path"
+	"strings"
+	"time"
+)
+
 const (
 	MsgTypeHandshake = "handshake"
 	MsgTypeConnect   = "connect"
+	MsgTypeAuth      = "auth"
+	MsgTypeListen    = "listen"    // Client asks the agent to listen for a reverse forward
+	MsgTypeForwarded = "forwarded" // Agent hands the client a connection accepted for a reverse forward
+	MsgTypeWatch     = "watch"     // Client subscribes to configuration updates
+)
+
+// Networks a target can be forwarded over. An empty network means TCP.
+const (
+	NetworkTCP = "tcp"
+	NetworkUDP = "udp"
 )
 
+// TargetUnix is the target type of a "unix:///path" target, a stream
+// socket on the agent host. It is reported in PortConfig.Type.
+const TargetUnix = "unix"
+
 type Message struct {
 	Type    string `json:"type"`
 	Payload any    `json:"payload"`
 }
 
+// AuthRequest is the first message on a direct (listen mode) connection,
+// sent before the yamux session starts. SSH transports skip it.
+type AuthRequest struct {
+	Token string `json:"token,omitempty"`
+}
+
+type AuthResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SplitAddress parses a listen mode address: "tcp://host:port",
+// "unix:///path/to.sock" or a bare "host:port" (TCP).
+func SplitAddress(addr string) (network, address string) {
+	if path, ok := UnixPath(addr); ok {
+		return "unix", path
+	}
+	return "tcp", strings.TrimPrefix(addr, "tcp://")
+}
+
+// UnixPath returns the socket path of a "unix:///path" address.
+func UnixPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, "unix://")
+}
+
 type HandshakeRequest struct {
 	Version string `json:"version"`
 }
@@ -20,19 +65,162 @@ type PortConfig struct {
 	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 	Static      bool   `json:"static,omitempty" yaml:"static,omitempty"`
 	LocalPort   int    `json:"local_port,omitempty" yaml:"local_port,omitempty"`
+	Network     string `json:"network,omitempty" yaml:"network,omitempty"`   // "tcp" (default) or "udp"
+	Hostname    string `json:"hostname,omitempty" yaml:"hostname,omitempty"` // Name for the client HTTP proxy (default: derived from Name)
+	Type        string `json:"type,omitempty" yaml:"-"`                      // Set in handshake responses: "tcp", "udp" or "unix"
+
+	// Health is the latest health check result, set in handshake responses
+	// once the agent has checked the port. HealthCheck configures the
+	// checks and is never sent to clients.
+	Health      *PortHealth  `json:"health,omitempty" yaml:"-"`
+	HealthCheck *HealthCheck `json:"-" yaml:"health_check,omitempty"`
+
+	// Access control by the agent's OS user (names or IDs). DenyUsers
+	// always wins; a port listing Users or Groups is limited to them.
+	// Never sent to clients.
+	Users     []string `json:"-" yaml:"users,omitempty"`
+	Groups    []string `json:"-" yaml:"groups,omitempty"`
+	DenyUsers []string `json:"-" yaml:"deny_users,omitempty"`
+
+	// Bandwidth shared by the connections to the port through one agent
+	// process, which in stdio mode serves a single login. Never sent to
+	// clients.
+	RateLimit *RateLimit `json:"-" yaml:"rate_limit,omitempty"`
+
+	// Concurrent connections to the port through one agent process (0 =
+	// unlimited), which in stdio mode serves a single login. Up to
+	// QueueSize further connections wait up to QueueTimeout for a slot
+	// (default 30s); others are refused with CodeCapacityExceeded. Never
+	// sent to clients.
+	MaxConnections int           `json:"-" yaml:"max_connections,omitempty"`
+	QueueSize      int           `json:"-" yaml:"queue_size,omitempty"`
+	QueueTimeout   time.Duration `json:"-" yaml:"queue_timeout,omitempty"`
+
+	// Timeouts of connections to the port, overriding the agent's
+	// idle_timeout and max_lifetime; 0 disables them. Never sent to
+	// clients.
+	IdleTimeout *time.Duration `json:"-" yaml:"idle_timeout,omitempty"`
+	MaxLifetime *time.Duration `json:"-" yaml:"max_lifetime,omitempty"`
+}
+
+// HealthCheck configures the agent's checks of a TCP or unix socket port:
+// a connect, followed by a GET request when HTTPPath is set, which fails on
+// a 5xx status. UDP ports are not checked.
+type HealthCheck struct {
+	Disabled bool          `yaml:"disabled,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`  // Default: 30s
+	Timeout  time.Duration `yaml:"timeout,omitempty"`   // Default: 5s
+	HTTPPath string        `yaml:"http_path,omitempty"` // e.g. "/-/health"
+}
+
+// Health check results.
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// PortHealth is the result of a port's latest health check.
+type PortHealth struct {
+	Status    string    `json:"status"` // HealthUp or HealthDown
+	CheckedAt time.Time `json:"checked_at"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RateLimit is a token bucket limit on bytes forwarded in either direction.
+type RateLimit struct {
+	BytesPerSec int64 `yaml:"bytes_per_sec"`
+	Burst       int64 `yaml:"burst,omitempty"` // Largest burst in bytes (default: one second's worth)
+}
+
+// TargetType classifies the port's target: TargetUnix for "unix:///path"
+// targets, otherwise its network.
+func (p PortConfig) TargetType() string {
+	if _, ok := UnixPath(p.Target); ok {
+		return TargetUnix
+	}
+	return NetworkOrDefault(p.Network)
+}
+
+// HasTarget reports whether ports allows target on network. Unix socket
+// paths are compared cleaned, as the agent compares them.
+func HasTarget(ports []PortConfig, network, target string) bool {
+	network = NetworkOrDefault(network)
+	for _, p := range ports {
+		if NetworkOrDefault(p.Network) != network {
+			continue
+		}
+		want, wantUnix := UnixPath(target)
+		have, haveUnix := UnixPath(p.Target)
+		if wantUnix && haveUnix && path.Clean(want) == path.Clean(have) || !wantUnix && p.Target == target {
+			return true
+		}
+	}
+	return false
 }
 
 type HandshakeResponse struct {
 	Version      string       `json:"version"`
 	AllowedPorts []PortConfig `json:"allowed_ports"`
+	AllowedBinds []string     `json:"allowed_binds,omitempty"` // Remote addresses reverse forwards may claim, e.g. "127.0.0.1:9000-9100"
 	Error        string       `json:"error,omitempty"`
+
+	// Draining is set in the last config update of an agent that is shutting
+	// down: it refuses new streams and closes the session once the open ones
+	// finish or its grace period ends. Clients should then reconnect.
+	Draining bool `json:"draining,omitempty"`
 }
 
 type ConnectRequest struct {
-	Target string `json:"target"`
+	Target  string `json:"target"`
+	Network string `json:"network,omitempty"` // "tcp" (default) or "udp"; UDP streams carry datagram frames
+}
+
+// NetworkOrDefault returns network, or NetworkTCP when it is empty.
+func NetworkOrDefault(network string) string {
+	if network == "" {
+		return NetworkTCP
+	}
+	return network
 }
 
 type ConnectResponse struct {
     Success bool   `json:"success"`
     Error   string `json:"error,omitempty"`
+    Code    string `json:"code,omitempty"` // Failure class, one of the Code constants; empty for other errors
+}
+
+// ConnectResponse failure codes, so clients such as the SOCKS5 listener can
+// tell a policy denial from a failed dial.
+const (
+	CodeDenied             = "denied"              // Target not in the allowlist
+	CodeUnsupported        = "unsupported"         // Unknown network
+	CodeRefused            = "refused"             // Target refused the connection
+	CodeHostUnreachable    = "host_unreachable"    // Name did not resolve or no route to host
+	CodeNetworkUnreachable = "network_unreachable" // No route to the network
+	CodeTimeout            = "timeout"             // Dial timed out
+	CodeQuotaExceeded      = "quota_exceeded"      // The user's byte quota is used up
+	CodeCapacityExceeded   = "capacity_exceeded"   // Connection limit reached; retrying later may succeed
+	CodeShuttingDown       = "shutting_down"       // The agent is draining; reconnect to reach its replacement
+)
+
+// ListenRequest opens a reverse forward: the agent listens on Address and
+// hands every accepted connection back to the client on a new stream,
+// tagged with ID. The listener lives as long as the request stream.
+type ListenRequest struct {
+	ID      string `json:"id"`      // Chosen by the client, unique per session
+	Address string `json:"address"` // Remote bind address, e.g. "127.0.0.1:9000"
+}
+
+type ListenResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Address string `json:"address,omitempty"` // Bound address
+}
+
+// ForwardedConn is the payload of the MsgTypeForwarded message that starts
+// each agent-opened stream; the connection's data follows it.
+type ForwardedConn struct {
+	ID     string `json:"id"`     // ListenRequest.ID of the reverse forward
+	Remote string `json:"remote"` // Address of the peer that connected on the agent side
 }
@@ -0,0 +1,393 @@
+// Package sshconfig reads the subset of OpenSSH client config (ssh_config)
+// needed to turn a host alias into connection details: Host patterns,
+// HostName, Port, User, IdentityFile, ProxyJump and Include.
+package sshconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// maxIncludeDepth bounds nested Include directives, as OpenSSH does.
+const maxIncludeDepth = 16
+
+// Config is a parsed ssh_config file. The zero value is an empty config.
+type Config struct {
+	blocks []*block
+}
+
+// block holds the directives that apply to hosts matching its patterns.
+// The implicit block before the first Host line matches every host.
+type block struct {
+	patterns []string
+	options  []option
+}
+
+type option struct {
+	key  string // Lowercased keyword
+	args []string
+}
+
+// Host is the result of resolving an alias against a Config.
+type Host struct {
+	Alias         string
+	HostName      string   // Defaults to Alias
+	Port          string   // Empty if not configured
+	User          string   // Empty if not configured
+	IdentityFiles []string // Expanded paths, in config order
+	ProxyJump     []string // Jump specs ([user@]host[:port]), in order
+}
+
+// DefaultPath returns the user's ~/.ssh/config path.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// Load parses the config file at path. A missing file yields an empty
+// config rather than an error.
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	if path == "" {
+		return c, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := c.parse(f, path, nil, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Parse reads config text such as a pasted snippet. Relative Include paths
+// are resolved against ~/.ssh.
+func Parse(r io.Reader) (*Config, error) {
+	c := &Config{}
+	if err := c.parse(r, "<input>", nil, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Merge combines configs in precedence order: for single-valued options
+// the first config that sets a value wins.
+func Merge(configs ...*Config) *Config {
+	merged := &Config{}
+	for _, c := range configs {
+		if c != nil {
+			merged.blocks = append(merged.blocks, c.blocks...)
+		}
+	}
+	return merged
+}
+
+func (c *Config) parse(r io.Reader, name string, current *block, depth int) error {
+	if current == nil {
+		current = &block{patterns: []string{"*"}}
+		c.blocks = append(c.blocks, current)
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		key, args, err := splitLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, lineNo, err)
+		}
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("%s:%d: Host requires at least one pattern", name, lineNo)
+			}
+			current = &block{patterns: args}
+			c.blocks = append(c.blocks, current)
+		case "match":
+			// Match criteria are not supported; never apply the section.
+			current = &block{}
+			c.blocks = append(c.blocks, current)
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s:%d: Include nested too deeply", name, lineNo)
+			}
+			for _, pattern := range args {
+				if err := c.include(pattern, current, depth+1); err != nil {
+					return fmt.Errorf("%s:%d: %w", name, lineNo, err)
+				}
+			}
+		default:
+			current.options = append(current.options, option{key: key, args: args})
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses every file matching pattern. Directives before the first
+// Host line of an included file belong to the including section.
+func (c *Config) include(pattern string, current *block, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(DefaultPath()), pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("Include %s: %w", pattern, err)
+	}
+	for _, path := range matches {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Include: %w", err)
+		}
+		err = c.parse(f, path, current, depth)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitLine returns the lowercased keyword and arguments of a config line,
+// accepting both "Key value" and "Key=value" forms and double quotes.
+func splitLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	var args []string
+	var arg strings.Builder
+	inQuote, hasArg := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if hasArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				hasArg = false
+			}
+		case r == '#' && !inQuote && !hasArg:
+			return key, args, nil // Trailing comment
+		default:
+			arg.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if inQuote {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+	if hasArg {
+		args = append(args, arg.String())
+	}
+	return key, args, nil
+}
+
+// Resolve applies every section matching alias, in file order. As with
+// OpenSSH, the first value found for an option wins, except IdentityFile
+// which accumulates.
+func (c *Config) Resolve(alias string) Host {
+	h := Host{Alias: alias}
+	seen := map[string]bool{}
+	var identityFiles []string
+	for _, b := range c.blocks {
+		if !b.matches(alias) {
+			continue
+		}
+		for _, opt := range b.options {
+			if len(opt.args) == 0 {
+				continue
+			}
+			if opt.key == "identityfile" {
+				identityFiles = append(identityFiles, opt.args[0])
+				continue
+			}
+			if seen[opt.key] {
+				continue
+			}
+			seen[opt.key] = true
+			switch opt.key {
+			case "hostname":
+				h.HostName = opt.args[0]
+			case "port":
+				h.Port = opt.args[0]
+			case "user":
+				h.User = opt.args[0]
+			case "proxyjump":
+				if !strings.EqualFold(opt.args[0], "none") {
+					for _, spec := range strings.Split(strings.Join(opt.args, ","), ",") {
+						if spec = strings.TrimSpace(spec); spec != "" {
+							h.ProxyJump = append(h.ProxyJump, spec)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if h.HostName == "" {
+		h.HostName = alias
+	} else {
+		h.HostName = strings.ReplaceAll(h.HostName, "%h", alias)
+	}
+	for _, path := range identityFiles {
+		h.IdentityFiles = append(h.IdentityFiles, h.expandTokens(path))
+	}
+	return h
+}
+
+// Aliases lists the literal (non-wildcard, non-negated) Host patterns in
+// file order, which are the names a user would type.
+func (c *Config) Aliases() []string {
+	seen := map[string]bool{}
+	var aliases []string
+	for _, b := range c.blocks {
+		for _, p := range b.patterns {
+			if strings.ContainsAny(p, "*?!") || seen[p] {
+				continue
+			}
+			seen[p] = true
+			aliases = append(aliases, p)
+		}
+	}
+	return aliases
+}
+
+// expandTokens expands ~ and the %d, %h, %r, %u and %% tokens in a path.
+func (h Host) expandTokens(path string) string {
+	home, _ := os.UserHomeDir()
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	remote := h.User
+	if remote == "" {
+		remote = local
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 == len(path) {
+			b.WriteByte(path[i])
+			continue
+		}
+		i++
+		switch path[i] {
+		case 'd':
+			b.WriteString(home)
+		case 'h':
+			b.WriteString(h.HostName)
+		case 'r':
+			b.WriteString(remote)
+		case 'u':
+			b.WriteString(local)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(path[i])
+		}
+	}
+	return expandHome(b.String())
+}
+
+// matches reports whether alias matches the block's patterns: at least one
+// positive pattern must match and no negated pattern may.
+func (b *block) matches(alias string) bool {
+	alias = strings.ToLower(alias)
+	matched := false
+	for _, p := range b.patterns {
+		negated := strings.HasPrefix(p, "!")
+		if negated {
+			p = p[1:]
+		}
+		if !wildcardMatch(strings.ToLower(p), alias) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// wildcardMatch matches s against an ssh_config pattern where '*' matches
+// any run of characters and '?' exactly one.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// ParseJump splits a ProxyJump entry of the form [ssh://][user@]host[:port].
+// Port is empty when not given.
+func ParseJump(spec string) (user, host, port string) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		user, spec = spec[:at], spec[at+1:]
+	}
+	if h, p, err := net.SplitHostPort(spec); err == nil {
+		return user, h, p
+	}
+	return user, spec, ""
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
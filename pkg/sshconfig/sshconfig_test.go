@@ -0,0 +1,244 @@
+package sshconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+# Global defaults come first in this file
+User = default-user
+
+Host web web.example
+    HostName 10.0.0.5
+    Port 2222
+    IdentityFile /keys/web
+
+Host db
+    HostName %h.internal
+    User "db admin"
+    ProxyJump alice@bastion:2200,ssh://jump2
+
+Host *.example !secret.example
+    User example-user
+    IdentityFile /keys/%h-%r
+
+Host direct
+    ProxyJump none
+
+Match host web
+    User ignored
+
+Host *
+    Port 22
+    IdentityFile /keys/default
+`
+
+func mustParse(t *testing.T, text string) *Config {
+	t.Helper()
+	c, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return c
+}
+
+func TestResolve(t *testing.T) {
+	c := mustParse(t, testConfig)
+	tests := []struct {
+		alias string
+		want  Host
+	}{
+		{"web", Host{
+			Alias: "web", HostName: "10.0.0.5", Port: "2222", User: "default-user",
+			IdentityFiles: []string{"/keys/web", "/keys/default"},
+		}},
+		{"db", Host{
+			Alias: "db", HostName: "db.internal", Port: "22", User: "default-user",
+			IdentityFiles: []string{"/keys/default"},
+			ProxyJump:     []string{"alice@bastion:2200", "ssh://jump2"},
+		}},
+		{"app.example", Host{
+			Alias: "app.example", HostName: "app.example", Port: "22", User: "default-user",
+			IdentityFiles: []string{"/keys/app.example-default-user", "/keys/default"},
+		}},
+		{"secret.example", Host{
+			Alias: "secret.example", HostName: "secret.example", Port: "22", User: "default-user",
+			IdentityFiles: []string{"/keys/default"},
+		}},
+		{"direct", Host{
+			Alias: "direct", HostName: "direct", Port: "22", User: "default-user",
+			IdentityFiles: []string{"/keys/default"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := c.Resolve(tt.alias); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolve(%q) =\n  %+v\nwant\n  %+v", tt.alias, got, tt.want)
+		}
+	}
+}
+
+func TestResolveQuotedValue(t *testing.T) {
+	c := mustParse(t, "Host db\n  User \"db admin\"\n")
+	if got := c.Resolve("db").User; got != "db admin" {
+		t.Errorf("User = %q, want %q", got, "db admin")
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		key     string
+		args    []string
+		wantErr bool
+	}{
+		{"", "", nil, false},
+		{"  # comment", "", nil, false},
+		{"HostName example.com", "hostname", []string{"example.com"}, false},
+		{"Port=2222", "port", []string{"2222"}, false},
+		{"Port = 2222", "port", []string{"2222"}, false},
+		{"\tUser\talice", "user", []string{"alice"}, false},
+		{`IdentityFile "/path/with space/key"`, "identityfile", []string{"/path/with space/key"}, false},
+		{"Host a b  c # trailing", "host", []string{"a", "b", "c"}, false},
+		{"Compression", "compression", nil, false},
+		{`User "unterminated`, "", nil, true},
+	}
+	for _, tt := range tests {
+		key, args, err := splitLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitLine(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if key != tt.key || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitLine(%q) = %q, %q, want %q, %q", tt.line, key, args, tt.key, tt.args)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"Host\n",
+		"Host a\n  User \"b\n",
+	} {
+		if _, err := Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("Parse(%q) succeeded", text)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"web", "web", true},
+		{"web", "web2", false},
+		{"web?", "web2", true},
+		{"web?", "web", false},
+		{"*.example", "a.b.example", true},
+		{"*.example", "example", false},
+		{"10.0.*.5", "10.0.12.5", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseJump(t *testing.T) {
+	tests := []struct {
+		spec             string
+		user, host, port string
+	}{
+		{"bastion", "", "bastion", ""},
+		{"alice@bastion", "alice", "bastion", ""},
+		{"alice@bastion:2200", "alice", "bastion", "2200"},
+		{"ssh://alice@bastion:2200", "alice", "bastion", "2200"},
+		{"me@corp@bastion", "me@corp", "bastion", ""},
+		{"[2001:db8::1]:22", "", "2001:db8::1", "22"},
+		{" bastion ", "", "bastion", ""},
+	}
+	for _, tt := range tests {
+		user, host, port := ParseJump(tt.spec)
+		if user != tt.user || host != tt.host || port != tt.port {
+			t.Errorf("ParseJump(%q) = %q, %q, %q, want %q, %q, %q", tt.spec, user, host, port, tt.user, tt.host, tt.port)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	c := mustParse(t, testConfig)
+	want := []string{"web", "web.example", "db", "direct"}
+	if got := c.Aliases(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Aliases() = %q, want %q", got, want)
+	}
+}
+
+func TestMergePrecedence(t *testing.T) {
+	pasted := mustParse(t, "Host web\n  User pasted\n  IdentityFile /keys/pasted\n")
+	user := mustParse(t, "Host web\n  User file\n  HostName web.internal\n  IdentityFile /keys/file\n")
+
+	h := Merge(pasted, nil, user).Resolve("web")
+	if h.User != "pasted" {
+		t.Errorf("User = %q, want the pasted config to win", h.User)
+	}
+	if h.HostName != "web.internal" {
+		t.Errorf("HostName = %q, want it taken from the later config", h.HostName)
+	}
+	if want := []string{"/keys/pasted", "/keys/file"}; !reflect.DeepEqual(h.IdentityFiles, want) {
+		t.Errorf("IdentityFiles = %q, want %q", h.IdentityFiles, want)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("hosts.conf", "User included\n\nHost extra\n  HostName extra.internal\n")
+	main := write("config", "Host web\n  Include "+filepath.Join(dir, "*.conf")+"\n  Port 2222\n")
+
+	c, err := Load(main)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if h := c.Resolve("web"); h.User != "included" || h.Port != "2222" {
+		t.Errorf("web = %+v, want the included User in the including section", h)
+	}
+	if h := c.Resolve("extra"); h.HostName != "extra.internal" || h.User != "" {
+		t.Errorf("extra = %+v, want only the included Host section", h)
+	}
+}
+
+func TestIncludeRecursionLimit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("Include "+path+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("Load error = %v, want a nesting error", err)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	c, err := Load(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if h := c.Resolve("web"); h.HostName != "web" {
+		t.Errorf("Resolve on an empty config = %+v", h)
+	}
+}
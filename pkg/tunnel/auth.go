@@ -0,0 +1,174 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrPassphraseRequired is returned by LoadSigner when the key is encrypted
+// and no passphrase was supplied.
+var ErrPassphraseRequired = errors.New("private key is encrypted: passphrase required")
+
+// ErrChallengeFailed is wrapped by errors from keyboard-interactive handlers
+// that could not answer the server's prompts.
+var ErrChallengeFailed = errors.New("keyboard-interactive challenge not answered")
+
+// ExpandPath replaces a leading "~" with the current user's home directory.
+func ExpandPath(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// LoadSigner reads a private key file for public key authentication.
+// RSA, ECDSA and Ed25519 keys are accepted in OpenSSH and PEM (PKCS#1,
+// PKCS#8, SEC1) formats. passphrase is only used for encrypted keys.
+func LoadSigner(path, passphrase string) (ssh.Signer, error) {
+	data, err := os.ReadFile(ExpandPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("read key %s: %w", path, err)
+	}
+	return ParseSigner(data, passphrase)
+}
+
+// ParseSigner parses PEM or OpenSSH encoded private key bytes.
+func ParseSigner(data []byte, passphrase string) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(data)
+	if err == nil {
+		return signer, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key: %w", err)
+	}
+	return signer, nil
+}
+
+// AgentSocket returns the ssh-agent socket to use: the configured path if
+// set, otherwise $SSH_AUTH_SOCK. An empty result means no agent is available.
+func AgentSocket(configured string) string {
+	if configured != "" {
+		return ExpandPath(configured)
+	}
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+// DialAgent connects to the ssh-agent listening on socket. The returned
+// closer releases the connection once authentication is done.
+func DialAgent(socket string) (agent.ExtendedAgent, io.Closer, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect ssh-agent %s: %w", socket, err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// PublicKeyAuth offers signers first, then every identity held by keyring
+// (which may be nil). They share one method because the SSH client never
+// retries a method name it has already attempted.
+func PublicKeyAuth(signers []ssh.Signer, keyring agent.Agent) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		all := append([]ssh.Signer{}, signers...)
+		if keyring == nil {
+			return all, nil
+		}
+		agentSigners, err := keyring.Signers()
+		if err != nil && len(all) == 0 {
+			return nil, err
+		}
+		return append(all, agentSigners...), nil
+	})
+}
+
+// AutoAnswer returns a keyboard-interactive handler that answers password
+// prompts with password and one-time code prompts with a TOTP derived from
+// totpSecret. Prompts it cannot answer are passed to fallback; with a nil
+// fallback they fail the challenge.
+func AutoAnswer(password, totpSecret string, fallback ssh.KeyboardInteractiveChallenge) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		var pending []int
+		for i, q := range questions {
+			switch promptKind(q) {
+			case "otp":
+				if totpSecret != "" {
+					code, err := TOTP(totpSecret, time.Now())
+					if err != nil {
+						return nil, err
+					}
+					answers[i] = code
+					continue
+				}
+			case "password":
+				if password != "" {
+					answers[i] = password
+					continue
+				}
+			}
+			pending = append(pending, i)
+		}
+		if len(pending) == 0 {
+			return answers, nil
+		}
+		if fallback == nil {
+			return nil, fmt.Errorf("%w: no answer for %q", ErrChallengeFailed, questions[pending[0]])
+		}
+
+		subQuestions := make([]string, len(pending))
+		subEchos := make([]bool, len(pending))
+		for j, i := range pending {
+			subQuestions[j] = questions[i]
+			subEchos[j] = echos[i]
+		}
+		subAnswers, err := fallback(name, instruction, subQuestions, subEchos)
+		if err != nil {
+			return nil, err
+		}
+		if len(subAnswers) != len(pending) {
+			return nil, fmt.Errorf("expected %d answers, got %d", len(pending), len(subAnswers))
+		}
+		for j, i := range pending {
+			answers[i] = subAnswers[j]
+		}
+		return answers, nil
+	}
+}
+
+// promptKind classifies a keyboard-interactive prompt as "otp", "password"
+// or "" (unknown). One-time codes are checked first since prompts such as
+// "One-time password:" mention both.
+func promptKind(prompt string) string {
+	p := strings.ToLower(prompt)
+	for _, word := range []string{"one-time", "otp", "totp", "verification", "code", "token", "2fa", "authenticator"} {
+		if strings.Contains(p, word) {
+			return "otp"
+		}
+	}
+	if strings.Contains(p, "password") || strings.Contains(p, "passphrase") {
+		return "password"
+	}
+	return ""
+}
@@ -0,0 +1,492 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testServer is an in-process SSH server that records the auth attempts
+// clients make.
+type testServer struct {
+	Addr    string
+	HostKey ssh.PublicKey
+
+	mu       sync.Mutex
+	attempts []string
+}
+
+// startTestServer serves config with a fresh host key on a loopback port
+// until the test ends.
+func startTestServer(t *testing.T, config *ssh.ServerConfig) *testServer {
+	t.Helper()
+	hostKey := newTestSigner(t)
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := &testServer{Addr: ln.Addr().String(), HostKey: hostKey.PublicKey()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				go func() {
+					for ch := range chans {
+						go serveTestChannel(ch)
+					}
+				}()
+				sconn.Wait()
+			}()
+		}
+	}()
+	return srv
+}
+
+// serveTestChannel connects direct-tcpip channels to their destination, so
+// the server can act as a jump host, and rejects anything else.
+func serveTestChannel(newCh ssh.NewChannel) {
+	if newCh.ChannelType() != "direct-tcpip" {
+		newCh.Reject(ssh.Prohibited, "only direct-tcpip in tests")
+		return
+	}
+	var dest struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &dest); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(dest.Host, strconv.Itoa(int(dest.Port))))
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+}
+
+// record notes an auth attempt, skipping repeats of the previous one (the
+// public key callback runs for the query and again for the signature).
+func (s *testServer) record(attempt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.attempts); n > 0 && s.attempts[n-1] == attempt {
+		return
+	}
+	s.attempts = append(s.attempts, attempt)
+}
+
+func (s *testServer) Attempts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.attempts...)
+}
+
+// dial connects to the server with auths, trusting only its host key.
+func (s *testServer) dial(auths ...ssh.AuthMethod) (*ssh.Client, error) {
+	return Dial(Config{
+		Host:            s.Addr,
+		User:            "alice",
+		Auth:            auths,
+		HostKeyCallback: ssh.FixedHostKey(s.HostKey),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// passwordServer accepts the password "secret" and, if authorized is
+// non-nil, that public key.
+func passwordServer(t *testing.T, authorized ssh.PublicKey) *testServer {
+	t.Helper()
+	var srv *testServer
+	srv = startTestServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			srv.record("publickey " + ssh.FingerprintSHA256(key))
+			if authorized != nil && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("key not authorized")
+		},
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			srv.record("password")
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	})
+	return srv
+}
+
+func TestPublicKeyAuthOffersAgentBeforePassword(t *testing.T) {
+	fileKey := newTestSigner(t)
+	_, raw, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: raw}); err != nil {
+		t.Fatal(err)
+	}
+	agentSigners, err := keyring.Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := passwordServer(t, nil)
+	client, err := srv.dial(PublicKeyAuth([]ssh.Signer{fileKey}, keyring), ssh.Password("secret"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client.Close()
+
+	want := []string{
+		"publickey " + ssh.FingerprintSHA256(fileKey.PublicKey()),
+		"publickey " + ssh.FingerprintSHA256(agentSigners[0].PublicKey()),
+		"password",
+	}
+	if got := srv.Attempts(); !slices.Equal(got, want) {
+		t.Errorf("attempts = %q, want %q", got, want)
+	}
+}
+
+func TestPublicKeyAuthAgentIdentityAccepted(t *testing.T) {
+	_, raw, _ := ed25519.GenerateKey(rand.Reader)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: raw}); err != nil {
+		t.Fatal(err)
+	}
+	signers, err := keyring.Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := passwordServer(t, signers[0].PublicKey())
+	client, err := srv.dial(PublicKeyAuth(nil, keyring), ssh.Password("secret"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client.Close()
+
+	for _, attempt := range srv.Attempts() {
+		if attempt == "password" {
+			t.Errorf("password tried although the agent key was accepted: %q", srv.Attempts())
+		}
+	}
+}
+
+func TestPublicKeyAuthUnreachableAgentFallsBackToPassword(t *testing.T) {
+	// An agent whose connection is gone fails every request
+	conn, peer := net.Pipe()
+	peer.Close()
+	keyring := agent.NewClient(conn)
+
+	srv := passwordServer(t, nil)
+	client, err := srv.dial(PublicKeyAuth(nil, keyring), ssh.Password("secret"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client.Close()
+
+	if got := srv.Attempts(); !slices.Equal(got, []string{"password"}) {
+		t.Errorf("attempts = %q, want only password", got)
+	}
+}
+
+func TestDialAgentMissingSocket(t *testing.T) {
+	if _, _, err := DialAgent(t.TempDir() + "/missing.sock"); err == nil {
+		t.Fatal("DialAgent succeeded for a missing socket")
+	}
+}
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// challengeServer asks every client for a password and a one-time code in
+// a single keyboard-interactive round, plus any extra prompts.
+func challengeServer(t *testing.T, extra ...string) *testServer {
+	t.Helper()
+	var srv *testServer
+	srv = startTestServer(t, &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			srv.record("keyboard-interactive")
+			questions := append([]string{"Password: ", "Verification code: "}, extra...)
+			echos := make([]bool, len(questions))
+			echos[1] = true
+			answers, err := client("login", "Two factors required", questions, echos)
+			if err != nil {
+				return nil, err
+			}
+			if answers[0] != "secret" || !validTOTP(answers[1]) {
+				return nil, errors.New("wrong answers")
+			}
+			for i := range extra {
+				if answers[2+i] != "extra" {
+					return nil, errors.New("wrong answers")
+				}
+			}
+			return nil, nil
+		},
+	})
+	return srv
+}
+
+// validTOTP accepts the current code or the previous one, in case the test
+// straddles a period boundary.
+func validTOTP(code string) bool {
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(-totpPeriod)} {
+		if want, err := TOTP(testTOTPSecret, at); err == nil && code == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAutoAnswerPasswordAndOTP(t *testing.T) {
+	srv := challengeServer(t)
+	client, err := srv.dial(ssh.KeyboardInteractive(AutoAnswer("secret", testTOTPSecret, nil)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client.Close()
+}
+
+func TestAutoAnswerFallbackGetsOnlyUnknownPrompts(t *testing.T) {
+	srv := challengeServer(t, "Favourite colour: ")
+	var asked []string
+	fallback := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		asked = append(asked, questions...)
+		return []string{"extra"}, nil
+	}
+	client, err := srv.dial(ssh.KeyboardInteractive(AutoAnswer("secret", testTOTPSecret, fallback)))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	client.Close()
+
+	if !slices.Equal(asked, []string{"Favourite colour: "}) {
+		t.Errorf("fallback asked %q, want only the unknown prompt", asked)
+	}
+}
+
+func TestAutoAnswerWithoutFallbackFails(t *testing.T) {
+	srv := challengeServer(t)
+	_, err := srv.dial(ssh.KeyboardInteractive(AutoAnswer("secret", "", nil)))
+	if !errors.Is(err, ErrChallengeFailed) {
+		t.Fatalf("dial error = %v, want ErrChallengeFailed", err)
+	}
+	if !IsAuthError(err) {
+		t.Errorf("IsAuthError(%v) = false", err)
+	}
+}
+
+func TestPromptKind(t *testing.T) {
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{"Password: ", "password"},
+		{"alice@host's password:", "password"},
+		{"Enter passphrase for key:", "password"},
+		{"Verification code: ", "otp"},
+		{"One-time password (OATH) for `alice':", "otp"},
+		{"OTP: ", "otp"},
+		{"Enter your 2FA token:", "otp"},
+		{"Authenticator code:", "otp"},
+		{"Favourite colour: ", ""},
+	}
+	for _, tt := range tests {
+		if got := promptKind(tt.prompt); got != tt.want {
+			t.Errorf("promptKind(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+// testKeys returns an RSA, an ECDSA and an Ed25519 private key.
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey, "ed25519": edKey}
+}
+
+// pemKey encodes key the way openssl would: PKCS#1 for RSA, SEC1 for
+// ECDSA and PKCS#8 for Ed25519.
+func pemKey(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func TestParseSignerFormats(t *testing.T) {
+	for name, key := range testKeys(t) {
+		want, err := ssh.NewPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for format, data := range map[string][]byte{
+			"openssh": pem.EncodeToMemory(block),
+			"pem":     pemKey(t, key),
+		} {
+			signer, err := ParseSigner(data, "")
+			if err != nil {
+				t.Errorf("%s %s: %v", name, format, err)
+				continue
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+				t.Errorf("%s %s: parsed a different key", name, format)
+			}
+		}
+	}
+}
+
+func TestParseSignerEncrypted(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("right"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(block)
+
+	if _, err := ParseSigner(data, ""); !errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("without passphrase: err = %v, want ErrPassphraseRequired", err)
+	}
+	if _, err := ParseSigner(data, "wrong"); err == nil || errors.Is(err, ErrPassphraseRequired) {
+		t.Errorf("wrong passphrase: err = %v, want a decrypt error", err)
+	}
+	if _, err := ParseSigner(data, "right"); err != nil {
+		t.Errorf("right passphrase: %v", err)
+	}
+}
+
+func TestParseSignerInvalid(t *testing.T) {
+	if _, err := ParseSigner([]byte("not a key"), ""); err == nil {
+		t.Error("ParseSigner accepted garbage")
+	}
+}
+
+func TestLoadSigner(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	if err := os.WriteFile(filepath.Join(home, "id_test"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadSigner("~/id_test", ""); err != nil {
+		t.Errorf("LoadSigner(~/id_test): %v", err)
+	}
+	if _, err := LoadSigner("~/missing", ""); err == nil || !strings.Contains(err.Error(), "~/missing") {
+		t.Errorf("LoadSigner(~/missing) error = %v, want one naming the path", err)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	tests := []struct {
+		path, want string
+	}{
+		{"~", home},
+		{"~/.ssh/id_ed25519", filepath.Join(home, ".ssh", "id_ed25519")},
+		{"~other/key", "~other/key"},
+		{"/etc/ssh/key", "/etc/ssh/key"},
+		{"relative/~/key", "relative/~/key"},
+	}
+	for _, tt := range tests {
+		if got := ExpandPath(tt.path); got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+
+	"github.com/hashicorp/yamux"
+)
+
+// ErrTokenRejected is returned by DialDirect when the agent refuses the token.
+var ErrTokenRejected = errors.New("agent rejected the token")
+
+// DirectConfig describes how to reach a server-agent running in listen
+// mode, without SSH.
+type DirectConfig struct {
+	Address string      // tcp://host:port, unix:///path or host:port
+	Token   string      // Pre-shared token, if the agent requires one
+	TLS     *tls.Config // Nil for a plain connection
+	Timeout time.Duration
+
+	// WrapConn optionally wraps the raw connection (e.g. for byte counting).
+	WrapConn func(net.Conn) net.Conn
+}
+
+// DialDirect connects to an agent in listen mode, authenticates and starts
+// a yamux client session over the connection.
+func DialDirect(cfg DirectConfig) (*yamux.Session, error) {
+	network, address := protocol.SplitAddress(cfg.Address)
+	conn, err := net.DialTimeout(network, address, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WrapConn != nil {
+		conn = cfg.WrapConn(conn)
+	}
+	if cfg.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	}
+
+	if cfg.TLS != nil {
+		tlsConfig := cfg.TLS.Clone()
+		if tlsConfig.ServerName == "" && network == "tcp" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	if err := authenticate(conn, cfg.Token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	yamuxCfg := yamux.DefaultConfig()
+	yamuxCfg.EnableKeepAlive = true
+	yamuxCfg.LogOutput = io.Discard
+	session, err := yamux.Client(conn, yamuxCfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// authenticate sends the auth message that opens every direct connection
+// and waits for the agent to accept it.
+func authenticate(conn net.Conn, token string) error {
+	msg := protocol.Message{Type: protocol.MsgTypeAuth, Payload: protocol.AuthRequest{Token: token}}
+	if err := json.NewEncoder(conn).Encode(msg); err != nil {
+		return err
+	}
+
+	// The agent starts yamux right after its reply, so read no further
+	var resp protocol.AuthResponse
+	if err := readJSONLine(conn, &resp); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("%w: %s", ErrTokenRejected, resp.Error)
+	}
+	return nil
+}
+
+// ClientTLS builds the TLS config for dialing an agent. caFile verifies the
+// agent certificate (system roots when empty); certFile and keyFile, if
+// set, present a client certificate for mutual TLS.
+func ClientTLS(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(ExpandPath(caFile))
+		if err != nil {
+			return nil, fmt.Errorf("read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(ExpandPath(certFile), ExpandPath(keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,192 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// UnknownHostKeyError is returned when the server's host key is not listed
+// in any known_hosts file. The user may accept Fingerprint and retry.
+type UnknownHostKeyError struct {
+	Host        string
+	KeyType     string
+	Fingerprint string
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("unknown host key for %s (%s %s)", e.Host, e.KeyType, e.Fingerprint)
+}
+
+// HostKeyChangedError is returned when the server presents a key that does
+// not match the one recorded for it. This may indicate a MITM attack and is
+// never accepted automatically.
+type HostKeyChangedError struct {
+	Host        string
+	KeyType     string
+	Fingerprint string
+	KnownFile   string
+	KnownLine   int
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key changed for %s: server presented %s %s, expected the key at %s:%d",
+		e.Host, e.KeyType, e.Fingerprint, e.KnownFile, e.KnownLine)
+}
+
+// HostKeyVerifier checks server host keys against known_hosts files and
+// implements trust-on-first-use: an unknown key whose fingerprint matches
+// TrustFingerprint is accepted and appended to AppFile.
+type HostKeyVerifier struct {
+	// Files are read-only known_hosts files such as ~/.ssh/known_hosts.
+	Files []string
+	// AppFile is the app-managed known_hosts file that accepted keys are written to.
+	AppFile string
+	// TrustFingerprint is a SHA256 fingerprint the user accepted for an unknown key.
+	TrustFingerprint string
+
+	mu          sync.Mutex
+	fingerprint string
+}
+
+// DefaultKnownHostsFile returns ~/.ssh/known_hosts.
+func DefaultKnownHostsFile() string {
+	return ExpandPath("~/.ssh/known_hosts")
+}
+
+// AppKnownHostsFile returns the known_hosts file managed by ssh-forwarder,
+// stored next to the client settings.
+func AppKnownHostsFile() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+	return filepath.Join(configDir, "ssh-forwarder", "known_hosts")
+}
+
+// Fingerprint returns the fingerprint of the last key presented to Callback.
+func (v *HostKeyVerifier) Fingerprint() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.fingerprint
+}
+
+// Callback returns an ssh.HostKeyCallback backed by the verifier.
+func (v *HostKeyVerifier) Callback() (ssh.HostKeyCallback, error) {
+	db, err := v.database()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		v.mu.Lock()
+		v.fingerprint = fingerprint
+		v.mu.Unlock()
+
+		if db != nil {
+			err := db(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				return err // e.g. revoked key
+			}
+			if len(keyErr.Want) > 0 {
+				known := keyErr.Want[0]
+				return &HostKeyChangedError{
+					Host:        hostname,
+					KeyType:     key.Type(),
+					Fingerprint: fingerprint,
+					KnownFile:   known.Filename,
+					KnownLine:   known.Line,
+				}
+			}
+		}
+
+		if v.TrustFingerprint != "" && v.TrustFingerprint == fingerprint {
+			return v.add(hostname, remote, key)
+		}
+		return &UnknownHostKeyError{Host: hostname, KeyType: key.Type(), Fingerprint: fingerprint}
+	}, nil
+}
+
+// HostKeyAlgorithms returns the algorithms of keys already known for host,
+// so the server is asked for a key type that can actually be verified.
+// It returns nil if the host is unknown.
+func (v *HostKeyVerifier) HostKeyAlgorithms(host string) []string {
+	db, err := v.database()
+	if err != nil || db == nil {
+		return nil
+	}
+
+	// Probe with a throwaway key; the KeyError lists the known keys.
+	pub, _, _ := ed25519.GenerateKey(nil)
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(db(host, &net.TCPAddr{IP: net.IPv4zero}, probe), &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algos = append(algos, known.Key.Type())
+		}
+	}
+	return algos
+}
+
+// database loads every existing known_hosts file. It returns a nil
+// callback when none exist yet.
+func (v *HostKeyVerifier) database() (ssh.HostKeyCallback, error) {
+	var files []string
+	for _, f := range append(append([]string{}, v.Files...), v.AppFile) {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return knownhosts.New(files...)
+}
+
+func (v *HostKeyVerifier) add(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if v.AppFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(v.AppFile), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(v.AppFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("record host key: %w", err)
+	}
+	defer f.Close()
+
+	// Hops dialed through a jump host have no meaningful remote address.
+	addresses := []string{knownhosts.Normalize(hostname)}
+	if tcp, ok := remote.(*net.TCPAddr); ok && !tcp.IP.IsUnspecified() && remote.String() != hostname {
+		addresses = append(addresses, knownhosts.Normalize(remote.String()))
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line(addresses, key))
+	return err
+}
@@ -0,0 +1,177 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var testRemote = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+// writeKnownHosts writes a known_hosts file listing keys for host.
+func writeKnownHosts(t *testing.T, host string, keys ...ssh.PublicKey) string {
+	t.Helper()
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, knownhosts.Line([]string{knownhosts.Normalize(host)}, key))
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func verify(t *testing.T, v *HostKeyVerifier, host string, key ssh.PublicKey) error {
+	t.Helper()
+	callback, err := v.Callback()
+	if err != nil {
+		t.Fatalf("Callback: %v", err)
+	}
+	return callback(host, testRemote, key)
+}
+
+func TestHostKeyVerifierUnknownKey(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	v := &HostKeyVerifier{AppFile: filepath.Join(t.TempDir(), "known_hosts")}
+
+	err := verify(t, v, "example.com:22", key)
+	var unknown *UnknownHostKeyError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("err = %v, want UnknownHostKeyError", err)
+	}
+	if want := ssh.FingerprintSHA256(key); unknown.Fingerprint != want || v.Fingerprint() != want {
+		t.Errorf("fingerprint = %q (verifier %q), want %q", unknown.Fingerprint, v.Fingerprint(), want)
+	}
+	if _, err := os.Stat(v.AppFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("AppFile written for an untrusted key: %v", err)
+	}
+
+	// A fingerprint accepted for another key does not help
+	v.TrustFingerprint = ssh.FingerprintSHA256(newTestSigner(t).PublicKey())
+	if err := verify(t, v, "example.com:22", key); !errors.As(err, &unknown) {
+		t.Errorf("err = %v with another fingerprint trusted, want UnknownHostKeyError", err)
+	}
+}
+
+func TestHostKeyVerifierTrustRecordsKey(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	appFile := filepath.Join(t.TempDir(), "ssh-forwarder", "known_hosts")
+	v := &HostKeyVerifier{AppFile: appFile, TrustFingerprint: ssh.FingerprintSHA256(key)}
+	if err := verify(t, v, "example.com:22", key); err != nil {
+		t.Fatalf("trusted key rejected: %v", err)
+	}
+
+	data, err := os.ReadFile(appFile)
+	if err != nil {
+		t.Fatalf("AppFile not written: %v", err)
+	}
+	if want := knownhosts.Line([]string{"example.com", "192.0.2.1"}, key); strings.TrimSpace(string(data)) != want {
+		t.Errorf("AppFile = %q, want %q", data, want)
+	}
+
+	// The next connection accepts the key without being told to
+	if err := verify(t, &HostKeyVerifier{AppFile: appFile}, "example.com:22", key); err != nil {
+		t.Errorf("recorded key rejected: %v", err)
+	}
+}
+
+func TestHostKeyVerifierChangedKey(t *testing.T) {
+	known := newTestSigner(t).PublicKey()
+	presented := newTestSigner(t).PublicKey()
+	file := writeKnownHosts(t, "example.com:22", known)
+	appFile := filepath.Join(t.TempDir(), "known_hosts")
+
+	// Accepting the new fingerprint must not override a known key
+	v := &HostKeyVerifier{Files: []string{file}, AppFile: appFile, TrustFingerprint: ssh.FingerprintSHA256(presented)}
+	err := verify(t, v, "example.com:22", presented)
+	var changed *HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("err = %v, want HostKeyChangedError", err)
+	}
+	if changed.KnownFile != file || changed.KnownLine != 1 || changed.Fingerprint != ssh.FingerprintSHA256(presented) {
+		t.Errorf("error = %+v, want the new fingerprint and %s:1", changed, file)
+	}
+	if _, err := os.Stat(appFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("AppFile written for a changed key: %v", err)
+	}
+
+	if err := verify(t, v, "example.com:22", known); err != nil {
+		t.Errorf("known key rejected: %v", err)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub := newTestSigner(t).PublicKey()
+	v := &HostKeyVerifier{Files: []string{writeKnownHosts(t, "example.com:22", rsaPub, edPub)}}
+
+	// The known keys come in no particular order, but RSA always brings
+	// its SHA-2 variants first
+	got := v.HostKeyAlgorithms("example.com:22")
+	want := []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	if i := slices.Index(got, ssh.KeyAlgoRSASHA512); i < 0 || i+3 > len(got) || !slices.Equal(got[i:i+3], want[1:]) {
+		t.Errorf("HostKeyAlgorithms = %q, want %q in that order", got, want[1:])
+	}
+	if sorted, wantSorted := slices.Sorted(slices.Values(got)), slices.Sorted(slices.Values(want)); !slices.Equal(sorted, wantSorted) {
+		t.Errorf("HostKeyAlgorithms = %q, want %q in any order", got, want)
+	}
+	if got := v.HostKeyAlgorithms("other.example:22"); got != nil {
+		t.Errorf("HostKeyAlgorithms for an unknown host = %q, want nil", got)
+	}
+	if got := (&HostKeyVerifier{}).HostKeyAlgorithms("example.com:22"); got != nil {
+		t.Errorf("HostKeyAlgorithms without known_hosts = %q, want nil", got)
+	}
+}
+
+func TestHostKeyVerifierDial(t *testing.T) {
+	srv := passwordServer(t, nil)
+	v := &HostKeyVerifier{AppFile: filepath.Join(t.TempDir(), "known_hosts")}
+	dial := func() error {
+		callback, err := v.Callback()
+		if err != nil {
+			return err
+		}
+		client, err := Dial(Config{
+			Host:            srv.Addr,
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+			HostKeyCallback: callback,
+			Timeout:         5 * time.Second,
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	var unknown *UnknownHostKeyError
+	if err := dial(); !errors.As(err, &unknown) {
+		t.Fatalf("first dial error = %v, want UnknownHostKeyError", err)
+	}
+	v.TrustFingerprint = unknown.Fingerprint
+	if err := dial(); err != nil {
+		t.Fatalf("dial after accepting the key: %v", err)
+	}
+	v.TrustFingerprint = ""
+	if err := dial(); err != nil {
+		t.Errorf("dial with the recorded key: %v", err)
+	}
+}
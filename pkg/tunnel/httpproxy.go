@@ -0,0 +1,254 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// ProxyDomain is the suffix of service hostnames derived from port names.
+const ProxyDomain = ".tunnel"
+
+// ServiceHost returns the hostname the HTTP proxy serves p under: its
+// Hostname, or its name in lower case with runs of other characters turned
+// into "-" followed by ProxyDomain ("GitLab Web" -> "gitlab-web.tunnel").
+// It is empty for UDP ports and names without letters or digits.
+func ServiceHost(p protocol.PortConfig) string {
+	if protocol.NetworkOrDefault(p.Network) != protocol.NetworkTCP {
+		return ""
+	}
+	if p.Hostname != "" {
+		return strings.ToLower(p.Hostname)
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(p.Name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return b.String() + ProxyDomain
+}
+
+// HTTPProxy is an HTTP proxy that routes requests by hostname to the
+// agent's allowed ports. Plain HTTP requests are forwarded with their Host
+// header intact, so redirects stay on the service hostname; CONNECT
+// requests (HTTPS, WebSockets) are tunnelled as is. Requests addressed to
+// the proxy itself get the service list, or the PAC file at /proxy.pac.
+type HTTPProxy struct {
+	services func() []protocol.PortConfig
+	open     func(target string) (net.Conn, error)
+	proxy    *httputil.ReverseProxy
+}
+
+// NewHTTPProxy creates a proxy for the ports services returns, consulted
+// on every request so a reconnect can change them. open connects a
+// stream to a target, typically OpenTarget.
+func NewHTTPProxy(services func() []protocol.PortConfig, open func(target string) (net.Conn, error)) *HTTPProxy {
+	p := &HTTPProxy{services: services, open: open}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			DialContext:     p.dial,
+			IdleConnTimeout: 90 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.servePage(w, r, errorStatus(err), err.Error())
+		},
+	}
+	return p
+}
+
+// ServeHTTP implements http.Handler.
+func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		// Addressed to the proxy itself
+		if r.URL.Path == "/proxy.pac" {
+			w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+			fmt.Fprint(w, p.PAC(r.Host))
+			return
+		}
+		p.servePage(w, r, http.StatusOK, "")
+		return
+	}
+	if r.URL.Scheme != "http" {
+		p.servePage(w, r, http.StatusBadRequest, fmt.Sprintf("Unsupported scheme %q", r.URL.Scheme))
+		return
+	}
+	if _, ok := p.lookup(r.URL.Hostname()); !ok {
+		p.servePage(w, r, http.StatusNotFound, fmt.Sprintf("Unknown service host %q", r.URL.Hostname()))
+		return
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+// connect tunnels a CONNECT request to the service named by its host; the
+// port is ignored.
+func (p *HTTPProxy) connect(w http.ResponseWriter, r *http.Request) {
+	target, ok := p.lookup(r.URL.Hostname())
+	if !ok {
+		p.servePage(w, r, http.StatusNotFound, fmt.Sprintf("Unknown service host %q", r.URL.Hostname()))
+		return
+	}
+	stream, err := p.open(target)
+	if err != nil {
+		p.servePage(w, r, errorStatus(err), err.Error())
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		stream.Close()
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		stream.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		stream.Close()
+		return
+	}
+	// Pass on anything the client sent after the request line
+	if n := buf.Reader.Buffered(); n > 0 {
+		data, _ := buf.Reader.Peek(n)
+		stream.Write(data)
+	}
+	Pipe(conn, stream)
+}
+
+// dial connects the reverse proxy's transport to the service named by
+// addr's host.
+func (p *HTTPProxy) dial(_ context.Context, _, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	target, ok := p.lookup(host)
+	if !ok {
+		return nil, fmt.Errorf("unknown service host %q", host)
+	}
+	return p.open(target)
+}
+
+// lookup returns the target of the service with the given hostname.
+func (p *HTTPProxy) lookup(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, port := range p.services() {
+		if h := ServiceHost(port); h != "" && h == host {
+			return port.Target, true
+		}
+	}
+	return "", false
+}
+
+// PAC returns a proxy auto-config script that sends the service hostnames,
+// and any other host under ProxyDomain, to the proxy at addr and
+// everything else direct.
+func (p *HTTPProxy) PAC(addr string) string {
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("\thost = host.toLowerCase();\n")
+	fmt.Fprintf(&b, "\tif (dnsDomainIs(host, %q)", ProxyDomain)
+	for _, s := range p.serviceList() {
+		if !strings.HasSuffix(s.Host, ProxyDomain) {
+			fmt.Fprintf(&b, " ||\n\t\thost == %q", s.Host)
+		}
+	}
+	fmt.Fprintf(&b, ") {\n\t\treturn %q;\n\t}\n", "PROXY "+addr)
+	b.WriteString("\treturn \"DIRECT\";\n}\n")
+	return b.String()
+}
+
+// proxyService is a row of the service list page.
+type proxyService struct {
+	Host        string
+	Name        string
+	Description string
+}
+
+// serviceList returns the services that have a hostname, sorted by it.
+func (p *HTTPProxy) serviceList() []proxyService {
+	var list []proxyService
+	for _, port := range p.services() {
+		if host := ServiceHost(port); host != "" {
+			list = append(list, proxyService{Host: host, Name: port.Name, Description: port.Description})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	return list
+}
+
+var servicePage = template.Must(template.New("services").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>SSH Forwarder</title></head>
+<body style="font-family: sans-serif; margin: 2em">
+{{if .Error}}<p style="color: #b91c1c"><b>{{.Error}}</b></p>{{end}}
+<h3>Available services</h3>
+{{if .Services}}<table cellpadding="6">
+{{range .Services}}<tr><td><a href="http://{{.Host}}/">{{.Host}}</a></td><td>{{.Name}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>{{else}}<p>The server allows no TCP services.</p>{{end}}
+{{if .Direct}}<p>Proxy auto-config: <a href="/proxy.pac">/proxy.pac</a></p>{{end}}
+</body>
+</html>
+`))
+
+// servePage writes the service list with an optional error message.
+func (p *HTTPProxy) servePage(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5") // Target at capacity or agent restarting
+	}
+	w.WriteHeader(status)
+	servicePage.Execute(w, struct {
+		Error    string
+		Services []proxyService
+		Direct   bool
+	}{msg, p.serviceList(), r.Method != http.MethodConnect && !r.URL.IsAbs()})
+}
+
+// errorStatus maps a failure to open a service to an HTTP status.
+func errorStatus(err error) int {
+	var connectErr *ConnectError
+	if errors.As(err, &connectErr) {
+		switch connectErr.Code {
+		case protocol.CodeDenied:
+			return http.StatusForbidden
+		case protocol.CodeQuotaExceeded:
+			return http.StatusTooManyRequests
+		case protocol.CodeCapacityExceeded, protocol.CodeShuttingDown:
+			return http.StatusServiceUnavailable
+		}
+	}
+	return http.StatusBadGateway
+}
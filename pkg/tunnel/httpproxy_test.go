@@ -0,0 +1,188 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+func TestServiceHost(t *testing.T) {
+	tests := []struct {
+		port protocol.PortConfig
+		want string
+	}{
+		{protocol.PortConfig{Name: "GitLab Web"}, "gitlab-web.tunnel"},
+		{protocol.PortConfig{Name: "  db (primary)!"}, "db-primary.tunnel"},
+		{protocol.PortConfig{Name: "app_v2"}, "app-v2.tunnel"},
+		{protocol.PortConfig{Name: "Web", Hostname: "Wiki.Example.com"}, "wiki.example.com"},
+		{protocol.PortConfig{Name: "dns", Network: protocol.NetworkUDP}, ""},
+		{protocol.PortConfig{Name: "--"}, ""},
+	}
+	for _, tt := range tests {
+		if got := ServiceHost(tt.port); got != tt.want {
+			t.Errorf("ServiceHost(%+v) = %q, want %q", tt.port, got, tt.want)
+		}
+	}
+}
+
+// startHTTPProxy serves an HTTPProxy for a backend that echoes the request
+// host and path. The "Denied" service is refused by the agent.
+func startHTTPProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	backendAddr := backend.Listener.Addr().String()
+
+	services := []protocol.PortConfig{
+		{Name: "GitLab Web", Target: backendAddr},
+		{Name: "Wiki", Hostname: "wiki.example.com", Target: backendAddr},
+		{Name: "Denied", Target: "127.0.0.1:1"},
+		{Name: "dns", Target: "127.0.0.1:53", Network: protocol.NetworkUDP},
+	}
+	open := func(target string) (net.Conn, error) {
+		if target != backendAddr {
+			return nil, &ConnectError{Target: target, Code: protocol.CodeDenied, Message: "denied"}
+		}
+		return net.Dial("tcp", target)
+	}
+	proxy := httptest.NewServer(NewHTTPProxy(func() []protocol.PortConfig { return services }, open))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// proxyGet requests rawURL through the proxy.
+func proxyGet(t *testing.T, proxy *httptest.Server, rawURL string) (int, string) {
+	t.Helper()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestHTTPProxyGet(t *testing.T) {
+	proxy := startHTTPProxy(t)
+	tests := []struct {
+		url    string
+		status int
+		body   string // Prefix of the expected body, checked for 200
+	}{
+		{"http://gitlab-web.tunnel/users/sign_in", http.StatusOK, "gitlab-web.tunnel /users/sign_in"},
+		{"http://GitLab-Web.tunnel./", http.StatusOK, "GitLab-Web.tunnel. /"},
+		{"http://wiki.example.com:8080/page", http.StatusOK, "wiki.example.com:8080 /page"},
+		{"http://unknown.tunnel/", http.StatusNotFound, ""},
+		{"http://dns.tunnel/", http.StatusNotFound, ""},
+		{"http://denied.tunnel/", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		status, body := proxyGet(t, proxy, tt.url)
+		if status != tt.status || tt.status == http.StatusOK && body != tt.body {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.url, status, body, tt.status, tt.body)
+		}
+	}
+}
+
+// proxyConnect sends a CONNECT for host through the proxy and returns the
+// connection and the proxy's response.
+func proxyConnect(t *testing.T, proxy *httptest.Server, host string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", host, host)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("CONNECT %s: %v", host, err)
+	}
+	return conn, br, resp
+}
+
+func TestHTTPProxyConnect(t *testing.T) {
+	proxy := startHTTPProxy(t)
+	conn, br, resp := proxyConnect(t, proxy, "gitlab-web.tunnel:443")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT status = %d, want 200", resp.StatusCode)
+	}
+
+	// The tunnel carries the bytes as is
+	fmt.Fprint(conn, "GET /over/tunnel HTTP/1.1\r\nHost: tunnelled\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read tunnelled response: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "tunnelled /over/tunnel" {
+		t.Errorf("tunnelled body = %q", body)
+	}
+
+	for host, want := range map[string]int{
+		"unknown.tunnel:443": http.StatusNotFound,
+		"denied.tunnel:443":  http.StatusForbidden,
+	} {
+		if _, _, resp := proxyConnect(t, proxy, host); resp.StatusCode != want {
+			t.Errorf("CONNECT %s status = %d, want %d", host, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestHTTPProxyPAC(t *testing.T) {
+	proxy := startHTTPProxy(t)
+	resp, err := http.Get(proxy.URL + "/proxy.pac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	host := proxy.Listener.Addr().String()
+	want := `function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (dnsDomainIs(host, ".tunnel") ||
+		host == "wiki.example.com") {
+		return "PROXY ` + host + `";
+	}
+	return "DIRECT";
+}
+`
+	if string(body) != want {
+		t.Errorf("PAC =\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestHTTPProxyServiceList(t *testing.T) {
+	proxy := startHTTPProxy(t)
+	resp, err := http.Get(proxy.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{"gitlab-web.tunnel", "wiki.example.com", "/proxy.pac"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("service list does not mention %q", want)
+		}
+	}
+	if strings.Contains(string(body), "dns.tunnel") {
+		t.Error("service list includes a UDP port")
+	}
+}
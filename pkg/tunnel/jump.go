@@ -0,0 +1,95 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HopError identifies which server in a jump chain failed.
+type HopError struct {
+	Index int // 1-based position in the chain
+	Total int
+	Host  string
+	Err   error
+}
+
+func (e *HopError) Error() string {
+	if e.Index < e.Total {
+		return fmt.Sprintf("jump host %d/%d (%s): %v", e.Index, e.Total-1, e.Host, e.Err)
+	}
+	return fmt.Sprintf("target %s via %d jump host(s): %v", e.Host, e.Total-1, e.Err)
+}
+
+func (e *HopError) Unwrap() error { return e.Err }
+
+// DialVia opens an SSH connection to cfg.Host tunnelled through an
+// already connected jump host. cfg.Timeout bounds opening the channel as
+// well as the handshake.
+func DialVia(via *ssh.Client, cfg Config) (*ssh.Client, error) {
+	if cfg.HostKeyCallback == nil {
+		return nil, errors.New("no host key callback configured")
+	}
+
+	ctx := context.Background()
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	conn, err := via.DialContext(ctx, "tcp", cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(conn, cfg)
+}
+
+// DialChain connects to hops in order, each through the previous one
+// (ProxyJump); the last hop is the target. Only the first hop dials TCP
+// directly, so only its WrapConn is used. Closing the returned client
+// tears down the whole chain. Failures are reported as *HopError when
+// there is more than one hop.
+func DialChain(hops []Config) (*ssh.Client, error) {
+	if len(hops) == 0 {
+		return nil, errors.New("no hosts to dial")
+	}
+
+	client, err := Dial(hops[0])
+	if err != nil {
+		return nil, hopError(hops, 0, err)
+	}
+
+	jumps := []*ssh.Client{}
+	for i := 1; i < len(hops); i++ {
+		jumps = append(jumps, client)
+		client, err = DialVia(client, hops[i])
+		if err != nil {
+			closeAll(jumps)
+			return nil, hopError(hops, i, err)
+		}
+	}
+
+	if len(jumps) > 0 {
+		go func(target *ssh.Client) {
+			target.Wait()
+			closeAll(jumps)
+		}(client)
+	}
+	return client, nil
+}
+
+func hopError(hops []Config, i int, err error) error {
+	if len(hops) == 1 {
+		return err
+	}
+	return &HopError{Index: i + 1, Total: len(hops), Host: hops[i].Host, Err: err}
+}
+
+// closeAll closes jump host clients, innermost first.
+func closeAll(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// stallingListener accepts TCP connections and never speaks SSH.
+func stallingListener(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDialChain(t *testing.T) {
+	bastion := passwordServer(t, nil)
+	target := passwordServer(t, nil)
+
+	client, err := DialChain([]Config{
+		{Host: bastion.Addr, User: "alice", Auth: []ssh.AuthMethod{ssh.Password("secret")}, HostKeyCallback: ssh.FixedHostKey(bastion.HostKey), Timeout: 5 * time.Second},
+		{Host: target.Addr, User: "alice", Auth: []ssh.AuthMethod{ssh.Password("secret")}, HostKeyCallback: ssh.FixedHostKey(target.HostKey), Timeout: 5 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("DialChain: %v", err)
+	}
+	client.Close()
+}
+
+func TestDialChainHostKeyPerHop(t *testing.T) {
+	bastion := passwordServer(t, nil)
+	target := passwordServer(t, nil)
+
+	// The target's key must not be accepted for the bastion
+	_, err := DialChain([]Config{
+		{Host: bastion.Addr, User: "alice", Auth: []ssh.AuthMethod{ssh.Password("secret")}, HostKeyCallback: ssh.FixedHostKey(target.HostKey), Timeout: 5 * time.Second},
+		{Host: target.Addr, User: "alice", Auth: []ssh.AuthMethod{ssh.Password("secret")}, HostKeyCallback: ssh.FixedHostKey(target.HostKey), Timeout: 5 * time.Second},
+	})
+	var hop *HopError
+	if !errors.As(err, &hop) || hop.Index != 1 {
+		t.Fatalf("DialChain error = %v, want a failure at the bastion", err)
+	}
+}
+
+func TestDialStalledServerTimesOut(t *testing.T) {
+	addr := stallingListener(t)
+	start := time.Now()
+	_, err := Dial(Config{Host: addr, User: "alice", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 200 * time.Millisecond})
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Dial error = %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Dial took %s", elapsed)
+	}
+}
+
+func TestDialChainStalledTargetTimesOut(t *testing.T) {
+	bastion := passwordServer(t, nil)
+	addr := stallingListener(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := DialChain([]Config{
+			{Host: bastion.Addr, User: "alice", Auth: []ssh.AuthMethod{ssh.Password("secret")}, HostKeyCallback: ssh.FixedHostKey(bastion.HostKey), Timeout: 5 * time.Second},
+			{Host: addr, User: "alice", HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 200 * time.Millisecond},
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		var hop *HopError
+		if !errors.As(err, &hop) || hop.Index != 2 || !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("DialChain error = %v, want a deadline error at the target", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("DialChain blocked on a stalled target")
+	}
+}
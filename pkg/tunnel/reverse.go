@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+
+	"github.com/hashicorp/yamux"
+)
+
+// ReverseListener is a listener the agent holds open for a reverse
+// forward. Connections accepted on it reach the client through
+// ServeReverse.
+type ReverseListener struct {
+	ID      string
+	Address string // Bound address on the agent host
+
+	stream net.Conn
+}
+
+// Listen asks the agent to listen on address for the reverse forward id.
+// Register id with the ServeReverse dialer first: connections may arrive
+// before Listen returns.
+func Listen(session *yamux.Session, id, address string) (*ReverseListener, error) {
+	stream, err := session.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	req := protocol.ListenRequest{ID: id, Address: address}
+	msg := protocol.Message{Type: protocol.MsgTypeListen, Payload: req}
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	var resp protocol.ListenResponse
+	if err := readJSONLine(stream, &resp); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if !resp.Success {
+		stream.Close()
+		return nil, fmt.Errorf("listen %s: %s", address, resp.Error)
+	}
+	return &ReverseListener{ID: id, Address: resp.Address, stream: stream}, nil
+}
+
+// Close stops the agent listening. Connections already forwarded are not
+// affected.
+func (l *ReverseListener) Close() error {
+	return l.stream.Close()
+}
+
+// forwardedMessage is a protocol.Message carrying a ForwardedConn.
+type forwardedMessage struct {
+	Type    string                 `json:"type"`
+	Payload protocol.ForwardedConn `json:"payload"`
+}
+
+// ServeReverse accepts the streams the agent opens for reverse forwards and
+// pipes each to the local connection dial returns for its forward ID. It
+// returns when the session closes.
+func ServeReverse(session *yamux.Session, dial func(id string) (net.Conn, error)) {
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			var msg forwardedMessage
+			if err := readJSONLine(stream, &msg); err != nil || msg.Type != protocol.MsgTypeForwarded {
+				stream.Close()
+				return
+			}
+			conn, err := dial(msg.Payload.ID)
+			if err != nil {
+				stream.Close()
+				return
+			}
+			Pipe(conn, stream)
+		}()
+	}
+}
+
+// DialLocal connects to the local end of a reverse forward: "unix:///path"
+// or a TCP address.
+func DialLocal(target string) (net.Conn, error) {
+	network, address := protocol.SplitAddress(target)
+	return net.DialTimeout(network, address, 10*time.Second)
+}
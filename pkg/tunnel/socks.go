@@ -0,0 +1,414 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// SOCKS5 (RFC 1928) protocol values.
+const (
+	socksVersion          = 5
+	socksAuthNone         = 0x00
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksSucceeded          = 0x00
+	socksGeneralFailure     = 0x01
+	socksNotAllowed         = 0x02
+	socksNetworkUnreachable = 0x03
+	socksHostUnreachable    = 0x04
+	socksConnRefused        = 0x05
+	socksCmdNotSupported    = 0x07
+	socksAddrNotSupported   = 0x08
+)
+
+// socksHandshakeTimeout bounds the method negotiation and request.
+const socksHandshakeTimeout = 10 * time.Second
+
+var errSOCKSAddrType = errors.New("unsupported SOCKS address type")
+
+// ServeSOCKS runs a SOCKS5 proxy on ln. Each CONNECT request becomes a
+// stream from open(protocol.NetworkTCP, target) and each UDP ASSOCIATE
+// destination a stream from open(protocol.NetworkUDP, target), so the
+// agent's allowlist decides what can be reached. Only the no-authentication
+// method is offered: bind ln to a loopback address. ServeSOCKS returns once
+// ln is closed.
+func ServeSOCKS(ln net.Listener, open func(network, target string) (net.Conn, error)) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return // Listener closed
+		}
+		go serveSOCKSConn(conn, open)
+	}
+}
+
+func serveSOCKSConn(conn net.Conn, open func(network, target string) (net.Conn, error)) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	cmd, target, err := socksHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	if cmd == socksCmdUDPAssociate {
+		serveSOCKSUDP(conn, open)
+		return
+	}
+
+	stream, err := open(protocol.NetworkTCP, target)
+	if err != nil {
+		writeSOCKSReply(conn, socksReplyCode(err), "")
+		conn.Close()
+		return
+	}
+	if err := writeSOCKSReply(conn, socksSucceeded, ""); err != nil {
+		conn.Close()
+		stream.Close()
+		return
+	}
+	Pipe(conn, stream)
+}
+
+// socksHandshake negotiates the authentication method and reads the
+// request. Unsupported requests are answered before the error is returned.
+func socksHandshake(conn net.Conn) (cmd byte, target string, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return 0, "", err
+	}
+	if hdr[0] != socksVersion {
+		return 0, "", errors.New("not a SOCKS5 client")
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, "", err
+	}
+	if bytes.IndexByte(methods, socksAuthNone) < 0 {
+		conn.Write([]byte{socksVersion, socksAuthNoAcceptable})
+		return 0, "", errors.New("no acceptable SOCKS authentication method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksAuthNone}); err != nil {
+		return 0, "", err
+	}
+
+	var req [3]byte // VER CMD RSV
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return 0, "", err
+	}
+	if req[0] != socksVersion {
+		return 0, "", errors.New("not a SOCKS5 request")
+	}
+	target, err = readSOCKSAddr(conn)
+	if err == errSOCKSAddrType {
+		writeSOCKSReply(conn, socksAddrNotSupported, "")
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if req[1] != socksCmdConnect && req[1] != socksCmdUDPAssociate {
+		writeSOCKSReply(conn, socksCmdNotSupported, "")
+		return 0, "", errors.New("unsupported SOCKS command")
+	}
+	return req[1], target, nil
+}
+
+// readSOCKSAddr reads an ATYP-prefixed address and port as "host:port".
+func readSOCKSAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errSOCKSAddrType
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendSOCKSAddr appends addr ("host:port") in ATYP-prefixed form. Hosts
+// that are not IP literals are sent as domain names.
+func appendSOCKSAddr(b []byte, addr string) []byte {
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		b = append(b, socksAddrDomain, byte(len(host)))
+		b = append(b, host...)
+	case ip.To4() != nil:
+		b = append(b, socksAddrIPv4)
+		b = append(b, ip.To4()...)
+	default:
+		b = append(b, socksAddrIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// writeSOCKSReply sends a reply with the given code. bound defaults to
+// 0.0.0.0:0 since the agent-side address is not known to the client.
+func writeSOCKSReply(w io.Writer, code byte, bound string) error {
+	if bound == "" {
+		bound = "0.0.0.0:0"
+	}
+	_, err := w.Write(appendSOCKSAddr([]byte{socksVersion, code, 0}, bound))
+	return err
+}
+
+// socksReplyCode maps an OpenTarget error to a SOCKS reply code.
+func socksReplyCode(err error) byte {
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) {
+		return socksGeneralFailure // Session unavailable
+	}
+	switch connectErr.Code {
+	case protocol.CodeDenied, protocol.CodeQuotaExceeded:
+		return socksNotAllowed
+	case protocol.CodeNetworkUnreachable:
+		return socksNetworkUnreachable
+	case protocol.CodeHostUnreachable, protocol.CodeTimeout:
+		return socksHostUnreachable
+	case protocol.CodeRefused:
+		return socksConnRefused
+	case protocol.CodeUnsupported:
+		return socksCmdNotSupported
+	}
+	return socksGeneralFailure
+}
+
+// socksUDPQueue is how many datagrams may wait for one destination while
+// its stream is opened or busy; more are dropped, as UDP would.
+const socksUDPQueue = 64
+
+// socksUDPFailureTTL is how long datagrams to a destination whose stream
+// could not be opened (e.g. one the agent denies) are dropped before
+// another stream is tried.
+const socksUDPFailureTTL = 10 * time.Second
+
+// socksAssociation relays one UDP ASSOCIATE over an agent stream per
+// destination.
+type socksAssociation struct {
+	pc     net.PacketConn
+	open   func(network, target string) (net.Conn, error)
+	client net.Addr      // Source of the first datagram; later ones must match
+	done   chan struct{} // Closed when the association ends
+
+	mu     sync.Mutex
+	dests  map[string]*socksDest
+	failed map[string]time.Time // Destination -> until when its datagrams are dropped
+}
+
+// socksDest queues the datagrams to one destination for its relay, so a
+// slow or unreachable destination never holds up the others.
+type socksDest struct {
+	queue chan []byte
+	done  chan struct{} // Closed by drop
+	once  sync.Once
+}
+
+// serveSOCKSUDP answers a UDP ASSOCIATE with a relay socket on the address
+// the client connected to and serves it until the control connection
+// closes.
+func serveSOCKSUDP(conn net.Conn, open func(network, target string) (net.Conn, error)) {
+	defer conn.Close()
+
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		writeSOCKSReply(conn, socksCmdNotSupported, "")
+		return
+	}
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(local.IP.String(), "0"))
+	if err != nil {
+		writeSOCKSReply(conn, socksGeneralFailure, "")
+		return
+	}
+	defer pc.Close()
+	if err := writeSOCKSReply(conn, socksSucceeded, pc.LocalAddr().String()); err != nil {
+		return
+	}
+
+	// The association lasts as long as the control connection
+	go func() {
+		io.Copy(io.Discard, conn)
+		pc.Close()
+	}()
+
+	a := &socksAssociation{
+		pc:     pc,
+		open:   open,
+		done:   make(chan struct{}),
+		dests:  make(map[string]*socksDest),
+		failed: make(map[string]time.Time),
+	}
+	a.run(conn.RemoteAddr().(*net.TCPAddr).IP)
+}
+
+// run forwards client datagrams until the relay socket is closed.
+func (a *socksAssociation) run(clientIP net.IP) {
+	defer close(a.done)
+
+	buf := make([]byte, protocol.MaxDatagramSize)
+	for {
+		n, addr, err := a.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		// Only the client that opened the association may use it
+		if udpAddr, ok := addr.(*net.UDPAddr); !ok || !udpAddr.IP.Equal(clientIP) {
+			continue
+		}
+		if a.client == nil {
+			a.client = addr
+		} else if a.client.String() != addr.String() {
+			continue
+		}
+
+		// RSV RSV FRAG; fragmented datagrams are not supported
+		pkt := buf[:n]
+		if len(pkt) < 4 || pkt[0] != 0 || pkt[1] != 0 || pkt[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(pkt[3:])
+		target, err := readSOCKSAddr(r)
+		if err != nil {
+			continue
+		}
+
+		d := a.dest(target)
+		if d == nil {
+			continue // Recently failed; dropped, as UDP would
+		}
+		select {
+		case d.queue <- bytes.Clone(pkt[n-r.Len():]):
+		default: // Queue full; dropped, as UDP would
+		}
+	}
+}
+
+// dest returns the relay for target, starting it if needed, or nil while
+// opening a stream to target recently failed.
+func (a *socksAssociation) dest(target string) *socksDest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if d, ok := a.dests[target]; ok {
+		return d
+	}
+	if until, ok := a.failed[target]; ok {
+		if time.Now().Before(until) {
+			return nil
+		}
+		delete(a.failed, target)
+	}
+	d := &socksDest{queue: make(chan []byte, socksUDPQueue), done: make(chan struct{})}
+	a.dests[target] = d
+	go a.relay(target, d)
+	return d
+}
+
+// relay opens the stream to target and writes d's queued datagrams to it
+// until the stream breaks or the association ends. It is the only writer
+// of the stream.
+func (a *socksAssociation) relay(target string, d *socksDest) {
+	stream, err := a.open(protocol.NetworkUDP, target)
+	if err != nil {
+		now := time.Now()
+		a.mu.Lock()
+		for dest, until := range a.failed {
+			if now.After(until) {
+				delete(a.failed, dest)
+			}
+		}
+		a.failed[target] = now.Add(socksUDPFailureTTL)
+		a.mu.Unlock()
+		a.drop(target, d, nil)
+		return
+	}
+	defer a.drop(target, d, stream)
+	go a.readReplies(target, d, stream)
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-d.done:
+			return
+		case pkt := <-d.queue:
+			if err := protocol.WriteDatagram(stream, 1, pkt); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readReplies sends datagrams from target back to the client with a SOCKS
+// UDP header.
+func (a *socksAssociation) readReplies(target string, d *socksDest, stream net.Conn) {
+	defer a.drop(target, d, stream)
+	header := appendSOCKSAddr([]byte{0, 0, 0}, target)
+	buf := make([]byte, len(header)+protocol.MaxDatagramSize)
+	copy(buf, header)
+	for {
+		_, n, err := protocol.ReadDatagram(stream, buf[len(header):])
+		if err != nil {
+			return
+		}
+		a.pc.WriteTo(buf[:len(header)+n], a.client)
+	}
+}
+
+// drop stops d and closes its stream, if any, so the next datagram to
+// target starts a new relay.
+func (a *socksAssociation) drop(target string, d *socksDest, stream net.Conn) {
+	d.once.Do(func() { close(d.done) })
+	if stream != nil {
+		stream.Close()
+	}
+	a.mu.Lock()
+	if a.dests[target] == d {
+		delete(a.dests, target)
+	}
+	a.mu.Unlock()
+}
@@ -0,0 +1,130 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// echoUDPStream returns a stream that sends every datagram back.
+func echoUDPStream() net.Conn {
+	client, agent := net.Pipe()
+	go func() {
+		defer agent.Close()
+		buf := make([]byte, protocol.MaxDatagramSize)
+		for {
+			flow, n, err := protocol.ReadDatagram(agent, buf)
+			if err != nil {
+				return
+			}
+			if err := protocol.WriteDatagram(agent, flow, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	return client
+}
+
+// socksUDPClient performs a UDP ASSOCIATE on the proxy at addr and returns
+// the control connection and a UDP socket connected to the relay.
+func socksUDPClient(t *testing.T, addr string) (net.Conn, net.Conn) {
+	t.Helper()
+	ctrl, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ctrl.Close() })
+	ctrl.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := []byte{socksVersion, 1, socksAuthNone}
+	req = appendSOCKSAddr(append(req, socksVersion, socksCmdUDPAssociate, 0), "0.0.0.0:0")
+	if _, err := ctrl.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	var reply [5]byte // VER METHOD, then VER REP RSV
+	if _, err := io.ReadFull(ctrl, reply[:]); err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != socksSucceeded {
+		t.Fatalf("UDP ASSOCIATE reply %d", reply[3])
+	}
+	relay, err := readSOCKSAddr(ctrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udp, err := net.Dial("udp", relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	return ctrl, udp
+}
+
+func sendSOCKSUDP(t *testing.T, udp net.Conn, target string, payload []byte) {
+	t.Helper()
+	pkt := append(appendSOCKSAddr([]byte{0, 0, 0}, target), payload...)
+	if _, err := udp.Write(pkt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSOCKSUDPSlowDestinationDoesNotBlockOthers(t *testing.T) {
+	const (
+		slow   = "10.0.0.1:53"
+		denied = "10.0.0.2:53"
+		echo   = "10.0.0.3:53"
+	)
+	release := make(chan struct{})
+	var deniedOpens atomic.Int32
+	open := func(network, target string) (net.Conn, error) {
+		if network != protocol.NetworkUDP {
+			return nil, errors.New("unexpected network")
+		}
+		switch target {
+		case slow:
+			<-release // An agent that never answers
+			return nil, errors.New("timed out")
+		case denied:
+			deniedOpens.Add(1)
+			return nil, &ConnectError{Code: protocol.CodeDenied, Message: "denied"}
+		}
+		return echoUDPStream(), nil
+	}
+	defer close(release)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ServeSOCKS(ln, open)
+
+	_, udp := socksUDPClient(t, ln.Addr().String())
+	sendSOCKSUDP(t, udp, slow, []byte("stuck"))
+	for i := 0; i < 5; i++ {
+		sendSOCKSUDP(t, udp, denied, []byte("nope"))
+	}
+	sendSOCKSUDP(t, udp, echo, []byte("hello"))
+
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := udp.Read(buf)
+	if err != nil {
+		t.Fatalf("no reply from %s while %s stalls: %v", echo, slow, err)
+	}
+	want := append(appendSOCKSAddr([]byte{0, 0, 0}, echo), "hello"...)
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("reply = %q, want %q", buf[:n], want)
+	}
+
+	if got := deniedOpens.Load(); got != 1 {
+		t.Errorf("denied destination opened %d times, want 1", got)
+	}
+}
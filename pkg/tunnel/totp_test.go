@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B test vectors for HMAC-SHA1, truncated to the six
+// digits authenticator apps use. The secret is "12345678901234567890".
+func TestTOTPRFC6238(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTP(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTP at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTP at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPSecretFormatting(t *testing.T) {
+	at := time.Unix(59, 0)
+	for _, secret := range []string{
+		"gezdgnbvgy3tqojqgezdgnbvgy3tqojq",
+		" GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ ",
+		"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ====",
+	} {
+		got, err := TOTP(secret, at)
+		if err != nil {
+			t.Errorf("TOTP(%q): %v", secret, err)
+			continue
+		}
+		if got != "287082" {
+			t.Errorf("TOTP(%q) = %s, want 287082", secret, got)
+		}
+	}
+
+	if _, err := TOTP("not base32!", at); err == nil {
+		t.Error("TOTP accepted an invalid secret")
+	}
+}
@@ -0,0 +1,389 @@
+// Package tunnel implements the client side of the forwarding protocol:
+// dialing SSH, launching server-agent over stdio and opening yamux streams
+// to allowed targets.
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+
+	"github.com/hashicorp/yamux"
+	"golang.org/x/crypto/ssh"
+)
+
+// ProtocolVersion is sent in the handshake request.
+const ProtocolVersion = "2.0"
+
+// maxControlLine bounds the size of a JSON control message read from a stream.
+const maxControlLine = 64 * 1024
+
+// DefaultAgentPath is the remote agent command used when none is configured.
+const DefaultAgentPath = "./server-agent"
+
+// Config describes how to reach and authenticate against the SSH server.
+type Config struct {
+	Host              string
+	User              string
+	Auth              []ssh.AuthMethod
+	HostKeyCallback   ssh.HostKeyCallback
+	HostKeyAlgorithms []string
+	Timeout           time.Duration
+
+	// WrapConn optionally wraps the raw TCP connection (e.g. for byte counting).
+	WrapConn func(net.Conn) net.Conn
+}
+
+// Dial opens the TCP connection and performs the SSH handshake and auth.
+func Dial(cfg Config) (*ssh.Client, error) {
+	if cfg.HostKeyCallback == nil {
+		return nil, errors.New("no host key callback configured")
+	}
+
+	conn, err := net.DialTimeout("tcp", cfg.Host, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WrapConn != nil {
+		conn = cfg.WrapConn(conn)
+	}
+	return newClient(conn, cfg)
+}
+
+// newClient runs the SSH handshake over an established connection.
+//
+// cfg.Timeout also bounds the version and key exchange, so a server that
+// accepts the connection and then stalls cannot block forever. Channels
+// through a jump host support no deadlines, so the connection is closed
+// when the timer fires instead. The timer stops once the host key has been
+// presented, as auth may wait on the user answering prompts.
+func newClient(conn net.Conn, cfg Config) (*ssh.Client, error) {
+	var timedOut atomic.Bool
+	stop := func() bool { return true }
+	if cfg.Timeout > 0 {
+		timer := time.AfterFunc(cfg.Timeout, func() {
+			timedOut.Store(true)
+			conn.Close()
+		})
+		stop = timer.Stop
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User: cfg.User,
+		Auth: cfg.Auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			stop()
+			return cfg.HostKeyCallback(hostname, remote, key)
+		},
+		HostKeyAlgorithms: cfg.HostKeyAlgorithms,
+		Timeout:           cfg.Timeout,
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, cfg.Host, clientConfig)
+	stop()
+	if err != nil {
+		conn.Close()
+		if timedOut.Load() {
+			return nil, fmt.Errorf("ssh handshake with %s: %w", cfg.Host, os.ErrDeadlineExceeded)
+		}
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// IsAuthError reports whether err was caused by the server rejecting every
+// offered authentication method, by an unanswered auth challenge or by a
+// listen mode agent refusing the token.
+func IsAuthError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "unable to authenticate") ||
+		errors.Is(err, ErrChallengeFailed) || errors.Is(err, ErrTokenRejected))
+}
+
+// Agent is a server-agent process running on the remote host, with a
+// yamux client session layered over its stdin/stdout.
+type Agent struct {
+	Session *yamux.Session
+
+	ssh     *ssh.Session
+	exited  chan struct{}
+	exitErr error
+}
+
+// StartAgent launches server-agent on the remote host. Agent stderr is
+// copied to stderr if non-nil.
+func StartAgent(client *ssh.Client, agentPath string, stderr io.Writer) (*Agent, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if stderr != nil {
+		agentStderr, err := session.StderrPipe()
+		if err != nil {
+			session.Close()
+			return nil, err
+		}
+		go io.Copy(stderr, agentStderr)
+	}
+
+	if agentPath == "" {
+		agentPath = DefaultAgentPath
+	}
+	if err := session.Start(fmt.Sprintf("%s --stdio", agentPath)); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	cfg := yamux.DefaultConfig()
+	cfg.EnableKeepAlive = true
+	cfg.LogOutput = io.Discard
+	ysess, err := yamux.Client(&stdioRWC{Reader: stdout, WriteCloser: stdin}, cfg)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	a := &Agent{Session: ysess, ssh: session, exited: make(chan struct{})}
+	go func() {
+		a.exitErr = session.Wait()
+		close(a.exited)
+		ysess.Close()
+	}()
+	return a, nil
+}
+
+// Exited is closed once the remote agent process has terminated.
+func (a *Agent) Exited() <-chan struct{} {
+	return a.exited
+}
+
+// ExitErr describes how the agent terminated. Only valid after Exited is closed.
+func (a *Agent) ExitErr() error {
+	if a.exitErr == nil {
+		return errors.New("server-agent exited")
+	}
+	return fmt.Errorf("server-agent exited: %w", a.exitErr)
+}
+
+// Close tears down the yamux session and the remote agent process.
+func (a *Agent) Close() error {
+	a.Session.Close()
+	return a.ssh.Close()
+}
+
+// Handshake asks the agent for its configuration.
+func Handshake(session *yamux.Session) (*protocol.HandshakeResponse, error) {
+	stream, err := session.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	req := protocol.HandshakeRequest{Version: ProtocolVersion}
+	msg := protocol.Message{Type: protocol.MsgTypeHandshake, Payload: req}
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		return nil, err
+	}
+
+	var resp protocol.HandshakeResponse
+	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("Server Error: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// WatchConfig subscribes to the agent's configuration and calls update with
+// each version it pushes, the current one first and then one after every
+// reload. It returns when the stream or session closes; agents without
+// hot reload close the stream at once.
+func WatchConfig(session *yamux.Session, update func(*protocol.HandshakeResponse)) error {
+	stream, err := session.Open()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	msg := protocol.Message{Type: protocol.MsgTypeWatch}
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var resp protocol.HandshakeResponse
+		if err := decoder.Decode(&resp); err != nil {
+			return err
+		}
+		update(&resp)
+	}
+}
+
+// OpenTarget opens a stream and asks the agent to connect it to target.
+// On success the returned stream carries the raw target traffic.
+func OpenTarget(session *yamux.Session, target string) (net.Conn, error) {
+	return openStream(session, protocol.ConnectRequest{Target: target})
+}
+
+// OpenUDP opens a stream relaying datagrams to a UDP target. On success
+// the stream carries protocol datagram frames; see ServeUDP.
+func OpenUDP(session *yamux.Session, target string) (net.Conn, error) {
+	return openStream(session, protocol.ConnectRequest{Target: target, Network: protocol.NetworkUDP})
+}
+
+// OpenNetwork opens a stream to target over network, protocol.NetworkTCP or
+// protocol.NetworkUDP, as OpenTarget or OpenUDP would.
+func OpenNetwork(session *yamux.Session, network, target string) (net.Conn, error) {
+	return openStream(session, protocol.ConnectRequest{Target: target, Network: network})
+}
+
+func openStream(session *yamux.Session, req protocol.ConnectRequest) (net.Conn, error) {
+	stream, err := session.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := protocol.Message{Type: protocol.MsgTypeConnect, Payload: req}
+	if err := json.NewEncoder(stream).Encode(msg); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	var resp protocol.ConnectResponse
+	if err := readJSONLine(stream, &resp); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if !resp.Success {
+		stream.Close()
+		return nil, &ConnectError{Target: req.Target, Code: resp.Code, Message: resp.Error}
+	}
+	return stream, nil
+}
+
+// ConnectError is returned by OpenTarget and OpenUDP when the agent denies
+// the target or fails to reach it.
+type ConnectError struct {
+	Target  string
+	Code    string // protocol.Code* value, empty if unclassified
+	Message string
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("connect %s: %s", e.Target, e.Message)
+}
+
+// Retryable reports whether the agent refused for lack of capacity, so the
+// same request may succeed later.
+func (e *ConnectError) Retryable() bool {
+	return e.Code == protocol.CodeCapacityExceeded
+}
+
+// retryBackoff is the delay before the first retry of Retry; it doubles
+// with each attempt.
+const retryBackoff = 500 * time.Millisecond
+
+// Retry calls open up to attempts times while it fails with a Retryable
+// ConnectError, backing off between attempts, and returns the last result.
+func Retry(attempts int, open func() (net.Conn, error)) (net.Conn, error) {
+	delay := retryBackoff
+	for i := 1; ; i++ {
+		conn, err := open()
+		var connectErr *ConnectError
+		if err == nil || i >= attempts || !errors.As(err, &connectErr) || !connectErr.Retryable() {
+			return conn, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Pipe copies data between a and b in both directions and returns once
+// either side is done. Both connections are closed on return.
+func Pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+type stdioRWC struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (c *stdioRWC) Close() error { return c.WriteCloser.Close() }
+
+// readJSONLine reads a single newline-terminated JSON value one byte at a
+// time, so no target data following it is consumed from r.
+func readJSONLine(r io.Reader, v any) error {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+		if len(line) > maxControlLine {
+			return fmt.Errorf("control message exceeds %d bytes", maxControlLine)
+		}
+	}
+	return json.Unmarshal(line, v)
+}
+
+// ListenLocal binds the local end of a forward: "unix:///path" for a Unix
+// socket, readable only by the current user, or a TCP address. A socket
+// file left behind by a previous run is replaced, one still in use is not.
+func ListenLocal(addr string) (net.Listener, error) {
+	path, ok := protocol.UnixPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0600)
+	return ln, nil
+}
@@ -0,0 +1,158 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"ssh-forwarder/pkg/protocol"
+)
+
+// UDPFlowTimeout is how long a local UDP source keeps its flow ID without
+// traffic. The agent expires its side of a flow on its own timer; a later
+// packet simply opens a new socket there.
+const UDPFlowTimeout = 2 * time.Minute
+
+// udpForwarder relays datagrams between a local UDP socket and one agent
+// stream, tracking a flow per local source address.
+type udpForwarder struct {
+	pc   net.PacketConn
+	open func() (net.Conn, error)
+
+	mu       sync.Mutex
+	stream   net.Conn
+	nextFlow uint32
+	bySource map[string]*udpSource
+	byFlow   map[uint32]*udpSource
+}
+
+type udpSource struct {
+	flow       uint32
+	addr       net.Addr
+	lastActive time.Time
+}
+
+// ServeUDP forwards datagrams received on pc through a stream returned by
+// open (typically OpenUDP) and sends the replies back to their sources. The
+// stream is opened on the first packet and reopened if it breaks. ServeUDP
+// returns once pc is closed.
+func ServeUDP(pc net.PacketConn, open func() (net.Conn, error)) {
+	f := &udpForwarder{
+		pc:       pc,
+		open:     open,
+		bySource: make(map[string]*udpSource),
+		byFlow:   make(map[uint32]*udpSource),
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		f.mu.Lock()
+		if f.stream != nil {
+			f.stream.Close()
+		}
+		f.mu.Unlock()
+	}()
+	go f.expire(done)
+
+	buf := make([]byte, protocol.MaxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		stream, flow, err := f.route(addr)
+		if err != nil {
+			continue // Dropped, as UDP would
+		}
+		// Only this loop writes to the stream
+		if err := protocol.WriteDatagram(stream, flow, buf[:n]); err != nil {
+			f.dropStream(stream)
+		}
+	}
+}
+
+// route returns the stream to send on, opening it if needed, and the flow
+// ID for addr.
+func (f *udpForwarder) route(addr net.Addr) (net.Conn, uint32, error) {
+	f.mu.Lock()
+	stream := f.stream
+	f.mu.Unlock()
+	if stream == nil {
+		// Open without holding mu, as it waits on the agent. Only the read
+		// loop opens streams, so no other one can be installed meanwhile
+		var err error
+		if stream, err = f.open(); err != nil {
+			return nil, 0, err
+		}
+		f.mu.Lock()
+		f.stream = stream
+		f.mu.Unlock()
+		go f.readReplies(stream)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	src, ok := f.bySource[addr.String()]
+	if !ok {
+		f.nextFlow++
+		src = &udpSource{flow: f.nextFlow, addr: addr}
+		f.bySource[addr.String()] = src
+		f.byFlow[src.flow] = src
+	}
+	src.lastActive = time.Now()
+	return stream, src.flow, nil
+}
+
+// readReplies delivers datagrams from the agent to their local sources.
+func (f *udpForwarder) readReplies(stream net.Conn) {
+	defer f.dropStream(stream)
+	buf := make([]byte, protocol.MaxDatagramSize)
+	for {
+		flow, n, err := protocol.ReadDatagram(stream, buf)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		src, ok := f.byFlow[flow]
+		if ok {
+			src.lastActive = time.Now()
+		}
+		f.mu.Unlock()
+		if ok {
+			f.pc.WriteTo(buf[:n], src.addr)
+		}
+	}
+}
+
+// dropStream closes stream so the next packet opens a new one.
+func (f *udpForwarder) dropStream(stream net.Conn) {
+	stream.Close()
+	f.mu.Lock()
+	if f.stream == stream {
+		f.stream = nil
+	}
+	f.mu.Unlock()
+}
+
+// expire forgets sources idle for longer than UDPFlowTimeout.
+func (f *udpForwarder) expire(done chan struct{}) {
+	ticker := time.NewTicker(UDPFlowTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-UDPFlowTimeout)
+		f.mu.Lock()
+		for key, src := range f.bySource {
+			if src.lastActive.Before(cutoff) {
+				delete(f.bySource, key)
+				delete(f.byFlow, src.flow)
+			}
+		}
+		f.mu.Unlock()
+	}
+}